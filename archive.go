@@ -0,0 +1,121 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/chriskirkland/go-utils/sloc"
+)
+
+// isArchive reports whether a target is an archive whose files are counted
+func isArchive(target string) bool {
+	name := strings.ToLower(target)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// processArchive counts the source files inside a zip or tar archive as
+// they're read, without extracting it. They're named archive//path.
+func processArchive(ctx context.Context, archive string, out collector) error {
+	if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+		return processZip(ctx, archive, out)
+	}
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := io.Reader(file)
+	if name := strings.ToLower(archive); strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("%s: %v", archive, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", archive, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		countArchived(archive, hdr.Name, hdr.Size, tr, out)
+	}
+}
+
+func processZip(ctx context.Context, archive string, out collector) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("%s: %v", archive, err)
+	}
+	defer zr.Close()
+	return processFS(ctx, zr, archive, out)
+}
+
+// processFS counts the source files in fsys as those of archive
+func processFS(ctx context.Context, fsys fs.FS, archive string, out collector) error {
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("%s: %v", archive, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			out.send(fileLines{filename: archive + "//" + name}, err)
+			return nil
+		}
+		r, err := fsys.Open(name)
+		if err != nil {
+			out.send(fileLines{filename: archive + "//" + name}, err)
+			return nil
+		}
+		defer r.Close()
+		countArchived(archive, name, info.Size(), r, out)
+		return nil
+	})
+}
+
+// countArchived counts one file of an archive, if a walk would count it
+func countArchived(archive, name string, size int64, r io.Reader, out collector) {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	if !countsPath(name) {
+		return
+	}
+	filename := archive + "//" + name
+	if maxFileSize > 0 && size > int64(maxFileSize) {
+		out.send(fileLines{filename: filename}, fmt.Errorf("%w (%s)", errFileTooLarge, formatSize(size)))
+		return
+	}
+	log.Debug("archiveProcessor", filename)
+	out.send(countLinesAs(filename, sloc.LanguageFor(name), r))
+}
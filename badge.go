@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+)
+
+// badgeColors are the named colors --badge-color takes, as shields.io
+// names them; anything else is used as given, e.g. #4c1
+var badgeColors = map[string]string{
+	"brightgreen": "#4c1",
+	"green":       "#97ca00",
+	"yellow":      "#dfb317",
+	"orange":      "#fe7d37",
+	"red":         "#e05d44",
+	"blue":        "#007ec6",
+	"grey":        "#555",
+}
+
+// newBadgeSink writes a shields.io style badge of the code total to path,
+// such as "Go code | 42.3k lines", naming the language when there's only one
+func newBadgeSink(path, color string) reportSink {
+	return func(target string, files []fileLines, total fileLines) error {
+		return os.WriteFile(path, []byte(codeBadge(files, total, color)), 0644)
+	}
+}
+
+// codeBadge is the badge of the files' code total, in color or the
+// shields.io color it names
+func codeBadge(files []fileLines, total fileLines, color string) string {
+	label := "code"
+	if langs := languageTotals(files); len(langs) == 1 {
+		for name := range langs {
+			label = name + " code"
+		}
+	}
+	value := compactCount(total.codeLines) + " lines"
+	if c, ok := badgeColors[color]; ok {
+		color = c
+	}
+	return badgeSVG(label, value, color)
+}
+
+// badgeSVG lays out a flat two-part badge. Text widths are estimated from
+// Verdana's average advance at 11px, near enough for short labels.
+func badgeSVG(label, value, color string) string {
+	textWidth := func(s string) int { return len([]rune(s))*7 + 10 }
+	lw, vw := textWidth(label), textWidth(value)
+	label, value, color = html.EscapeString(label), html.EscapeString(value), html.EscapeString(color)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+  <title>%[4]s: %[5]s</title>
+  <linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+  <clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+  <g clip-path="url(#r)">
+    <rect width="%[2]d" height="20" fill="#555"/>
+    <rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/>
+    <rect width="%[1]d" height="20" fill="url(#s)"/>
+  </g>
+  <g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+    <text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text>
+    <text x="%[7]d" y="14">%[4]s</text>
+    <text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[5]s</text>
+    <text x="%[8]d" y="14">%[5]s</text>
+  </g>
+</svg>
+`, lw+vw, lw, vw, label, value, color, lw/2, lw+vw/2)
+}
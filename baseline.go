@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// baseline is the code lines per package a check holds the tree to
+type baseline struct {
+	Written  time.Time      `json:"written"`
+	Packages map[string]int `json:"packages"`
+}
+
+// runBaseline writes or checks a baseline, failing the check with
+// exitFailedCheck when a package grew past it
+func runBaseline(args []string) error {
+	flags := flag.NewFlagSet("baseline", flag.ContinueOnError)
+	allow := flags.Int("allow", 0, "code lines a package may grow past its baseline")
+	ratchet := flags.Bool("ratchet", false, "on a passing check, lower the baseline to packages that shrank")
+	if len(args) < 2 || args[0] != "write" && args[0] != "check" {
+		return usageErrorf("usage: sloc baseline write|check [-allow n] [-ratchet] <baseline.json> [path...]")
+	}
+	mode := args[0]
+	if err := parseFlags(flags, args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return usageErrorf("usage: sloc baseline %s <baseline.json> [path...]", mode)
+	}
+	path, targets := flags.Arg(0), flags.Args()[1:]
+	if len(targets) == 0 {
+		targets = []string{"."}
+	}
+
+	current := packageCode(targets)
+	if mode == "write" {
+		return writeBaseline(path, current)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var base baseline
+	if err := json.Unmarshal(data, &base); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	over := checkBaseline(reportOutput, base.Packages, current, *allow)
+	if over > 0 {
+		log.Errorf("%d packages grew past %s", over, path)
+		return exitStatus(exitFailedCheck)
+	}
+	if *ratchet {
+		lowered := false
+		for pkg, code := range base.Packages {
+			if now := current[pkg]; now < code {
+				base.Packages[pkg] = now
+				lowered = true
+			}
+		}
+		if lowered {
+			return writeBaseline(path, base.Packages)
+		}
+	}
+	return nil
+}
+
+// packageCode totals the code lines of each directory under the targets
+func packageCode(targets []string) map[string]int {
+	res := map[string]int{}
+	for _, target := range targets {
+		files, _ := countTree(target)
+		if !includeGenerated {
+			files, _ = withoutGenerated(files)
+		}
+		for _, f := range files {
+			res[filepath.ToSlash(filepath.Dir(f.filename))] += f.codeLines
+		}
+	}
+	return res
+}
+
+func writeBaseline(path string, packages map[string]int) error {
+	data, err := json.MarshalIndent(baseline{Written: time.Now().UTC(), Packages: packages}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// checkBaseline writes a table of the packages that grew past their
+// baseline plus allow, new packages included, and returns how many did
+func checkBaseline(w io.Writer, base, current map[string]int, allow int) int {
+	var over []string
+	for pkg, code := range current {
+		if code > base[pkg]+allow {
+			over = append(over, pkg)
+		}
+	}
+	if len(over) == 0 {
+		return 0
+	}
+	sort.Strings(over)
+
+	fmt.Fprintln(w)
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Package", "Baseline", "Code", "Over"})
+	table.SetBorder(false)
+	for _, pkg := range over {
+		baseline := "new"
+		if code, ok := base[pkg]; ok {
+			baseline = tableCount(code)
+		}
+		table.Append([]string{displayPath(pkg), baseline, tableCount(current[pkg]), tableCount(current[pkg] - base[pkg] - allow)})
+	}
+	table.Render()
+	return len(over)
+}
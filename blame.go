@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chriskirkland/go-utils/sloc"
+	"github.com/olekukonko/tablewriter"
+)
+
+// with --by-author, lines are attributed to whoever last changed them
+var byAuthor bool
+
+// with --by-age, code lines are bucketed by when they last changed
+var byAge bool
+
+// ageBuckets are the --by-age columns, each holding code last changed less
+// than its limit ago; uncommitted lines are the newest
+var ageBuckets = []struct {
+	key, title string
+	limit      time.Duration
+}{
+	{"under_3_months", "< 3 months", 91 * 24 * time.Hour},
+	{"3_to_12_months", "3-12 months", 365 * 24 * time.Hour},
+	{"1_to_3_years", "1-3 years", 3 * 365 * 24 * time.Hour},
+	{"over_3_years", "> 3 years", 1<<63 - 1},
+}
+
+// blameLine is who last changed a line, and when
+type blameLine struct {
+	author string
+	time   time.Time
+}
+
+// blameFile runs git blame on path, returning a line's author by line
+// number from 1. Lines not yet committed are git's "Not Committed Yet".
+func blameFile(path string) (map[int]blameLine, error) {
+	out, err := exec.Command("git", "-C", filepath.Dir(path), "blame", "--line-porcelain", "--", filepath.Base(path)).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[int]blameLine{}
+	var line int
+	var cur blameLine
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		text := scanner.Text()
+		switch {
+		case strings.HasPrefix(text, "\t"):
+			// the line itself ends each entry
+			res[line] = cur
+		case strings.HasPrefix(text, "author "):
+			cur.author = strings.TrimPrefix(text, "author ")
+		case strings.HasPrefix(text, "author-time "):
+			secs, _ := strconv.ParseInt(strings.TrimPrefix(text, "author-time "), 10, 64)
+			cur.time = time.Unix(secs, 0)
+		default:
+			// <commit> <original line> <final line> [<lines in group>]
+			if fields := strings.Fields(text); len(fields) >= 3 && len(fields[0]) >= 40 {
+				line, _ = strconv.Atoi(fields[2])
+			}
+		}
+	}
+	return res, scanner.Err()
+}
+
+// fileLineKinds classifies each of a file's lines as counting does; mixed
+// lines are code, and licenses and directives stay comments
+func fileLineKinds(path string) ([]sloc.LineKind, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, _, _ := sloc.DecodeSource(bufio.NewReader(bytes.NewReader(src)))
+	if sample, _ := reader.Peek(sloc.SniffLen); sloc.LooksBinary(sample) {
+		return nil, sloc.ErrBinaryFile
+	}
+	lang := sloc.LanguageFor(path)
+	if lang == nil {
+		lang = sloc.SniffLanguage(path)
+	}
+	if lang == nil {
+		lang = sloc.Go
+	}
+
+	var scanned []sloc.LineKind
+	var cgo map[int]sloc.LineKind
+	if lang == sloc.Go {
+		decoded, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		if goMode == "scanner" {
+			if kinds, ok := sloc.ScanGoLines(decoded); ok {
+				scanned = kinds
+			}
+		}
+		cgo = sloc.CgoLines(decoded)
+		reader = bufio.NewReader(bytes.NewReader(decoded))
+	}
+
+	// the lines are read either way, as the scanner's kinds run on past the
+	// last line ending
+	var kinds []sloc.LineKind
+	c := sloc.NewClassifier(lang)
+	for line := 0; ; line++ {
+		raw, _, _, err := sloc.ReadLine(reader, maxLineBytes)
+		if err == io.EOF {
+			return kinds, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var kind sloc.LineKind
+		if scanned != nil {
+			kind = scanned[line]
+		} else {
+			kind = c.Classify(string(raw))
+		}
+		kind = sloc.BlankInComment(raw, kind, scanned == nil && c.InBlockComment(), blankCommentMode)
+		if cKind, ok := cgo[line]; ok {
+			kind = cKind
+		}
+		kinds = append(kinds, kind)
+	}
+}
+
+// blameLines classifies each counted file's lines and hands them, with who
+// last changed them, to fn. Files are blamed by --jobs workers at once.
+func blameLines(files []fileLines, fn func(f fileLines, kinds []sloc.LineKind, blame map[int]blameLine)) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(jobs, 1))
+	for _, f := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			kinds, err := fileLineKinds(f.filename)
+			if err != nil {
+				log.Debugf("%s: %v", f.filename, err)
+				return
+			}
+			blame, err := blameFile(f.filename)
+			if err != nil {
+				log.Debugf("%s: git blame: %v", f.filename, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			fn(f, kinds, blame)
+		}()
+	}
+	wg.Wait()
+}
+
+// addKind counts a line of the given kind into f
+func addKind(f *fileLines, kind sloc.LineKind) {
+	switch {
+	case kind&sloc.CodeLine != 0:
+		f.codeLines++
+	case kind&sloc.CommentLine != 0:
+		f.commentLines++
+	default:
+		f.whitespaceLines++
+	}
+}
+
+// blameRenderer renders a row per author instead of per file, the most code
+// first; files git doesn't track are "(untracked)"
+func blameRenderer(render renderer) renderer {
+	return func(w io.Writer, files []fileLines, _ fileLines) error {
+		authors := map[string]*fileLines{}
+		blameLines(files, func(f fileLines, kinds []sloc.LineKind, blame map[int]blameLine) {
+			touched := map[string]bool{}
+			for i, kind := range kinds {
+				author := "(untracked)"
+				if b, ok := blame[i+1]; ok {
+					author = b.author
+				}
+				if authors[author] == nil {
+					authors[author] = &fileLines{filename: author}
+				}
+				addKind(authors[author], kind)
+				if !touched[author] {
+					touched[author] = true
+					authors[author].files++
+				}
+			}
+		})
+
+		total := fileLines{filename: "TOTAL"}
+		var rows []fileLines
+		for _, a := range authors {
+			rows = append(rows, *a)
+			total.join(*a)
+		}
+		sort.Slice(rows, func(i, j int) bool {
+			if rows[i].codeLines != rows[j].codeLines {
+				return rows[i].codeLines > rows[j].codeLines
+			}
+			return rows[i].filename < rows[j].filename
+		})
+		return render(w, rows, total)
+	}
+}
+
+// ageRenderer writes a row per directory, or per dir:N with --group-by, of
+// how many code lines fall in each age bucket
+func ageRenderer(format string) (renderer, error) {
+	if format != "table" && format != "csv" && format != "json" {
+		return nil, fmt.Errorf("--by-age writes table, csv or json, not %s", format)
+	}
+	return func(w io.Writer, files []fileLines, _ fileLines) error {
+		now := time.Now()
+		dirs := map[string][]int{}
+		total := make([]int, len(ageBuckets))
+		blameLines(files, func(f fileLines, kinds []sloc.LineKind, blame map[int]blameLine) {
+			dir := groupKeys["dir"](f)
+			if dirs[dir] == nil {
+				dirs[dir] = make([]int, len(ageBuckets))
+			}
+			for i, kind := range kinds {
+				if kind&sloc.CodeLine == 0 {
+					continue
+				}
+				age := time.Duration(0)
+				if b, ok := blame[i+1]; ok && b.author != "Not Committed Yet" {
+					age = now.Sub(b.time)
+				}
+				for j, bucket := range ageBuckets {
+					if age < bucket.limit {
+						dirs[dir][j]++
+						total[j]++
+						break
+					}
+				}
+			}
+		})
+
+		var names []string
+		for name := range dirs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		row := func(name string, counts []int, count func(int) string) []string {
+			res := []string{name}
+			for _, n := range counts {
+				res = append(res, count(n))
+			}
+			return res
+		}
+
+		switch format {
+		case "json":
+			type ageRow struct {
+				Directory string         `json:"directory"`
+				Code      map[string]int `json:"code"`
+			}
+			jsonRow := func(name string, counts []int) ageRow {
+				res := ageRow{Directory: name, Code: map[string]int{}}
+				for j, n := range counts {
+					res.Code[ageBuckets[j].key] = n
+				}
+				return res
+			}
+			report := struct {
+				Directories []ageRow `json:"directories"`
+				Total       ageRow   `json:"total"`
+			}{Directories: []ageRow{}, Total: jsonRow("TOTAL", total)}
+			for _, name := range names {
+				report.Directories = append(report.Directories, jsonRow(name, dirs[name]))
+			}
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(report)
+		case "csv":
+			cw := csv.NewWriter(w)
+			header := []string{"directory"}
+			for _, bucket := range ageBuckets {
+				header = append(header, bucket.key)
+			}
+			cw.Write(header)
+			for _, name := range names {
+				cw.Write(row(name, dirs[name], strconv.Itoa))
+			}
+			cw.Write(row("TOTAL", total, strconv.Itoa))
+			cw.Flush()
+			return cw.Error()
+		}
+
+		fmt.Fprintln(w)
+		table := tablewriter.NewWriter(w)
+		header := []string{"Directory"}
+		for _, bucket := range ageBuckets {
+			header = append(header, bucket.title)
+		}
+		table.SetHeader(header)
+		table.SetFooter(row("TOTAL", total, tableCount))
+		table.SetBorder(false)
+		for _, name := range names {
+			table.Append(row(displayPath(name), dirs[name], tableCount))
+		}
+		table.Render()
+		return nil
+	}, nil
+}
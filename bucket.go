@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"google.golang.org/api/iterator"
+)
+
+// objectStore is a bucket backend that source files are streamed from
+type objectStore interface {
+	list(ctx context.Context, prefix string, fn func(key string, size int64) error) error
+	open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+func isBucketURL(path string) bool {
+	return strings.HasPrefix(path, "s3://") || strings.HasPrefix(path, "gs://")
+}
+
+func newObjectStore(ctx context.Context, u *url.URL) (objectStore, error) {
+	switch u.Scheme {
+	case "s3":
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &s3Store{client: s3.NewFromConfig(cfg), bucket: u.Host}, nil
+	case "gs":
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &gcsStore{bucket: client.Bucket(u.Host)}, nil
+	}
+	return nil, fmt.Errorf("unsupported bucket scheme %q", u.Scheme)
+}
+
+type s3Store struct {
+	client *s3.Client
+	bucket string
+}
+
+func (this *s3Store) list(ctx context.Context, prefix string, fn func(key string, size int64) error) error {
+	pages := s3.NewListObjectsV2Paginator(this.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(this.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Contents {
+			if err := fn(aws.ToString(obj.Key), aws.ToInt64(obj.Size)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (this *s3Store) open(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := this.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(this.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return obj.Body, nil
+}
+
+type gcsStore struct {
+	bucket *storage.BucketHandle
+}
+
+func (this *gcsStore) list(ctx context.Context, prefix string, fn func(key string, size int64) error) error {
+	it := this.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(attrs.Name, attrs.Size); err != nil {
+			return err
+		}
+	}
+}
+
+func (this *gcsStore) open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return this.bucket.Object(key).NewReader(ctx)
+}
+
+func processBucket(ctx context.Context, target string, workers int, out collector) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+
+	store, err := newObjectStore(ctx, u)
+	if err != nil {
+		return err
+	}
+
+	// download and count objects concurrently
+	keys := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				// once cancelled, drop what's queued rather than fail it
+				if ctx.Err() != nil {
+					continue
+				}
+				name := u.Scheme + "://" + u.Host + "/" + key
+				body, err := store.open(ctx, key)
+				if err != nil {
+					out.send(fileLines{filename: name}, err)
+					continue
+				}
+				res, err := countLines(name, body)
+				body.Close()
+				out.send(res, err)
+			}
+		}()
+	}
+
+	// list objects under the prefix, ignoring those a walk wouldn't count,
+	// the filters matching keys below the prefix as they do paths below a
+	// target
+	prefix := strings.TrimPrefix(u.Path, "/")
+	err = store.list(ctx, prefix, func(key string, size int64) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !countsPath(strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")) {
+			log.Debug("ignoring", key)
+			return nil
+		}
+		if maxFileSize > 0 && size > int64(maxFileSize) {
+			name := u.Scheme + "://" + u.Host + "/" + key
+			out.send(fileLines{filename: name}, fmt.Errorf("%w (%s)", errFileTooLarge, formatSize(size)))
+			return nil
+		}
+		keys <- key
+		return nil
+	})
+	close(keys)
+	wg.Wait()
+	return err
+}
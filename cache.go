@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chriskirkland/go-utils/sloc"
+)
+
+// cacheEntry is a file's counts as of its size and modification time
+type cacheEntry struct {
+	Size    int64
+	ModTime time.Time
+
+	Language                  string
+	Code, Comment, Blank      int
+	Mixed, License, Directive int
+	LongLines, Cgo            int
+	BOM, Generated            bool
+	Encoding, LineEnding      string
+	Embeds                    []string
+	Tags                      map[string]int
+	TagSites                  []tagSite
+	Funcs                     []funcComplexity
+	Decls                     goDecls
+	Lengths                   lineLengths
+	OverLong                  []longLine
+	Hash                      string
+	Unique                    uniqueLines
+	Logical                   int
+	Style                     styleStats
+}
+
+// fileCache persists counts between runs, so unchanged files aren't read
+// again. Entries are only used while the counting settings they were made
+// under stay the same.
+type fileCache struct {
+	path     string
+	settings string
+
+	mu    sync.Mutex
+	old   map[string]cacheEntry
+	files map[string]cacheEntry // entries used or made this run
+}
+
+type cacheFile struct {
+	Settings string
+	Files    map[string]cacheEntry
+}
+
+// statsCache is set by --cache
+var statsCache *fileCache
+
+// cacheFormat changes whenever entries gain counts older caches lack
+const cacheFormat = 3
+
+// cacheSettings describes everything that changes how a file is counted
+func cacheSettings() string {
+	var exts []string
+	for ext, lang := range sloc.Extensions() {
+		exts = append(exts, ext+"="+lang.Name)
+	}
+	sort.Strings(exts)
+	tags := ""
+	if tagPattern != nil {
+		tags = tagPattern.String()
+	}
+	return fmt.Sprint(cacheFormat, goMode, mixedMode, directiveMode, blankCommentMode, countLicenses, showEmbeds, tags, listTags, measureComplexity, countDecls, measureLineLengths, maxLineLength, findDuplicates, countUnique, countLogical, styleReport, maxLineBytes, exts)
+}
+
+func openCache(dir string) (*fileCache, error) {
+	this := &fileCache{
+		path:     filepath.Join(dir, "files.gob"),
+		settings: cacheSettings(),
+		old:      map[string]cacheEntry{},
+		files:    map[string]cacheEntry{},
+	}
+
+	file, err := os.Open(this.path)
+	if os.IsNotExist(err) {
+		return this, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var data cacheFile
+	if err := gob.NewDecoder(file).Decode(&data); err != nil {
+		log.Warningf("%s: %v, starting a new cache", this.path, err)
+		return this, nil
+	}
+	if data.Settings != this.settings {
+		log.Info("counting settings changed, starting a new cache")
+		return this, nil
+	}
+	this.old = data.Files
+	return this, nil
+}
+
+// stats returns the cached counts for an unchanged file or counts it
+func (this *fileCache) stats(path string) (fileLines, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return getFileStats(path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileLines{filename: path}, err
+	}
+
+	this.mu.Lock()
+	entry, ok := this.old[abs]
+	this.mu.Unlock()
+	oversized := maxFileSize > 0 && info.Size() > int64(maxFileSize)
+	if ok && !oversized && entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime()) {
+		this.mu.Lock()
+		this.files[abs] = entry
+		this.mu.Unlock()
+		return entry.lines(path), nil
+	}
+
+	res, err := getFileStats(path)
+	// a file modified within the timestamp granularity could change again
+	// without its modification time moving, don't trust it
+	if err != nil || time.Since(info.ModTime()) < 2*time.Second {
+		return res, err
+	}
+	this.mu.Lock()
+	this.files[abs] = newCacheEntry(info, res)
+	this.mu.Unlock()
+	return res, nil
+}
+
+// save writes the entries used this run, plus those for paths outside the
+// targets of this run, which it can't tell are gone
+func (this *fileCache) save(targets []string) error {
+	var roots []string
+	for _, target := range targets {
+		if abs, err := filepath.Abs(target); err == nil {
+			roots = append(roots, abs)
+		}
+	}
+	scanned := func(path string) bool {
+		for _, root := range roots {
+			if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	data := cacheFile{Settings: this.settings, Files: this.files}
+	for path, entry := range this.old {
+		if _, ok := data.Files[path]; !ok && !scanned(path) {
+			data.Files[path] = entry
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(this.path), 0o755); err != nil {
+		return err
+	}
+	return saveGob(this.path, data)
+}
+
+// saveGob writes a new file and swaps it in, so an interrupted save can't
+// leave a truncated one
+func saveGob(path string, data any) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(tmp).Encode(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func newCacheEntry(info os.FileInfo, f fileLines) cacheEntry {
+	entry := countsEntry(f)
+	entry.Size, entry.ModTime = info.Size(), info.ModTime()
+	return entry
+}
+
+// countsEntry is an entry for f's counts alone, without what they were
+// taken of
+func countsEntry(f fileLines) cacheEntry {
+	return cacheEntry{
+		Language:   f.language,
+		Code:       f.codeLines,
+		Comment:    f.commentLines,
+		Blank:      f.whitespaceLines,
+		Mixed:      f.mixedLines,
+		License:    f.licenseLines,
+		Directive:  f.directiveLines,
+		LongLines:  f.longLines,
+		Cgo:        f.cgoLines,
+		BOM:        f.bom,
+		Generated:  f.generated,
+		Encoding:   f.encoding,
+		LineEnding: f.lineEnding,
+		Embeds:     f.embeds,
+		Tags:       f.tags,
+		TagSites:   f.tagSites,
+		Funcs:      f.funcs,
+		Decls:      f.decls,
+		Lengths:    f.lengths,
+		OverLong:   f.overLong,
+		Hash:       f.hash,
+		Unique:     f.unique,
+		Logical:    f.logicalLines,
+		Style:      f.style,
+	}
+}
+
+func (this cacheEntry) lines(filename string) fileLines {
+	return fileLines{
+		filename:        filename,
+		language:        this.Language,
+		codeLines:       this.Code,
+		commentLines:    this.Comment,
+		whitespaceLines: this.Blank,
+		mixedLines:      this.Mixed,
+		licenseLines:    this.License,
+		directiveLines:  this.Directive,
+		longLines:       this.LongLines,
+		cgoLines:        this.Cgo,
+		bom:             this.BOM,
+		generated:       this.Generated,
+		encoding:        this.Encoding,
+		lineEnding:      this.LineEnding,
+		embeds:          this.Embeds,
+		tags:            this.Tags,
+		tagSites:        this.TagSites,
+		funcs:           this.Funcs,
+		decls:           this.Decls,
+		lengths:         this.Lengths,
+		overLong:        this.OverLong,
+		hash:            this.Hash,
+		unique:          this.Unique,
+		logicalLines:    this.Logical,
+		style:           this.Style,
+	}
+}
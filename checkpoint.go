@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkpoint keeps the counts of a long run on disk as it goes, so one that
+// is interrupted can carry on with --resume instead of reading every file
+// again. Files already in it are taken as they were, without a stat.
+type checkpoint struct {
+	path string
+	key  string // the settings and targets the counts belong to
+
+	mu    sync.Mutex
+	files map[string]cacheEntry
+	saved int // len(files) as of the last save
+}
+
+type checkpointFile struct {
+	Key   string
+	Files map[string]cacheEntry
+}
+
+// runCheckpoint is set by --checkpoint
+var runCheckpoint *checkpoint
+
+// openCheckpoint starts a checkpoint at path, picking up the counts an
+// interrupted run of the same targets left there if resume is set
+func openCheckpoint(path string, targets []string, resume bool) (*checkpoint, error) {
+	this := &checkpoint{
+		path:  path,
+		key:   cacheSettings() + "\n" + strings.Join(targets, "\n"),
+		files: map[string]cacheEntry{},
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		if resume {
+			log.Warningf("no checkpoint at %s, counting from the start", path)
+		}
+		return this, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if !resume {
+		log.Warningf("%s is left from an interrupted run, starting over; --resume to carry on from it", path)
+		return this, nil
+	}
+
+	var data checkpointFile
+	if err := gob.NewDecoder(file).Decode(&data); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if data.Key != this.key {
+		return nil, usageErrorf("%s is of a run with other targets or counting settings, it can't be resumed with these", path)
+	}
+	this.files = data.Files
+	this.saved = len(data.Files)
+	log.Infof("resuming from %s, %d files already counted", path, len(data.Files))
+	return this, nil
+}
+
+// stats returns the checkpointed counts of path, or counts it with count.
+// Files of remote targets are kept under their target's name, as each run
+// clones them to another temporary directory.
+func (this *checkpoint) stats(path string, count func(string) (fileLines, error)) (fileLines, error) {
+	key := remoteName(path)
+	this.mu.Lock()
+	entry, ok := this.files[key]
+	this.mu.Unlock()
+	if ok {
+		return entry.lines(path), nil
+	}
+
+	res, err := count(path)
+	// files that fail are tried again on resuming
+	if err != nil {
+		return res, err
+	}
+	this.mu.Lock()
+	this.files[key] = countsEntry(res)
+	this.mu.Unlock()
+	return res, nil
+}
+
+// save writes the checkpoint if any file has been counted since it last was
+func (this *checkpoint) save() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if len(this.files) == this.saved {
+		return nil
+	}
+	if err := saveGob(this.path, checkpointFile{Key: this.key, Files: this.files}); err != nil {
+		return err
+	}
+	this.saved = len(this.files)
+	return nil
+}
+
+// every saves the checkpoint at each interval until the returned function
+// is called
+func (this *checkpoint) every(interval time.Duration) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := this.save(); err != nil {
+					log.Errorf("saving the checkpoint: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// finish removes the checkpoint of a run that completed, or saves where one
+// that didn't got to
+func (this *checkpoint) finish(completed bool) {
+	if completed {
+		if err := os.Remove(this.path); err != nil && !os.IsNotExist(err) {
+			log.Errorf("removing the checkpoint: %v", err)
+		}
+		return
+	}
+	if err := this.save(); err != nil {
+		log.Errorf("saving the checkpoint: %v", err)
+		return
+	}
+	log.Warningf("%d files counted so far are kept in %s, run again with --resume to carry on", len(this.files), this.path)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// check run summaries are capped by the GitHub API, as are the annotations
+// one request may carry
+const (
+	maxCheckSummary     = 65535
+	maxCheckAnnotations = 50
+)
+
+type checkRunOutput struct {
+	Title       string            `json:"title"`
+	Summary     string            `json:"summary"`
+	Annotations []checkAnnotation `json:"annotations,omitempty"`
+}
+
+// checkAnnotation marks a file over a budget; the line is required, so it's
+// the file's first
+type checkAnnotation struct {
+	Path            string `json:"path"`
+	StartLine       int    `json:"start_line"`
+	EndLine         int    `json:"end_line"`
+	AnnotationLevel string `json:"annotation_level"`
+	Title           string `json:"title"`
+	Message         string `json:"message"`
+}
+
+type checkRun struct {
+	Name       string         `json:"name"`
+	HeadSHA    string         `json:"head_sha"`
+	Status     string         `json:"status"`
+	Conclusion string         `json:"conclusion"`
+	Output     checkRunOutput `json:"output"`
+}
+
+// checkRunID is all that's read back of a created check run
+type checkRunID struct {
+	ID int64 `json:"id"`
+}
+
+func getenvRequired(key string) (string, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return "", fmt.Errorf("%s is not set", key)
+	}
+	return value, nil
+}
+
+// createCheckRun posts the results as a completed check run, authenticating
+// as the GitHub App installation described by the environment. Each file
+// over a --fail-if or --max-file-code budget is annotated, failing the run.
+func createCheckRun(target string, files []fileLines, total fileLines) error {
+	env := map[string]string{}
+	for _, key := range []string{"GITHUB_APP_ID", "GITHUB_APP_PRIVATE_KEY", "GITHUB_INSTALLATION_ID", "GITHUB_REPOSITORY", "GITHUB_SHA"} {
+		value, err := getenvRequired(key)
+		if err != nil {
+			return err
+		}
+		env[key] = value
+	}
+	apiURL := os.Getenv("GITHUB_API_URL")
+	if apiURL == "" {
+		apiURL = "https://api.github.com"
+	}
+
+	jwt, err := githubAppJWT(env["GITHUB_APP_ID"], env["GITHUB_APP_PRIVATE_KEY"])
+	if err != nil {
+		return err
+	}
+
+	// exchange the app JWT for an installation token
+	var token struct {
+		Token string `json:"token"`
+	}
+	err = githubRequest("POST", apiURL+"/app/installations/"+env["GITHUB_INSTALLATION_ID"]+"/access_tokens", "Bearer "+jwt, nil, &token)
+	if err != nil {
+		return err
+	}
+
+	var annotations []checkAnnotation
+	for _, f := range budgetFindings(files) {
+		annotations = append(annotations, checkAnnotation{
+			// from the repository root, which the run is taken to be at
+			Path:            sarifURI(f.path),
+			StartLine:       1,
+			EndLine:         1,
+			AnnotationLevel: "failure",
+			Title:           "over budget",
+			Message:         f.message,
+		})
+	}
+	conclusion := "success"
+	if len(annotations) > 0 {
+		conclusion = "failure"
+	}
+
+	// the first batch of annotations goes with the run, the rest are added
+	// to it in updates
+	output := checkRunOutput{
+		Title:   fmt.Sprintf("%d code, %d comment, %d blank lines", total.codeLines, total.commentLines, total.whitespaceLines),
+		Summary: checkSummary(files, total),
+	}
+	batch := func() []checkAnnotation {
+		n := min(len(annotations), maxCheckAnnotations)
+		res := annotations[:n]
+		annotations = annotations[n:]
+		return res
+	}
+	output.Annotations = batch()
+	run := checkRun{
+		Name:       "sloc",
+		HeadSHA:    env["GITHUB_SHA"],
+		Status:     "completed",
+		Conclusion: conclusion,
+		Output:     output,
+	}
+	runsURL := apiURL + "/repos/" + env["GITHUB_REPOSITORY"] + "/check-runs"
+	var created checkRunID
+	if err := githubRequest("POST", runsURL, "token "+token.Token, run, &created); err != nil {
+		return err
+	}
+	for len(annotations) > 0 {
+		output.Annotations = batch()
+		update := struct {
+			Output checkRunOutput `json:"output"`
+		}{output}
+		if err := githubRequest("PATCH", fmt.Sprintf("%s/%d", runsURL, created.ID), "token "+token.Token, update, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkSummary(files []fileLines, total fileLines) string {
+	var b strings.Builder
+	row := func(f fileLines) string {
+		return fmt.Sprintf("| %s | %d | %d | %d |\n", strings.ReplaceAll(displayPath(f.filename), "|", "\\|"), f.whitespaceLines, f.commentLines, f.codeLines)
+	}
+
+	footer := row(fileLines{filename: "**" + total.filename + "**", whitespaceLines: total.whitespaceLines, commentLines: total.commentLines, codeLines: total.codeLines})
+	b.WriteString("| FILENAME | White Space | Comment | Code |\n|---|---:|---:|---:|\n")
+	for i, f := range files {
+		line := row(f)
+		if b.Len()+len(line)+len(footer)+64 > maxCheckSummary {
+			fmt.Fprintf(&b, "| _... %d more files_ | | | |\n", len(files)-i)
+			break
+		}
+		b.WriteString(line)
+	}
+	b.WriteString(footer)
+	return b.String()
+}
+
+// githubAppJWT signs a short-lived RS256 token identifying the app. The key
+// may be given inline as PEM or as a path to a PEM file.
+func githubAppJWT(appID, key string) (string, error) {
+	data := []byte(key)
+	if !strings.Contains(key, "-----BEGIN") {
+		var err error
+		if data, err = os.ReadFile(key); err != nil {
+			return "", err
+		}
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", errors.New("GITHUB_APP_PRIVATE_KEY is not a PEM encoded key")
+	}
+	priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err8 := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err8 != nil {
+			return "", err
+		}
+		var ok bool
+		if priv, ok = parsed.(*rsa.PrivateKey); !ok {
+			return "", errors.New("GITHUB_APP_PRIVATE_KEY is not an RSA key")
+		}
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": appID,
+	})
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+func githubRequest(method, url, auth string, body, out interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, url, &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
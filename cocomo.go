@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// with --cocomo, the table is followed by basic COCOMO estimates of what
+// writing the code would take
+var showCocomo bool
+
+// cocomoModel is a basic COCOMO project class's coefficients: effort is
+// a*KSLOC^b person-months over a schedule of c*effort^d months
+type cocomoModel struct {
+	a, b, c, d float64
+}
+
+var cocomoModels = map[string]cocomoModel{
+	"organic":       {2.4, 1.05, 2.5, 0.38},
+	"semi-detached": {3.0, 1.12, 2.5, 0.35},
+	"embedded":      {3.6, 1.20, 2.5, 0.32},
+}
+
+// the --cocomo-model, --cocomo-salary and --cocomo-overhead settings
+var (
+	cocomoMode     = "organic"
+	cocomoSalary   = 56286.0
+	cocomoOverhead = 2.4
+)
+
+// writeCocomo estimates the effort, schedule, team and cost of the code
+// lines in total
+func writeCocomo(w io.Writer, total fileLines) {
+	model := cocomoModels[cocomoMode]
+	effort := model.a * math.Pow(float64(total.codeLines)/1000, model.b)
+	schedule := model.c * math.Pow(effort, model.d)
+	people := 0.0
+	if schedule > 0 {
+		people = effort / schedule
+	}
+	cost := effort * cocomoSalary / 12 * cocomoOverhead
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Estimated cost to develop (%s): $%s\n", cocomoMode, thousands(int(math.Round(cost))))
+	fmt.Fprintf(w, "Estimated schedule effort (%s): %.2f months\n", cocomoMode, schedule)
+	fmt.Fprintf(w, "Estimated people required (%s): %.2f\n", cocomoMode, people)
+}
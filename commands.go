@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// subcommand is a mode of sloc, chosen by the first argument. The global
+// flags are shared by all of them and may come before or after the name.
+type subcommand struct {
+	name, usage, summary string
+	ownFlags             bool // parses the arguments after its name itself
+}
+
+var subcommands = []subcommand{
+	{name: "count", usage: "count [flags] [path...]", summary: "count the lines of each path, the default"},
+	{name: "diff", usage: "diff [flags] <refA> <refB>", summary: "report the change in each file's counts between two git refs"},
+	{name: "compare", usage: "compare [flags] <dirA|reportA.json> <dirB|reportB.json>", summary: "report the files added, removed and changed between two trees or saved reports"},
+	{name: "merge", usage: "merge [flags] <report.json>...", summary: "combine reports saved with --format json or ndjson, such as those of CI shards, into one"},
+	{name: "pr-comment", usage: "pr-comment <base> [head]", summary: "write a markdown comment summarizing a pull request's change in lines"},
+	{name: "baseline", usage: "baseline write|check [-allow n] [-ratchet] <baseline.json> [path...]", summary: "record each package's code lines, or fail if any grew past them", ownFlags: true},
+	{name: "history", usage: "history [-since date] [-interval month] [-ref HEAD] [path]", summary: "count snapshots of the git history as a time series", ownFlags: true},
+	{name: "patch", usage: "patch [flags] [file.patch]", summary: "classify the lines a unified diff adds and removes, read from stdin by default"},
+	{name: "image", usage: "image [flags] ref...", summary: "count the source files inside container images"},
+	{name: "watch", usage: "watch [-by-dir] [-plain] [path...]", summary: "keep the totals on screen, recounting files as they change", ownFlags: true},
+	{name: "tui", usage: "tui [path]", summary: "explore the counts as a directory tree", ownFlags: true},
+	{name: "serve", usage: "serve [-listen addr] [-root dir] [-max-upload size]", summary: "count paths or uploaded archives through an HTTP API", ownFlags: true},
+	{name: "daemon", usage: "daemon [-cron spec] [-repos file] [-listen addr]", summary: "scan the configured repos on a schedule", ownFlags: true},
+	{name: "lsp", usage: "lsp", summary: "serve counts to an editor over stdio"},
+	{name: "completion", usage: "completion bash|zsh|fish", summary: "write a shell completion script", ownFlags: true},
+}
+
+func findSubcommand(name string) *subcommand {
+	for i := range subcommands {
+		if subcommands[i].name == name {
+			return &subcommands[i]
+		}
+	}
+	return nil
+}
+
+// parseSubcommand picks the subcommand from the arguments left after the
+// global flags and parses any global flags following its name, returning
+// the rest
+func parseSubcommand(args []string) (*subcommand, []string, error) {
+	cmd := findSubcommand("count")
+	if len(args) > 0 {
+		if named := findSubcommand(args[0]); named != nil {
+			cmd, args = named, args[1:]
+		}
+	}
+	if !cmd.ownFlags {
+		if err := parseFlags(flag.CommandLine, args); err != nil {
+			return nil, nil, err
+		}
+		args = flag.Args()
+	}
+	return cmd, args, nil
+}
+
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "usage: %s [flags] <command> [flags] [args]\n\ncommands:\n", filepath.Base(os.Args[0]))
+	for _, cmd := range subcommands {
+		fmt.Fprintf(w, "  %-50s %s\n", cmd.usage, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nflags:\n")
+	flag.PrintDefaults()
+}
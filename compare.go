@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// compareRow is a file that differs between the two sides of a compare
+type compareRow struct {
+	path          string
+	status        string // added, removed or changed
+	before, after fileLines
+}
+
+// runCompare writes the files that differ between the two trees or saved
+// reports in args
+func runCompare(args []string, format string) error {
+	if len(args) != 2 {
+		return usageErrorf("usage: sloc compare <dirA|reportA.json> <dirB|reportB.json>")
+	}
+	a, err := compareSide(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := compareSide(args[1])
+	if err != nil {
+		return err
+	}
+	return writeCompare(reportOutput, compareTrees(a, b), format)
+}
+
+// compareSide counts one side of a compare, a directory or a report saved
+// with --format json, keyed by path relative to it
+func compareSide(path string) (map[string]fileLines, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]fileLines{}
+	if info.IsDir() {
+		files, _ := countTree(path)
+		if !includeGenerated {
+			files, _ = withoutGenerated(files)
+		}
+		for _, f := range files {
+			rel, err := filepath.Rel(path, f.filename)
+			if err != nil {
+				rel = f.filename
+			}
+			res[filepath.ToSlash(rel)] = f
+		}
+		return res, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report jsonReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("%s is neither a directory nor a JSON report: %v", path, err)
+	}
+	// a report's paths are as it was run, so they're taken from the
+	// directory all of them share
+	var names []string
+	for _, f := range report.Files {
+		names = append(names, filepath.ToSlash(f.fromJSON().filename))
+	}
+	prefix := commonDir(names)
+	for i, f := range report.Files {
+		res[strings.TrimPrefix(names[i], prefix)] = f.fromJSON()
+	}
+	return res, nil
+}
+
+// commonDir is the leading directory, with its trailing slash, that all the
+// slash-separated paths share
+func commonDir(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+	prefix := paths[0][:strings.LastIndex(paths[0], "/")+1]
+	for _, p := range paths[1:] {
+		for !strings.HasPrefix(p, prefix) {
+			prefix = prefix[:strings.LastIndex(strings.TrimSuffix(prefix, "/"), "/")+1]
+		}
+	}
+	return prefix
+}
+
+// compareTrees lists the files added, removed or changed from a to b
+func compareTrees(a, b map[string]fileLines) []compareRow {
+	var res []compareRow
+	for path, before := range a {
+		after, ok := b[path]
+		switch {
+		case !ok:
+			res = append(res, compareRow{path: path, status: "removed", before: before, after: fileLines{language: before.language}})
+		case after.codeLines != before.codeLines || after.commentLines != before.commentLines || after.whitespaceLines != before.whitespaceLines:
+			res = append(res, compareRow{path: path, status: "changed", before: before, after: after})
+		}
+	}
+	for path, after := range b {
+		if _, ok := a[path]; !ok {
+			res = append(res, compareRow{path: path, status: "added", before: fileLines{language: after.language}, after: after})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].path < res[j].path })
+	return res
+}
+
+func writeCompare(w io.Writer, rows []compareRow, format string) error {
+	var before, after fileLines
+	for _, r := range rows {
+		before.join(r.before)
+		after.join(r.after)
+	}
+	delta := func(r compareRow) fileLines { return subtractLines(r.after, r.before) }
+	totalRow := compareRow{path: "TOTAL", status: fmt.Sprintf("%d files", len(rows)), before: before, after: after}
+
+	switch format {
+	case "json":
+		type jsonCounts struct {
+			Whitespace int `json:"whitespace"`
+			Comment    int `json:"comment"`
+			Code       int `json:"code"`
+		}
+		type jsonCompareRow struct {
+			Filename string     `json:"filename"`
+			Status   string     `json:"status,omitempty"`
+			Before   jsonCounts `json:"before"`
+			After    jsonCounts `json:"after"`
+			Delta    jsonCounts `json:"delta"`
+		}
+		counts := func(f fileLines) jsonCounts { return jsonCounts{f.whitespaceLines, f.commentLines, f.codeLines} }
+		toJSON := func(r compareRow) jsonCompareRow {
+			return jsonCompareRow{Filename: r.path, Status: r.status, Before: counts(r.before), After: counts(r.after), Delta: counts(delta(r))}
+		}
+		report := struct {
+			Files []jsonCompareRow `json:"files"`
+			Total jsonCompareRow   `json:"total"`
+		}{Files: []jsonCompareRow{}}
+		totalRow.status = ""
+		report.Total = toJSON(totalRow)
+		for _, r := range rows {
+			report.Files = append(report.Files, toJSON(r))
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"filename", "status", "code_before", "code_after", "code_delta", "comment_delta", "blank_delta"})
+		totalRow.status = ""
+		for _, r := range append(rows, totalRow) {
+			d := delta(r)
+			cw.Write([]string{r.path, r.status, strconv.Itoa(r.before.codeLines), strconv.Itoa(r.after.codeLines),
+				strconv.Itoa(d.codeLines), strconv.Itoa(d.commentLines), strconv.Itoa(d.whitespaceLines)})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table":
+		signedCounts = true
+		row := func(r compareRow) []string {
+			d := delta(r)
+			return []string{displayPath(r.path), r.status, strconv.Itoa(r.before.codeLines), strconv.Itoa(r.after.codeLines),
+				tableCount(d.codeLines), tableCount(d.commentLines), tableCount(d.whitespaceLines)}
+		}
+		fmt.Fprintln(w)
+		table := tablewriter.NewWriter(w)
+		table.SetHeader([]string{"Filename", "Status", "Code Before", "Code After", "Code", "Comment", "White Space"})
+		table.SetFooter(row(totalRow))
+		table.SetBorder(false)
+		table.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT,
+			tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT})
+		for _, r := range rows {
+			table.Append(row(r))
+		}
+		table.Render()
+		return nil
+	}
+	return usageErrorf("sloc compare writes table, csv or json, not %s", format)
+}
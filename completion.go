@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// completionFlag is a global flag as the completion scripts offer it
+type completionFlag struct {
+	name, usage string
+	isBool      bool
+	values      []string // the values it takes, if there's a fixed set
+}
+
+func completionFlags() []completionFlag {
+	var res []completionFlag
+	flag.VisitAll(func(f *flag.Flag) {
+		c := completionFlag{name: f.Name, usage: strings.SplitN(f.Usage, "\n", 2)[0]}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			c.isBool = true
+		}
+		switch v := f.Value.(type) {
+		case *enumFlag:
+			c.values = v.allowed
+		case *groupByFlag:
+			c.values = v.allowed
+		}
+		if f.Name == "columns" {
+			c.values = tableColumnKeys()
+		}
+		sort.Strings(c.values)
+		res = append(res, c)
+	})
+	return res
+}
+
+func subcommandNames() []string {
+	var names []string
+	for _, cmd := range subcommands {
+		names = append(names, cmd.name)
+	}
+	return names
+}
+
+// runCompletion writes the completion script for the shell in args
+func runCompletion(args []string) error {
+	if len(args) != 1 {
+		return usageErrorf("usage: sloc completion bash|zsh|fish")
+	}
+	if err := writeCompletion(os.Stdout, args[0]); err != nil {
+		return usageError{err}
+	}
+	return nil
+}
+
+// writeCompletion writes the completion script for shell
+func writeCompletion(w io.Writer, shell string) error {
+	switch shell {
+	case "bash":
+		return writeBashCompletion(w)
+	case "zsh":
+		return writeZshCompletion(w)
+	case "fish":
+		return writeFishCompletion(w)
+	}
+	return usageErrorf("unknown shell %q, want bash, zsh or fish", shell)
+}
+
+func writeBashCompletion(w io.Writer) error {
+	var names []string
+	var cases strings.Builder
+	for _, f := range completionFlags() {
+		names = append(names, "--"+f.name)
+		if len(f.values) > 0 {
+			fmt.Fprintf(&cases, "\t-%s|--%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", f.name, f.name, strings.Join(f.values, " "))
+		}
+	}
+	_, err := fmt.Fprintf(w, `# bash completion for sloc
+_sloc() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	case "$prev" in
+%s	esac
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+		return
+	fi
+	COMPREPLY=($(compgen -f -- "$cur"))
+	if [[ $COMP_CWORD -eq 1 ]]; then
+		COMPREPLY+=($(compgen -W %q -- "$cur"))
+	fi
+}
+complete -o filenames -F _sloc sloc
+`, cases.String(), strings.Join(names, " "), strings.Join(subcommandNames(), " "))
+	return err
+}
+
+func writeZshCompletion(w io.Writer) error {
+	// brackets and colons delimit an _arguments spec's parts
+	escape := strings.NewReplacer("[", `\[`, "]", `\]`, ":", `\:`, "'", `'\''`).Replace
+	var b strings.Builder
+	b.WriteString("#compdef sloc\n\n_arguments \\\n")
+	for _, f := range completionFlags() {
+		spec := fmt.Sprintf("--%s[%s]", f.name, escape(f.usage))
+		switch {
+		case len(f.values) > 0:
+			spec += ":value:(" + strings.Join(f.values, " ") + ")"
+		case !f.isBool:
+			spec += ":value:_files"
+		}
+		fmt.Fprintf(&b, "\t'%s' \\\n", spec)
+	}
+	var commands []string
+	for _, cmd := range subcommands {
+		commands = append(commands, fmt.Sprintf(`%s\:"%s"`, cmd.name, escape(cmd.summary)))
+	}
+	fmt.Fprintf(&b, "\t'1:command:((%s))' \\\n\t'*:file:_files'\n", strings.Join(commands, " "))
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeFishCompletion(w io.Writer) error {
+	quote := strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace
+	var b strings.Builder
+	b.WriteString("# fish completion for sloc\n")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(&b, "complete -c sloc -n __fish_use_subcommand -a %s -d '%s'\n", cmd.name, quote(cmd.summary))
+	}
+	for _, f := range completionFlags() {
+		fmt.Fprintf(&b, "complete -c sloc -l %s -d '%s'", f.name, quote(f.usage))
+		switch {
+		case len(f.values) > 0:
+			fmt.Fprintf(&b, " -x -a '%s'", strings.Join(f.values, " "))
+		case !f.isBool:
+			b.WriteString(" -r")
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
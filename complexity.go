@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// with --complexity, Go functions' cyclomatic complexity is measured
+var measureComplexity bool
+
+// complexityOver lists the functions more complex than this after the
+// table, with --complexity-over
+var complexityOver int
+
+// funcComplexity is one Go function's cyclomatic complexity
+type funcComplexity struct {
+	Name             string
+	Line, Complexity int
+}
+
+// goComplexity measures each function and method in a Go source file: one
+// plus a branch for each if, for, case, select case, && and ||. Function
+// literals count toward the function they're in.
+func goComplexity(fset *token.FileSet, file *ast.File) []funcComplexity {
+	var res []funcComplexity
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Body == nil {
+			continue
+		}
+		complexity := 1
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt:
+				complexity++
+			case *ast.CaseClause:
+				if n.List != nil {
+					complexity++
+				}
+			case *ast.CommClause:
+				if n.Comm != nil {
+					complexity++
+				}
+			case *ast.BinaryExpr:
+				if n.Op == token.LAND || n.Op == token.LOR {
+					complexity++
+				}
+			}
+			return true
+		})
+		res = append(res, funcComplexity{Name: funcName(fn), Line: fset.Position(fn.Pos()).Line, Complexity: complexity})
+	}
+	return res
+}
+
+// funcName names a function as Recv.Name for methods
+func funcName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return fn.Name.Name
+	}
+	recv := fn.Recv.List[0].Type
+	for {
+		switch t := recv.(type) {
+		case *ast.StarExpr:
+			recv = t.X
+			continue
+		case *ast.IndexExpr:
+			recv = t.X
+			continue
+		case *ast.IndexListExpr:
+			recv = t.X
+			continue
+		case *ast.Ident:
+			return t.Name + "." + fn.Name.Name
+		}
+		return fn.Name.Name
+	}
+}
+
+// maxComplexity is the most complex function's complexity
+func maxComplexity(f fileLines) int {
+	res := 0
+	for _, fn := range f.funcs {
+		res = max(res, fn.Complexity)
+	}
+	return res
+}
+
+// averageComplexity is the functions' mean complexity
+func averageComplexity(f fileLines) float64 {
+	if len(f.funcs) == 0 {
+		return 0
+	}
+	sum := 0
+	for _, fn := range f.funcs {
+		sum += fn.Complexity
+	}
+	return float64(sum) / float64(len(f.funcs))
+}
+
+// writeComplexity lists the functions over --complexity-over after the
+// table, the most complex first
+func writeComplexity(w io.Writer, files []fileLines) {
+	type site struct {
+		path string
+		fn   funcComplexity
+	}
+	var over []site
+	for _, f := range files {
+		for _, fn := range f.funcs {
+			if fn.Complexity > complexityOver {
+				over = append(over, site{f.filename, fn})
+			}
+		}
+	}
+	if len(over) == 0 {
+		return
+	}
+	sort.Slice(over, func(i, j int) bool {
+		if over[i].fn.Complexity != over[j].fn.Complexity {
+			return over[i].fn.Complexity > over[j].fn.Complexity
+		}
+		if over[i].path != over[j].path {
+			return over[i].path < over[j].path
+		}
+		return over[i].fn.Line < over[j].fn.Line
+	})
+
+	fmt.Fprintln(w)
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Function", "Location", "Complexity"})
+	table.SetBorder(false)
+	for _, s := range over {
+		table.Append([]string{s.fn.Name, fmt.Sprintf("%s:%d", displayPath(s.path), s.fn.Line), strconv.Itoa(s.fn.Complexity)})
+	}
+	table.Render()
+	fmt.Fprintf(w, "%d functions over complexity %d\n", len(over), complexityOver)
+}
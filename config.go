@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// projectConfigFile holds a project's defaults for the flags, found at the
+// root of the work tree, e.g.
+//
+//	exclude: ["gen/**", "*.pb.go"]
+//	format: json
+//	group-by: language
+//	languages:
+//	  - name: Jsonnet
+//	    extensions: [.jsonnet]
+//	    line_comments: ["//"]
+//	profiles:
+//	  ci:
+//	    format: sarif
+//	    include: ["*.go"]
+const projectConfigFile = ".sloc.yaml"
+
+// configLanguages are the languages a config file defines, registered with
+// those from --languages
+var (
+	configLanguages     []languageConfig
+	configLanguagesPath string
+)
+
+// findProjectConfig looks for the config file at the top of the work tree
+// holding the working directory, or in the working directory itself
+func findProjectConfig() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	if top := workTreeTop(dir); top != "" {
+		dir = top
+	}
+	path := filepath.Join(dir, projectConfigFile)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// loadProjectConfig sets each flag the file names to its value, unless it
+// was given on the command line. Lists set repeatable flags once per item
+// and others to the items joined by commas. A named profile's settings
+// replace those at the top of the file.
+func loadProjectConfig(path, profile string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var settings map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	var profiles map[string]map[string]yaml.Node
+	if node, ok := settings["profiles"]; ok {
+		if err := node.Decode(&profiles); err != nil {
+			return fmt.Errorf("%s: profiles: %v", path, err)
+		}
+		delete(settings, "profiles")
+	}
+	if profile != "" {
+		chosen, ok := profiles[profile]
+		if !ok {
+			var names []string
+			for name := range profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("%s: no profile %q, have %s", path, profile, strings.Join(names, ", "))
+		}
+		for name, node := range chosen {
+			settings[name] = node
+		}
+	}
+	return applySettings(path, settings)
+}
+
+func applySettings(path string, settings map[string]yaml.Node) error {
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var names []string
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		node := settings[name]
+		if name == "languages" && node.Kind == yaml.SequenceNode {
+			if err := node.Decode(&configLanguages); err != nil {
+				return fmt.Errorf("%s: languages: %v", path, err)
+			}
+			configLanguagesPath = path
+			continue
+		}
+
+		f := flag.Lookup(name)
+		if f == nil {
+			var known []string
+			flag.VisitAll(func(f *flag.Flag) {
+				known = append(known, f.Name)
+			})
+			msg := fmt.Sprintf("%s: unknown setting %q", path, name)
+			if suggestion := closestMatch(name, known); suggestion != "" {
+				msg += fmt.Sprintf("; did you mean %q?", suggestion)
+			}
+			return fmt.Errorf("%s", msg)
+		}
+		if given[name] {
+			log.Debugf("%s: --%s given, ignoring %s", path, name, name)
+			continue
+		}
+
+		values := []*yaml.Node{&node}
+		if node.Kind == yaml.SequenceNode {
+			values = node.Content
+		}
+		var items []string
+		for _, value := range values {
+			if value.Kind != yaml.ScalarNode {
+				return fmt.Errorf("%s: %s: want a value or a list of them", path, name)
+			}
+			items = append(items, value.Value)
+		}
+		// repeatable flags take each item, others a comma-separated list
+		if _, repeatable := f.Value.(*stringList); !repeatable {
+			items = []string{strings.Join(items, ",")}
+		}
+		for _, item := range items {
+			if err := f.Value.Set(item); err != nil {
+				return fmt.Errorf("%s: %s: %v", path, name, err)
+			}
+		}
+	}
+	return nil
+}
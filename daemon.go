@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+type daemonRepo struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+}
+
+type daemonConfig struct {
+	Repos []daemonRepo `yaml:"repos"`
+	Sinks struct {
+		SQLite      string          `yaml:"sqlite"` // the runs database, unless --db names one
+		Influx      *influxConfig   `yaml:"influx"`
+		Postgres    *postgresConfig `yaml:"postgres"`
+		Pushgateway string          `yaml:"pushgateway"`
+	} `yaml:"sinks"`
+}
+
+type repoStatus struct {
+	Name     string     `json:"name"`
+	Path     string     `json:"path"`
+	Commit   string     `json:"commit,omitempty"`
+	LastRun  *time.Time `json:"lastRun,omitempty"`
+	Duration string     `json:"duration,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Files    int        `json:"files"`
+	Total    *jsonLines `json:"total,omitempty"`
+}
+
+type daemonStatus struct {
+	NextRun *time.Time   `json:"nextRun,omitempty"`
+	Repos   []repoStatus `json:"repos"`
+}
+
+type repoReport struct {
+	Repo   string      `json:"repo"`
+	Commit string      `json:"commit,omitempty"`
+	Time   time.Time   `json:"time"`
+	Files  []jsonLines `json:"files"`
+	Total  jsonLines   `json:"total"`
+
+	key string // the treeKey it was counted at
+}
+
+type daemon struct {
+	repos []daemonRepo
+	sinks []reportSink
+	cron  *cron.Cron
+
+	scanMu sync.Mutex // serializes scans from the schedule and from requests
+
+	mu      sync.RWMutex
+	status  map[string]repoStatus
+	reports map[string]*repoReport // latest report per repo, keyed by name and reused while its treeKey holds
+}
+
+func loadDaemonConfig(path string) (*daemonConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg daemonConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(cfg.Repos) == 0 {
+		return nil, fmt.Errorf("%s: no repos configured", path)
+	}
+	// status, reports and sink runs are all keyed by name
+	names := map[string]bool{}
+	for i, repo := range cfg.Repos {
+		if repo.Path == "" {
+			return nil, fmt.Errorf("%s: repo %d has no path", path, i)
+		}
+		if repo.Name == "" {
+			cfg.Repos[i].Name = filepath.Base(repo.Path)
+		}
+		if names[cfg.Repos[i].Name] {
+			return nil, fmt.Errorf("%s: repo %q listed twice", path, cfg.Repos[i].Name)
+		}
+		names[cfg.Repos[i].Name] = true
+	}
+	return &cfg, nil
+}
+
+// countTree walks a single root and collects its results without rendering,
+// generated files included
+func countTree(root string) ([]fileLines, fileLines) {
+	return countTreeSkipping(root, nil)
+}
+
+// countTreeSkipping counts like countTree, leaving out the directories and
+// files skip reports
+func countTreeSkipping(root string, skip func(path string, info os.FileInfo) bool) ([]fileLines, fileLines) {
+	out := newCollector()
+	go func() {
+		process, wait := genFileProcessor(context.Background(), out)
+		walk := process
+		if skip != nil {
+			walk = func(path string, info os.FileInfo, err error) error {
+				if err == nil && skip(path, info) {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				return process(path, info, err)
+			}
+		}
+		if err := walkTree(root, walk); err != nil {
+			log.Error(err)
+		}
+		wait()
+		out.close()
+	}()
+
+	total := fileLines{filename: "TOTAL"}
+	var files []fileLines
+	out.drain(func(res fileLines) {
+		total.join(res)
+		files = append(files, res)
+	}, func(err error) {
+		log.Warningf("skipping %v", err)
+	})
+	return files, total
+}
+
+// withoutGenerated drops generated files and totals the rest
+func withoutGenerated(files []fileLines) ([]fileLines, fileLines) {
+	total := fileLines{filename: "TOTAL"}
+	var res []fileLines
+	for _, f := range files {
+		if !f.generated {
+			total.join(f)
+			res = append(res, f)
+		}
+	}
+	return res, total
+}
+
+// runDaemon serves, besides the status endpoints, the runs recorded in the
+// --db database, or the repos file's sinks.sqlite, to Grafana
+func runDaemon(args []string, sinks []reportSink, dbPath string) error {
+	flags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	schedule := flags.String("cron", "0 2 * * *", "cron schedule for scanning the configured repos")
+	reposFile := flags.String("repos", "repos.yaml", "YAML file listing the repos to scan")
+	listen := flags.String("listen", ":8080", "address to serve health and status endpoints on")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	cfg, err := loadDaemonConfig(*reposFile)
+	if err != nil {
+		return err
+	}
+	// a daemon keeps its history, so one that would record nothing is refused
+	// rather than found out when Grafana has no runs to show
+	if dbPath == "" {
+		if cfg.Sinks.SQLite == "" {
+			return usageErrorf("the daemon records its runs in SQLite: set sinks.sqlite in %s or pass --db", *reposFile)
+		}
+		dbPath = cfg.Sinks.SQLite
+		sink, err := newSQLiteSink(dbPath)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.Sinks.Influx != nil {
+		sinks = append(sinks, newInfluxSink(*cfg.Sinks.Influx))
+	}
+	if cfg.Sinks.Pushgateway != "" {
+		sinks = append(sinks, newPushgatewaySink(cfg.Sinks.Pushgateway))
+	}
+	if cfg.Sinks.Postgres != nil {
+		sink, err := newPostgresSink(*cfg.Sinks.Postgres)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	d := &daemon{
+		repos:   cfg.Repos,
+		sinks:   sinks,
+		status:  map[string]repoStatus{},
+		reports: map[string]*repoReport{},
+		cron:    cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DiscardLogger))),
+	}
+	for _, repo := range d.repos {
+		d.status[repo.Name] = repoStatus{Name: repo.Name, Path: repo.Path}
+	}
+	if _, err := d.cron.AddFunc(*schedule, d.scanAll); err != nil {
+		return fmt.Errorf("invalid --cron schedule %q: %v", *schedule, err)
+	}
+	d.cron.Start()
+	defer d.cron.Stop()
+
+	routes := []apiRoute{
+		{method: "GET", path: "/healthz", summary: "liveness check", handler: d.handleHealth},
+		{method: "GET", path: "/status", summary: "last scan result for each configured repo", response: daemonStatus{}, handler: d.handleStatus},
+		{method: "GET", path: "/reports", summary: "per-file report for ?repo=NAME, rescanned only when its commit or work tree changed", query: []string{"repo"},
+			response: repoReport{}, errors: []int{http.StatusNotFound, http.StatusInternalServerError}, handler: d.handleReports},
+	}
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	routes = append(routes, grafanaRoutes(db)...)
+	mux := http.NewServeMux()
+	registerRoutes(mux, "sloc daemon", routes)
+
+	log.Noticef("daemon scanning %d repos on %q, listening on %s", len(d.repos), *schedule, *listen)
+	return http.ListenAndServe(*listen, mux)
+}
+
+// gitHead returns the commit checked out at path, or "" outside a git repo
+func gitHead(path string) string {
+	out, err := exec.Command("git", "-C", path, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// treeKey identifies what counting path would find, so a count can be
+// reused until it changes: the branch and commit of a clean git work tree,
+// otherwise a hash of the name, size and modification time of each file the
+// walk reaches, far cheaper than reading them
+func treeKey(path string) string {
+	if commit := gitHead(path); commit != "" {
+		status := []string{"-C", path, "status", "--porcelain"}
+		if !respectGitignore {
+			// ignored files are counted, so changing them dirties the tree too
+			status = append(status, "--ignored")
+		}
+		if out, err := exec.Command("git", status...).Output(); err == nil && len(out) == 0 {
+			ref, _ := exec.Command("git", "-C", path, "rev-parse", "--abbrev-ref", "HEAD").Output()
+			return strings.TrimSpace(string(ref)) + "@" + commit
+		}
+	}
+
+	h := sha256.New()
+	walkTree(path, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			fmt.Fprintf(h, "%s\x00%d\x00%d\n", path, info.Size(), info.ModTime().UnixNano())
+		}
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (this *daemon) scanAll() {
+	for _, repo := range this.repos {
+		this.scanRepo(repo)
+	}
+}
+
+// scanRepo returns the cached report while the repo's treeKey is unchanged
+func (this *daemon) scanRepo(repo daemonRepo) (*repoReport, error) {
+	this.scanMu.Lock()
+	defer this.scanMu.Unlock()
+
+	commit := gitHead(repo.Path)
+	key := treeKey(repo.Path)
+	this.mu.RLock()
+	cached := this.reports[repo.Name]
+	this.mu.RUnlock()
+	if cached != nil && cached.key == key {
+		log.Debugf("%s unchanged at %s, using cached report", repo.Name, key)
+		return cached, nil
+	}
+
+	log.Info("scanning", repo.Name)
+	status := repoStatus{Name: repo.Name, Path: repo.Path, Commit: commit}
+	start := time.Now()
+
+	var report *repoReport
+	_, err := isDirectory(repo.Path)
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		files, total := countTree(repo.Path)
+		if !includeGenerated {
+			files, total = withoutGenerated(files)
+		}
+		for _, sink := range this.sinks {
+			if err := sink(repo.Name, files, total); err != nil {
+				log.Error(err)
+			}
+		}
+
+		report = &repoReport{Repo: repo.Name, Commit: commit, Time: start, Total: total.toJSON(), key: key}
+		for _, f := range files {
+			report.Files = append(report.Files, f.toJSON())
+		}
+		status.Files = len(files)
+		status.Total = &report.Total
+	}
+
+	status.LastRun = &start
+	status.Duration = time.Since(start).String()
+	this.mu.Lock()
+	this.status[repo.Name] = status
+	if report != nil {
+		this.reports[repo.Name] = report
+	}
+	this.mu.Unlock()
+	return report, err
+}
+
+// writeJSON honors If-None-Match using a hash of the encoded body
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeTagged(w, r, "application/json", body.Bytes())
+}
+
+// writeTagged writes body with an ETag of its hash, or only 304 Not
+// Modified when the request's If-None-Match already has it
+func writeTagged(w http.ResponseWriter, r *http.Request, contentType string, body []byte) {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+func (this *daemon) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+func (this *daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	this.mu.RLock()
+	repos := make([]repoStatus, 0, len(this.repos))
+	for _, repo := range this.repos {
+		repos = append(repos, this.status[repo.Name])
+	}
+	this.mu.RUnlock()
+
+	var next *time.Time
+	if entries := this.cron.Entries(); len(entries) > 0 {
+		next = &entries[0].Next
+	}
+
+	writeJSON(w, r, daemonStatus{NextRun: next, Repos: repos})
+}
+
+func (this *daemon) handleReports(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("repo")
+	for _, repo := range this.repos {
+		if repo.Name != name {
+			continue
+		}
+		report, err := this.scanRepo(repo)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, report)
+		return
+	}
+	http.Error(w, fmt.Sprintf("unknown repo %q", name), http.StatusNotFound)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDaemonConfigDuplicateNames(t *testing.T) {
+	cases := []struct {
+		config string
+		want   string // in the error, or "" for none
+	}{
+		{"repos:\n  - path: /src/a/api\n  - path: /src/b/web\n", ""},
+		{"repos:\n  - path: /src/a/api\n  - path: /src/b/api\n", `repo "api" listed twice`},
+		{"repos:\n  - path: /src/a/api\n  - path: /src/b/api\n    name: b-api\n", ""},
+		{"repos:\n  - path: /src/a/web\n    name: api\n  - path: /src/b/api\n", `repo "api" listed twice`},
+	}
+	for _, tc := range cases {
+		path := filepath.Join(t.TempDir(), "repos.yaml")
+		if err := os.WriteFile(path, []byte(tc.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadDaemonConfig(path)
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%q: %v", tc.config, err)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("%q: got %v, want %s", tc.config, err, tc.want)
+		}
+	}
+}
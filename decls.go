@@ -0,0 +1,73 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// with --decls, Go files' declarations are counted
+var countDecls bool
+
+// goDecls counts a Go file's top-level declarations, to track a package's
+// API surface, and how many of its functions and methods have a doc comment
+type goDecls struct {
+	Funcs, Methods, Types, Exported int
+	Documented                      int
+}
+
+func (this *goDecls) join(d goDecls) {
+	this.Funcs += d.Funcs
+	this.Methods += d.Methods
+	this.Types += d.Types
+	this.Exported += d.Exported
+	this.Documented += d.Documented
+}
+
+// docCoverage is the share of functions and methods with a doc comment
+func (this goDecls) docCoverage() float64 {
+	return ratio(this.Documented, this.Funcs+this.Methods)
+}
+
+// countGoDecls counts the functions, methods and types a file declares, how
+// many of its declared names are exported: functions, methods, types,
+// variables and constants, and how many functions and methods have a doc
+// comment
+func countGoDecls(file *ast.File) goDecls {
+	var res goDecls
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv != nil {
+				res.Methods++
+			} else {
+				res.Funcs++
+			}
+			if decl.Name.IsExported() {
+				res.Exported++
+			}
+			if decl.Doc != nil {
+				res.Documented++
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					res.Types++
+					if spec.Name.IsExported() {
+						res.Exported++
+					}
+				case *ast.ValueSpec:
+					if decl.Tok != token.VAR && decl.Tok != token.CONST {
+						continue
+					}
+					for _, name := range spec.Names {
+						if name.IsExported() {
+							res.Exported++
+						}
+					}
+				}
+			}
+		}
+	}
+	return res
+}
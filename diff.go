@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/chriskirkland/go-utils/sloc"
+)
+
+// runDiff renders how the counts changed between the two refs in args
+func runDiff(args []string, render renderer) error {
+	if len(args) != 2 {
+		return usageErrorf("usage: sloc diff <refA> <refB>")
+	}
+	rows, total, err := diffRefs(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	signedCounts = true
+	return render(reportOutput, rows, total)
+}
+
+// diffRefs reports the change in each source file's counts from refA to
+// refB, as signed deltas
+func diffRefs(refA, refB string) ([]fileLines, fileLines, error) {
+	total := fileLines{filename: "TOTAL"}
+	cmd := exec.Command("git", "diff", "--name-status", "--no-renames", "--relative", "-z", refA, refB)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, total, fmt.Errorf("git diff %s %s: %v: %s", refA, refB, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var res []fileLines
+	fields := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		status, path := fields[i], fields[i+1]
+		if !isSourceFile(path) {
+			continue
+		}
+		var before, after fileLines
+		if status != "A" {
+			if before, err = countBlob(refA, path); err != nil {
+				return nil, total, err
+			}
+		}
+		if status != "D" {
+			if after, err = countBlob(refB, path); err != nil {
+				return nil, total, err
+			}
+		}
+		delta := subtractLines(after, before)
+		delta.filename = path
+		res = append(res, delta)
+		total.join(delta)
+	}
+	return res, total, nil
+}
+
+// countBlob counts path as it is at ref, binary and ignored files as empty
+func countBlob(ref, path string) (fileLines, error) {
+	data, err := exec.Command("git", "show", ref+":./"+path).Output()
+	if err != nil {
+		return fileLines{}, fmt.Errorf("git show %s:%s: %v", ref, path, err)
+	}
+	res, err := countLines(path, bytes.NewReader(data))
+	if errors.Is(err, sloc.ErrBinaryFile) || errors.Is(err, sloc.ErrIgnoredFile) {
+		return fileLines{filename: path}, nil
+	}
+	return res, err
+}
+
+// subtractLines is the change in counts from before to after
+func subtractLines(after, before fileLines) fileLines {
+	res := after
+	if res.language == "" {
+		res.language = before.language
+	}
+	res.codeLines -= before.codeLines
+	res.commentLines -= before.commentLines
+	res.whitespaceLines -= before.whitespaceLines
+	res.mixedLines -= before.mixedLines
+	res.licenseLines -= before.licenseLines
+	res.directiveLines -= before.directiveLines
+	res.cgoLines -= before.cgoLines
+	res.longLines -= before.longLines
+	return res
+}
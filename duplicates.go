@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// with --duplicates, files are hashed and the byte-identical ones listed
+// after the table
+var findDuplicates bool
+
+// writeDuplicates lists the groups of byte-identical files, the most lines
+// wasted on copies first
+func writeDuplicates(w io.Writer, files []fileLines) {
+	groups := map[string][]fileLines{}
+	for _, f := range files {
+		// empty files are alike without being copies
+		if f.hash != "" && f.codeLines+f.commentLines+f.whitespaceLines > 0 {
+			groups[f.hash] = append(groups[f.hash], f)
+		}
+	}
+	var dupes [][]fileLines
+	wastedCode, wastedLines := 0, 0
+	for _, group := range groups {
+		if len(group) > 1 {
+			dupes = append(dupes, group)
+			wastedCode += group[0].codeLines * (len(group) - 1)
+			wastedLines += (group[0].codeLines + group[0].commentLines + group[0].whitespaceLines) * (len(group) - 1)
+		}
+	}
+	if len(dupes) == 0 {
+		return
+	}
+	wasted := func(group []fileLines) int { return group[0].codeLines * (len(group) - 1) }
+	sort.Slice(dupes, func(i, j int) bool {
+		if wasted(dupes[i]) != wasted(dupes[j]) {
+			return wasted(dupes[i]) > wasted(dupes[j])
+		}
+		return dupes[i][0].filename < dupes[j][0].filename
+	})
+
+	fmt.Fprintln(w)
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Identical Files", "Copies", "Code Each", "Wasted Code"})
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, group := range dupes {
+		var names []string
+		for _, f := range group {
+			names = append(names, displayPath(f.filename))
+		}
+		table.Append([]string{strings.Join(names, "\n"), tableCount(len(group)), tableCount(group[0].codeLines), tableCount(wasted(group))})
+	}
+	table.Render()
+	fmt.Fprintf(w, "%d groups of identical files, %s code lines (%s in all) in copies\n", len(dupes), tableCount(wastedCode), tableCount(wastedLines))
+}
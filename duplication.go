@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/chriskirkland/go-utils/sloc"
+	"github.com/olekukonko/tablewriter"
+)
+
+// with --duplication, code blocks repeated across the files are found after
+// the count
+var detectDuplication bool
+
+// duplicationMin is the fewest tokens a repeated block must have
+var duplicationMin = 100
+
+// sourceToken is a normalized token and the line it's on
+type sourceToken struct {
+	id   int
+	line int
+}
+
+// tokenPattern splits a line of any language into identifiers, numbers,
+// string literals and single punctuation characters
+var tokenPattern = regexp.MustCompile(`[\pL_][\pL\pN_]*|\pN[\pL\pN_.]*|"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|\S`)
+
+// tokenizer numbers each distinct token, so windows hash as integers
+type tokenizer struct {
+	ids map[string]int
+}
+
+func (this *tokenizer) id(text string) int {
+	id, ok := this.ids[text]
+	if !ok {
+		id = len(this.ids) + 1
+		this.ids[text] = id
+	}
+	return id
+}
+
+// tokens reads a file's code as normalized tokens: comments and whitespace
+// dropped and literals replaced by their kind, so a block copied with other
+// strings or numbers in it still matches
+func (this *tokenizer) tokens(f fileLines) ([]sourceToken, error) {
+	src, err := os.ReadFile(f.filename)
+	if err != nil {
+		return nil, err
+	}
+	var res []sourceToken
+	if f.language == sloc.Go.Name {
+		fset := token.NewFileSet()
+		file := fset.AddFile(f.filename, -1, len(src))
+		var s scanner.Scanner
+		s.Init(file, src, nil, 0)
+		for {
+			pos, tok, lit := s.Scan()
+			if tok == token.EOF {
+				return res, nil
+			}
+			text := lit
+			switch {
+			case tok == token.SEMICOLON && lit == "\n":
+				continue
+			case tok.IsLiteral() && tok != token.IDENT:
+				text = tok.String()
+			case lit == "":
+				text = tok.String()
+			}
+			res = append(res, sourceToken{id: this.id(text), line: fset.Position(pos).Line})
+		}
+	}
+
+	lang := sloc.FindLanguage(f.language)
+	if lang == nil {
+		return nil, nil
+	}
+	c := sloc.NewClassifier(lang)
+	for i, line := range bytes.Split(src, []byte("\n")) {
+		kind := c.Classify(string(line))
+		if kind&sloc.CodeLine == 0 {
+			continue
+		}
+		if kind == sloc.MixedLine {
+			line = line[:lang.CommentStart(kind, line)]
+		}
+		for _, text := range tokenPattern.FindAllString(string(line), -1) {
+			if text[0] == '"' || text[0] == '\'' {
+				text = "STRING"
+			} else if text[0] >= '0' && text[0] <= '9' {
+				text = "NUMBER"
+			}
+			res = append(res, sourceToken{id: this.id(text), line: i + 1})
+		}
+	}
+	return res, nil
+}
+
+// duplicatedFile is the lines of one file in blocks found elsewhere too
+type duplicatedFile struct {
+	path  string
+	lines map[int]bool
+	of    string // where the first block it repeats is
+	code  int
+}
+
+// findDuplication finds the windows of duplicationMin tokens that occur more
+// than once, by their rolling hash, and marks the lines of every copy
+func findDuplication(files []fileLines) (map[string]*duplicatedFile, int) {
+	const base = 1000003
+	pow := uint64(1)
+	for i := 0; i < duplicationMin; i++ {
+		pow *= base
+	}
+	type location struct {
+		file  int
+		start int
+	}
+	t := &tokenizer{ids: map[string]int{}}
+	seen := map[uint64]location{}
+	res := map[string]*duplicatedFile{}
+	var all [][]sourceToken
+	code := 0
+	mark := func(file int, start int, of string) {
+		f := files[file]
+		d := res[f.filename]
+		if d == nil {
+			d = &duplicatedFile{path: f.filename, lines: map[int]bool{}, of: of, code: f.codeLines}
+			res[f.filename] = d
+		}
+		for _, tok := range all[file][start : start+duplicationMin] {
+			d.lines[tok.line] = true
+		}
+	}
+
+	for i, f := range files {
+		toks, err := t.tokens(f)
+		if err != nil {
+			log.Debugf("%s: %v", displayPath(f.filename), err)
+		}
+		all = append(all, toks)
+		code += f.codeLines
+		if len(toks) < duplicationMin {
+			continue
+		}
+		var h uint64
+		for j, tok := range toks {
+			h = h*base + uint64(tok.id)
+			if j >= duplicationMin {
+				h -= uint64(toks[j-duplicationMin].id) * pow
+			}
+			start := j - duplicationMin + 1
+			if start < 0 {
+				continue
+			}
+			first, ok := seen[h]
+			if !ok {
+				seen[h] = location{file: i, start: start}
+				continue
+			}
+			// a run repeating itself isn't a copy of anything
+			if first.file == i && start-first.start < duplicationMin {
+				continue
+			}
+			firstFile := files[first.file].filename
+			mark(first.file, first.start, fmt.Sprintf("%s:%d", displayPath(f.filename), toks[start].line))
+			mark(i, start, fmt.Sprintf("%s:%d", displayPath(firstFile), all[first.file][first.start].line))
+		}
+	}
+	return res, code
+}
+
+// writeDuplication lists the files with duplicated blocks after the table,
+// the most duplicated lines first, and the share of code they make up
+func writeDuplication(w io.Writer, files []fileLines) {
+	dupes, code := findDuplication(files)
+	var rows []*duplicatedFile
+	duplicated := 0
+	for _, d := range dupes {
+		rows = append(rows, d)
+		duplicated += len(d.lines)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if len(rows[i].lines) != len(rows[j].lines) {
+			return len(rows[i].lines) > len(rows[j].lines)
+		}
+		return rows[i].path < rows[j].path
+	})
+
+	if len(rows) > 0 {
+		fmt.Fprintln(w)
+		table := tablewriter.NewWriter(w)
+		table.SetHeader([]string{"Filename", "Duplicated Lines", "Of Code", "First Copy Of"})
+		table.SetBorder(false)
+		for _, d := range rows {
+			table.Append([]string{displayPath(d.path), strconv.Itoa(len(d.lines)), percent(len(d.lines), d.code), d.of})
+		}
+		table.Render()
+	}
+	fmt.Fprintf(w, "duplication: %s of code lines (%s of %s) in blocks of %d or more tokens\n",
+		percent(duplicated, code), tableCount(duplicated), tableCount(code), duplicationMin)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// with --embeds, the files Go sources pull in with //go:embed are listed
+// after the report
+var showEmbeds bool
+
+// embeddedAsset is a file embedded by one or more Go sources
+type embeddedAsset struct {
+	path  string
+	by    []string
+	bytes int64
+	lines int
+}
+
+// embedPatterns parses the patterns of a //go:embed line, which may be
+// quoted to hold spaces
+func embedPatterns(line string) ([]string, error) {
+	var patterns []string
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "//go:embed"))
+	for rest != "" {
+		end := strings.IndexAny(rest, " \t")
+		if rest[0] == '"' || rest[0] == '`' {
+			// the closing quote, skipping escaped ones for "..."
+			end = 1
+			for end < len(rest) && rest[end] != rest[0] {
+				if rest[0] == '"' && rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end++
+		}
+		if end < 0 || end > len(rest) {
+			end = len(rest)
+		}
+
+		pattern := rest[:end]
+		if pattern[0] == '"' || pattern[0] == '`' {
+			var err error
+			if pattern, err = strconv.Unquote(pattern); err != nil {
+				return patterns, fmt.Errorf("invalid //go:embed pattern %s", rest[:end])
+			}
+		}
+		patterns = append(patterns, pattern)
+		rest = strings.TrimLeft(rest[end:], " \t")
+	}
+	return patterns, nil
+}
+
+// resolveEmbeds finds the files matched by each source's //go:embed patterns
+// the way the go command does: relative to the source's directory, with
+// directories embedded whole except for names starting with . or _ unless
+// the pattern has the all: prefix
+func resolveEmbeds(files []fileLines) []embeddedAsset {
+	assets := map[string]*embeddedAsset{}
+	add := func(path, by string) {
+		asset := assets[path]
+		if asset == nil {
+			asset = &embeddedAsset{path: path}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				log.Warningf("embedded by %s: %v", displayPath(by), err)
+				return
+			}
+			asset.bytes = int64(len(data))
+			asset.lines = bytes.Count(data, []byte("\n"))
+			if len(data) > 0 && data[len(data)-1] != '\n' {
+				asset.lines++
+			}
+			assets[path] = asset
+		}
+		if len(asset.by) == 0 || asset.by[len(asset.by)-1] != by {
+			asset.by = append(asset.by, by)
+		}
+	}
+
+	for _, f := range files {
+		dir := filepath.Dir(f.filename)
+		for _, pattern := range f.embeds {
+			pattern, all := strings.CutPrefix(pattern, "all:")
+			matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+			if err != nil || len(matches) == 0 {
+				log.Warningf("%s: //go:embed %s matches no files", displayPath(f.filename), pattern)
+				continue
+			}
+			for _, match := range matches {
+				filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+					if err != nil {
+						return nil
+					}
+					if path != match && !all && strings.ContainsAny(d.Name()[:1], "._") {
+						if d.IsDir() {
+							return filepath.SkipDir
+						}
+						return nil
+					}
+					if d.Type().IsRegular() {
+						add(path, f.filename)
+					}
+					return nil
+				})
+			}
+		}
+	}
+
+	var res []embeddedAsset
+	for _, asset := range assets {
+		res = append(res, *asset)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].path < res[j].path })
+	return res
+}
+
+// writeEmbeds adds the embedded assets section to the table report
+func writeEmbeds(w io.Writer, files []fileLines) {
+	assets := resolveEmbeds(files)
+	if len(assets) == 0 {
+		return
+	}
+
+	var size int64
+	lines := 0
+	var data [][]string
+	for _, asset := range assets {
+		var by []string
+		for _, f := range asset.by {
+			by = append(by, displayPath(f))
+		}
+		data = append(data, []string{displayPath(asset.path), strings.Join(by, ", "), strconv.FormatInt(asset.bytes, 10), strconv.Itoa(asset.lines)})
+		size += asset.bytes
+		lines += asset.lines
+	}
+
+	fmt.Fprintln(w)
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Embedded Asset", "Embedded By", "Bytes", "Lines"})
+	table.SetFooter([]string{"TOTAL", "", strconv.FormatInt(size, 10), strconv.Itoa(lines)})
+	table.SetBorder(false)
+	table.AppendBulk(data)
+	table.Render()
+}
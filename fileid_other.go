@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// fileID identifies a file independently of the path it was reached by
+type fileID struct {
+	dev, ino uint64
+}
+
+// file identity isn't available here, so nothing is ever a revisit
+func getFileID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
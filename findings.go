@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// finding is a problem with a file worth surfacing to code review tools
+type finding struct {
+	rule    string
+	path    string
+	message string
+}
+
+// findingRules describes each rule findings can be reported under
+var findingRules = map[string]string{
+	"long-lines":          "Lines longer than --max-line-bytes were only partly classified",
+	"low-comment-density": "Comments are under --min-comment-density percent of code and comments",
+	"oversized-file":      "The file is larger than --max-file-size and wasn't counted",
+	"over-budget":         "The file is over a --fail-if or --max-file-code budget",
+}
+
+// oversizedFiles are the files the run left out for --max-file-size, set
+// once it's counted
+var oversizedFiles []finding
+
+// minDensity is the --min-comment-density percentage, 0 to not check
+var minDensity float64
+
+// densityBy checks the density of each "file" or each "package", that is
+// directory
+var densityBy = "file"
+
+// collectFindings checks the counted files against the rules
+func collectFindings(files []fileLines) []finding {
+	var res []finding
+	for _, f := range files {
+		if f.longLines > 0 {
+			res = append(res, finding{
+				rule:    "long-lines",
+				path:    f.filename,
+				message: fmt.Sprintf("%d lines longer than %d bytes were truncated for classification", f.longLines, maxLineBytes),
+			})
+		}
+	}
+	res = append(res, oversizedFiles...)
+	res = append(res, budgetFindings(files)...)
+	return append(res, densityFindings(files)...)
+}
+
+// densityFindings lists the files or packages with code whose comment
+// density is under minDensity; generated files aren't held to it
+func densityFindings(files []fileLines) []finding {
+	if minDensity <= 0 {
+		return nil
+	}
+	var rows []fileLines
+	if densityBy == "package" {
+		packages := map[string]*fileLines{}
+		for _, f := range files {
+			if f.generated {
+				continue
+			}
+			dir := filepath.Dir(f.filename)
+			if packages[dir] == nil {
+				packages[dir] = &fileLines{filename: dir}
+			}
+			packages[dir].join(f)
+		}
+		for _, p := range packages {
+			rows = append(rows, *p)
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i].filename < rows[j].filename })
+	} else {
+		for _, f := range files {
+			if !f.generated {
+				rows = append(rows, f)
+			}
+		}
+	}
+
+	var res []finding
+	for _, f := range rows {
+		if f.codeLines == 0 || 100*ratio(f.commentLines, f.codeLines+f.commentLines) >= minDensity {
+			continue
+		}
+		res = append(res, finding{
+			rule: "low-comment-density",
+			path: f.filename,
+			message: fmt.Sprintf("comment density %s is under %g%% (%d comment lines to %d code)",
+				percent(f.commentLines, f.codeLines+f.commentLines), minDensity, f.commentLines, f.codeLines),
+		})
+	}
+	return res
+}
+
+// newDensitySink warns of each file or package under --min-comment-density,
+// failing the run with fail
+func newDensitySink(fail bool) reportSink {
+	return func(target string, files []fileLines, total fileLines) error {
+		found := densityFindings(files)
+		for _, f := range found {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", displayPath(f.path), f.message)
+		}
+		if fail {
+			failedChecks += len(found)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// stringList is a flag that may be repeated
+type stringList []string
+
+func (this *stringList) String() string {
+	return strings.Join(*this, ",")
+}
+
+func (this *stringList) Set(value string) error {
+	*this = append(*this, value)
+	return nil
+}
+
+// enumFlag is a flag restricted to a fixed set of values. Values match case
+// insensitively; a typo is rejected with the valid values and, when one is
+// close enough, a suggestion.
+type enumFlag struct {
+	value   string
+	allowed []string
+}
+
+func newEnumFlag(value string, allowed ...string) *enumFlag {
+	sort.Strings(allowed)
+	return &enumFlag{value: value, allowed: allowed}
+}
+
+func (this *enumFlag) String() string {
+	return this.value
+}
+
+func (this *enumFlag) Set(value string) error {
+	for _, allowed := range this.allowed {
+		if strings.EqualFold(value, allowed) {
+			this.value = allowed
+			return nil
+		}
+	}
+
+	msg := fmt.Sprintf("must be one of %s", this.choices())
+	if suggestion := closestMatch(value, this.allowed); suggestion != "" {
+		msg += fmt.Sprintf("; did you mean %q?", suggestion)
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+func (this *enumFlag) choices() string {
+	return strings.Join(this.allowed, ", ")
+}
+
+// closestMatch returns the candidate nearest to s by edit distance, if it's
+// near enough to plausibly be what was meant
+func closestMatch(s string, candidates []string) string {
+	best, bestDist := "", -1
+	for _, c := range candidates {
+		d := editDistance(strings.ToLower(s), strings.ToLower(c))
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	if bestDist < 0 || bestDist > 2 && bestDist > len(best)/3 {
+		return ""
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// groupByFlag is --group-by, whose dir grouping can take a depth, as in
+// dir:2
+type groupByFlag struct {
+	*enumFlag
+	depth int
+}
+
+func (this *groupByFlag) String() string {
+	if this.enumFlag == nil {
+		return ""
+	}
+	if this.depth == 0 {
+		return this.value
+	}
+	return this.value + ":" + strconv.Itoa(this.depth)
+}
+
+func (this *groupByFlag) Set(value string) error {
+	name, depth, ok := strings.Cut(value, ":")
+	this.depth = 0
+	if ok {
+		if !strings.EqualFold(name, "dir") {
+			return fmt.Errorf("only dir takes a depth")
+		}
+		n, err := strconv.Atoi(depth)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid depth %q, want a positive number", depth)
+		}
+		this.depth = n
+	}
+	return this.enumFlag.Set(name)
+}
+
+// humanFlag is --human, which alone means "commas" and also takes
+// --human=compact
+type humanFlag string
+
+func (this *humanFlag) String() string {
+	return string(*this)
+}
+
+func (this *humanFlag) Set(value string) error {
+	switch strings.ToLower(value) {
+	case "true", "commas":
+		*this = "commas"
+	case "false":
+		*this = ""
+	case "compact":
+		*this = "compact"
+	default:
+		return fmt.Errorf("must be commas or compact")
+	}
+	return nil
+}
+
+func (this *humanFlag) IsBoolFlag() bool {
+	return true
+}
+
+// byteSize is a flag holding a size such as 512K, 10MB or 1GiB, the units
+// all being powers of 1024
+type byteSize int64
+
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"", 1}}
+
+func (this *byteSize) String() string {
+	return formatSize(int64(*this))
+}
+
+func (this *byteSize) Set(value string) error {
+	s := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B"), "I")
+	for _, unit := range sizeUnits {
+		if num, ok := strings.CutSuffix(s, unit.suffix); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid size %q, want e.g. 512K or 10MB", value)
+			}
+			*this = byteSize(n * float64(unit.size))
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid size %q, want e.g. 512K or 10MB", value)
+}
+
+// formatSize writes n in the largest unit it's at least one of
+func formatSize(n int64) string {
+	for _, unit := range sizeUnits {
+		if n >= unit.size && unit.size > 1 {
+			size := math.Round(float64(n)/float64(unit.size)*10) / 10
+			return strconv.FormatFloat(size, 'f', -1, 64) + unit.suffix + "B"
+		}
+	}
+	return strconv.FormatInt(n, 10) + "B"
+}
@@ -0,0 +1,987 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// renderer writes the final report for a run
+type renderer func(w io.Writer, files []fileLines, total fileLines) error
+
+var renderers = map[string]renderer{
+	"table":     renderTable,
+	"sonar":     renderSonar,
+	"backstage": renderBackstage,
+	"oneline":   renderOneline,
+	"json":      renderJSON,
+	"csv":       renderDelimited(','),
+	"tsv":       renderDelimited('\t'),
+	"ndjson":    renderNDJSON,
+	"html":      renderHTML,
+	"tokei":     renderTokei,
+	"sarif":     renderSARIF,
+}
+
+// groupBy rolls the report up from files into groups of them
+var groupBy = "file"
+
+// groupDepth cuts --group-by dir:N's directories to their first N levels
+var groupDepth int
+
+// groupRoots are the targets the dir:N levels are counted from
+var groupRoots []string
+
+// groupKeys name the group each file belongs to, for --group-by
+var groupKeys = map[string]func(f fileLines) string{
+	"language": func(f fileLines) string { return f.language },
+	"ext": func(f fileLines) string {
+		if ext := filepath.Ext(f.filename); ext != "" {
+			return strings.ToLower(ext)
+		}
+		return "(none)"
+	},
+	"dir": func(f fileLines) string {
+		dir := filepath.Dir(f.filename)
+		if groupDepth == 0 {
+			return dir
+		}
+		// levels count from the target the file was found under
+		sep := string(filepath.Separator)
+		root := ""
+		for _, target := range groupRoots {
+			if dir == target {
+				return dir
+			}
+			// a target such as / or C:\ already ends in a separator
+			if !strings.HasSuffix(target, sep) {
+				target += sep
+			}
+			if strings.HasPrefix(dir, target) && len(target) > len(root) {
+				root = target
+			}
+		}
+		if root == "" {
+			// or from the volume, C: or \\server\share, and any leading
+			// separator of a path outside the targets
+			root = filepath.VolumeName(dir)
+			if strings.HasPrefix(dir[len(root):], sep) {
+				root += sep
+			}
+		}
+		rel := strings.TrimPrefix(dir, root)
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) > groupDepth {
+			parts = parts[:groupDepth]
+		}
+		return filepath.Join(root, filepath.FromSlash(strings.Join(parts, "/")))
+	},
+	"module": func(f fileLines) string {
+		if mod := moduleFor(filepath.Dir(f.filename)); mod.path != "" {
+			return mod.path
+		}
+		return "(none)"
+	},
+	"test": func(f fileLines) string {
+		if isTestFile(f.filename) {
+			return "test"
+		}
+		return "non-test"
+	},
+}
+
+// groupResults sums files into a row per group, in group order
+func groupResults(files []fileLines, key func(f fileLines) string) []fileLines {
+	groups := map[string]*fileLines{}
+	var names []string
+	for _, f := range files {
+		name := key(f)
+		if groups[name] == nil {
+			groups[name] = &fileLines{filename: name}
+			names = append(names, name)
+		}
+		groups[name].join(f)
+		groups[name].files++
+	}
+	sort.Strings(names)
+
+	var res []fileLines
+	for _, name := range names {
+		res = append(res, *groups[name])
+	}
+	return res
+}
+
+// sortKeys order the report's rows by --sort, ties going by name
+var sortKeys = map[string]func(f fileLines) int{
+	"code":    func(f fileLines) int { return f.codeLines },
+	"comment": func(f fileLines) int { return f.commentLines },
+	"blank":   func(f fileLines) int { return f.whitespaceLines },
+	"name":    nil,
+}
+
+var (
+	sortBy   = "name"
+	sortDesc bool
+	topN     int // with --top, only the first rows are reported
+
+	// with --summary, only the total is reported, in whatever format
+	summaryOnly bool
+)
+
+// sortRows orders rows by --sort, largest first with --desc
+func sortRows(rows []fileLines) {
+	key := sortKeys[sortBy]
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if sortDesc {
+			a, b = b, a
+		}
+		if key != nil && key(a) != key(b) {
+			return key(a) < key(b)
+		}
+		return a.filename < b.filename
+	})
+}
+
+// reportOutput is where reports are written; logs always go to stderr
+var reportOutput io.Writer = os.Stdout
+
+// streamers write each result as soon as it's counted, for formats whose
+// consumers shouldn't have to wait for the whole run
+var streamers = map[string]func(w io.Writer, f fileLines) error{
+	"ndjson": streamNDJSON,
+}
+
+// streamResult is the selected format's streamer, if it has one
+var streamResult func(w io.Writer, f fileLines) error
+
+// reportCopy is the report in another format, written to a file of its own
+// by --json FILE, --csv FILE and the like alongside the main one
+type reportCopy struct {
+	path   string
+	render renderer
+}
+
+var reportCopies []reportCopy
+
+// writeReportCopies writes the rows of the main report in each other format
+// asked for. Colors are for terminals, the files are written without them.
+func writeReportCopies(rows []fileLines, total fileLines) {
+	colored := colorTables
+	colorTables = false
+	defer func() { colorTables = colored }()
+	for _, c := range reportCopies {
+		out, err := os.Create(c.path)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if err := c.render(out, rows, total); err != nil {
+			log.Errorf("%s: %v", c.path, err)
+		}
+		if err := out.Close(); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// backstageEntity is the entity ref facts are reported for, e.g.
+// component:default/payments; defaults to the working directory's name
+var backstageEntity string
+
+// showLineEndings adds a column with each file's dominant line ending
+var showLineEndings bool
+
+// displayPath makes a filename safe to print in a text report: paths with
+// invalid UTF-8, newlines or other control characters are shown Go-quoted
+func displayPath(name string) string {
+	if !utf8.ValidString(name) || strings.IndexFunc(name, func(r rune) bool { return !unicode.IsPrint(r) && r != ' ' }) >= 0 {
+		return strconv.Quote(name)
+	}
+	return name
+}
+
+// renderTable prints a row per file, or per group with --group-by, and
+// follows a per-file table spanning several languages with a summary of each
+func renderTable(w io.Writer, files []fileLines, total fileLines) error {
+	if groupBy != "file" {
+		writeTable(w, strings.ToUpper(groupBy), files, total, true)
+		return nil
+	}
+
+	writeTable(w, "FILENAME", files, total, false)
+	// the summaries would only cover the rows shown, unlike the total, and
+	// mean little for deltas
+	if topN > 0 || signedCounts {
+		return nil
+	}
+	if langs := groupResults(files, groupKeys["language"]); len(langs) > 1 {
+		writeTable(w, "LANGUAGE", langs, total, true)
+	}
+	if tests := groupResults(files, groupKeys["test"]); len(tests) > 1 {
+		writeTable(w, "TEST", tests, total, true)
+		fmt.Fprintf(w, "test to code ratio: %.2f\n", ratio(tests[1].codeLines, tests[0].codeLines))
+	}
+	return nil
+}
+
+// tableColumn is a metric the table can show, by its --columns name
+type tableColumn struct {
+	key, title string
+	value      func(f, total fileLines) string
+}
+
+var tableColumns = []tableColumn{
+	{"files", "Files", func(f, _ fileLines) string { return tableCount(f.files) }},
+	{"blank", "White Space", func(f, _ fileLines) string { return tableCount(f.whitespaceLines) }},
+	{"comment", "Comment", func(f, _ fileLines) string { return tableCount(f.commentLines) }},
+	{"code", "Code", func(f, _ fileLines) string { return tableCount(f.codeLines) }},
+	{"license", "License", func(f, _ fileLines) string { return tableCount(f.licenseLines) }},
+	{"directive", "Directive", func(f, _ fileLines) string { return tableCount(f.directiveLines) }},
+	{"mixed", "Mixed", func(f, _ fileLines) string { return tableCount(f.mixedLines) }},
+	{"cgo", "Cgo", func(f, _ fileLines) string { return tableCount(f.cgoLines) }},
+	{"eol", "EOL", func(f, _ fileLines) string { return f.lineEnding }},
+	{"density", "Comment %", func(f, _ fileLines) string { return percent(f.commentLines, f.codeLines+f.commentLines) }},
+	{"tags", "Tags", func(f, _ fileLines) string { return tableCount(tagCount(f)) }},
+	{"max-complexity", "Max Cyclo", func(f, _ fileLines) string { return tableCount(maxComplexity(f)) }},
+	{"avg-complexity", "Avg Cyclo", func(f, _ fileLines) string { return strconv.FormatFloat(averageComplexity(f), 'f', 1, 64) }},
+	{"uloc", "ULOC", func(f, _ fileLines) string { return tableCount(len(f.unique)) }},
+	{"logical", "Logical", func(f, _ fileLines) string { return tableCount(f.logicalLines) }},
+	{"funcs", "Funcs", func(f, _ fileLines) string { return tableCount(f.decls.Funcs) }},
+	{"methods", "Methods", func(f, _ fileLines) string { return tableCount(f.decls.Methods) }},
+	{"types", "Types", func(f, _ fileLines) string { return tableCount(f.decls.Types) }},
+	{"exported", "Exported", func(f, _ fileLines) string { return tableCount(f.decls.Exported) }},
+	{"documented", "Documented", func(f, _ fileLines) string { return tableCount(f.decls.Documented) }},
+	{"doc-coverage", "Doc %", func(f, _ fileLines) string { return percent(f.decls.Documented, f.decls.Funcs+f.decls.Methods) }},
+	{"max-line", "Max Len", func(f, _ fileLines) string { longest, _, _ := f.lengths.stats(); return tableCount(longest) }},
+	{"avg-line", "Avg Len", func(f, _ fileLines) string {
+		_, mean, _ := f.lengths.stats()
+		return strconv.FormatFloat(mean, 'f', 1, 64)
+	}},
+	{"p95-line", "P95 Len", func(f, _ fileLines) string { _, _, p95 := f.lengths.stats(); return tableCount(p95) }},
+	{"share", "Code %", func(f, total fileLines) string { return percent(f.codeLines, total.codeLines) }},
+}
+
+// humanNumbers is how --human writes the table's counts: "" for plain
+// integers, "commas" with thousands separators, "compact" as in 12.4k
+var humanNumbers humanFlag
+
+// signedCounts marks increases with a +, for tables of deltas
+var signedCounts bool
+
+func tableCount(n int) string {
+	var s string
+	switch humanNumbers {
+	case "commas":
+		s = thousands(n)
+	case "compact":
+		s = compactCount(n)
+	default:
+		s = strconv.Itoa(n)
+	}
+	if signedCounts && n > 0 {
+		s = "+" + s
+	}
+	return s
+}
+
+// thousands writes n with commas between groups of three digits
+func thousands(n int) string {
+	s := strconv.Itoa(n)
+	sign := ""
+	if n < 0 {
+		sign, s = "-", s[1:]
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return sign + s
+}
+
+// colorMode is --color: auto colors output going to a terminal, unless
+// NO_COLOR is set
+var colorMode = "auto"
+
+// colorTables is set when the report's tables are colored
+var colorTables bool
+
+// colorThresholds are the file budgets whose cells are shown in red; without
+// any, files over 1000 lines are
+var colorThresholds []threshold
+
+func useColor(f *os.File) bool {
+	switch colorMode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	return os.Getenv("NO_COLOR") == "" && isTerminal(f)
+}
+
+// cellColors colors a file's cells that break a budget, the filename for a
+// budget on its lines, and with --signed increases green and decreases red
+func cellColors(f fileLines, row []string, cols []tableColumn, grouped bool) []tablewriter.Colors {
+	res := make([]tablewriter.Colors, len(row))
+	if signedCounts {
+		for i := 1; i < len(row); i++ {
+			if strings.HasPrefix(row[i], "+") {
+				res[i] = tablewriter.Colors{tablewriter.FgGreenColor}
+			} else if strings.HasPrefix(row[i], "-") {
+				res[i] = tablewriter.Colors{tablewriter.FgRedColor}
+			}
+		}
+		return res
+	}
+	if grouped {
+		return res
+	}
+	thresholds := colorThresholds
+	if len(thresholds) == 0 {
+		thresholds = []threshold{{scope: "file", metric: "lines", op: ">", limit: 1000}}
+	}
+	for _, t := range thresholds {
+		if !t.fails(f) {
+			continue
+		}
+		if t.metric == "lines" {
+			res[0] = tablewriter.Colors{tablewriter.FgRedColor}
+		}
+		for i, c := range cols {
+			if c.key == t.metric {
+				res[i+1] = tablewriter.Colors{tablewriter.FgRedColor}
+			}
+		}
+	}
+	return res
+}
+
+// with --density, the comment density and share of code columns are shown
+var showDensity bool
+
+func percent(a, b int) string {
+	return strconv.FormatFloat(100*ratio(a, b), 'f', 1, 64) + "%"
+}
+
+// columns is set by --columns, replacing the default choice
+var columns []string
+
+// defaultColumns are the line counts plus those the counting options add
+func defaultColumns(grouped bool) []string {
+	res := []string{"blank", "comment", "code"}
+	if grouped {
+		res = append([]string{"files"}, res...)
+	}
+	if countLicenses {
+		res = append(res, "license")
+	}
+	if directiveMode == "separate" {
+		res = append(res, "directive")
+	}
+	if mixedMode == "separate" {
+		res = append(res, "mixed")
+	}
+	if showDensity {
+		res = append(res, "density", "share")
+	}
+	if showLineEndings && !grouped {
+		res = append(res, "eol")
+	}
+	if tagPattern != nil {
+		res = append(res, "tags")
+	}
+	if measureComplexity {
+		res = append(res, "max-complexity", "avg-complexity")
+	}
+	if measureLineLengths {
+		res = append(res, "max-line", "avg-line", "p95-line")
+	}
+	if countUnique {
+		res = append(res, "uloc")
+	}
+	if countLogical {
+		res = append(res, "logical")
+	}
+	if countDecls {
+		res = append(res, "funcs", "methods", "types", "exported", "documented", "doc-coverage")
+	}
+	return res
+}
+
+func tableColumnKeys() []string {
+	var res []string
+	for _, c := range tableColumns {
+		res = append(res, c.key)
+	}
+	return res
+}
+
+// tableColumnsFor picks the columns of a table of files, or of groups
+func tableColumnsFor(grouped bool) []tableColumn {
+	keys := columns
+	if len(keys) == 0 {
+		keys = defaultColumns(grouped)
+	}
+	var cols []tableColumn
+	for _, key := range keys {
+		i := slices.IndexFunc(tableColumns, func(c tableColumn) bool { return c.key == key })
+		// files only means something for grouped rows, line endings for files
+		if i >= 0 && !(key == "files" && !grouped) && !(key == "eol" && grouped) {
+			cols = append(cols, tableColumns[i])
+		}
+	}
+	return cols
+}
+
+func writeTable(w io.Writer, name string, rows []fileLines, total fileLines, grouped bool) {
+	cols := tableColumnsFor(grouped)
+
+	total.files = 0
+	for _, row := range rows {
+		total.files += row.files
+	}
+
+	header := []string{name}
+	footer := []string{total.filename}
+	for _, c := range cols {
+		header = append(header, c.title)
+		footer = append(footer, c.value(total, total))
+	}
+
+	var data [][]string
+	var colors [][]tablewriter.Colors
+	for _, f := range rows {
+		row := []string{displayPath(f.filename)}
+		for _, c := range cols {
+			row = append(row, c.value(f, total))
+		}
+		data = append(data, row)
+		if colorTables {
+			colors = append(colors, cellColors(f, row, cols, grouped))
+		}
+	}
+	bold := make([]tablewriter.Colors, len(footer))
+	for i := range bold {
+		bold[i] = tablewriter.Colors{tablewriter.Bold}
+	}
+
+	// print table
+	fmt.Fprintln(w)
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(header)
+	if len(data) > 0 {
+		table.SetFooter(footer)
+		if colorTables {
+			table.SetFooterColor(bold...)
+		}
+	} else {
+		// with --summary, the total is the whole table
+		data = [][]string{footer}
+		colors = [][]tablewriter.Colors{bold}
+	}
+	table.SetBorder(false)
+	if signedCounts || colorTables {
+		// neither "+1" nor a colored count is taken for a number, keep the
+		// counts right-aligned
+		align := []int{tablewriter.ALIGN_LEFT}
+		for range cols {
+			align = append(align, tablewriter.ALIGN_RIGHT)
+		}
+		table.SetColumnAlignment(align)
+	}
+	if colorTables {
+		for i, row := range data {
+			table.Rich(row, colors[i])
+		}
+	} else {
+		table.AppendBulk(data)
+	}
+	table.Render()
+}
+
+// streamTableAfter is --stream-table-after: a table of files that grows
+// past this many rows is written as they're counted rather than held for
+// one sorted table, 0 to never
+var streamTableAfter = 20000
+
+// streamTable is set when the run's table could be streamed: one of files,
+// in name order, with nothing after it that needs them all
+var streamTable bool
+
+// tableStream writes a table of files a row at a time, its column widths
+// fixed by the rows it starts with; the rows after those come in the order
+// they're counted
+type tableStream struct {
+	w      io.Writer
+	cols   []tableColumn
+	widths []int
+}
+
+// newTableStream writes the header and the sorted rows so far
+func newTableStream(w io.Writer, files []fileLines) *tableStream {
+	this := &tableStream{w: w, cols: tableColumnsFor(false)}
+	header := []string{"FILENAME"}
+	for _, c := range this.cols {
+		header = append(header, strings.ToUpper(c.title))
+	}
+	this.widths = make([]int, len(header))
+	rows := [][]string{header}
+	for _, f := range files {
+		rows = append(rows, this.cells(f))
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			this.widths[i] = max(this.widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	fmt.Fprintln(w)
+	this.line(header, true)
+	this.rule()
+	for _, row := range rows[1:] {
+		this.line(row, false)
+	}
+	return this
+}
+
+// cells are a file's row; no column of the stream depends on the total
+func (this *tableStream) cells(f fileLines) []string {
+	row := []string{displayPath(f.filename)}
+	for _, c := range this.cols {
+		row = append(row, c.value(f, fileLines{}))
+	}
+	return row
+}
+
+func (this *tableStream) line(row []string, header bool) {
+	var b strings.Builder
+	for i, cell := range row {
+		if i > 0 {
+			b.WriteString("|")
+		}
+		pad := strings.Repeat(" ", max(this.widths[i]-utf8.RuneCountInString(cell), 0))
+		switch {
+		case i == 0:
+			b.WriteString("  " + cell + pad + " ")
+		case header:
+			b.WriteString(" " + cell + pad + " ")
+		default:
+			b.WriteString(" " + pad + cell + " ")
+		}
+	}
+	fmt.Fprintln(this.w, b.String())
+}
+
+func (this *tableStream) rule() {
+	var parts []string
+	for i, width := range this.widths {
+		extra := 2
+		if i == 0 {
+			extra = 3
+		}
+		parts = append(parts, strings.Repeat("-", width+extra))
+	}
+	fmt.Fprintln(this.w, strings.Join(parts, "+"))
+}
+
+func (this *tableStream) add(f fileLines) {
+	this.line(this.cells(f), false)
+}
+
+// close writes the total below the rows
+func (this *tableStream) close(total fileLines) {
+	this.rule()
+	row := this.cells(total)
+	row[0] = total.filename
+	this.line(row, false)
+}
+
+type jsonReport struct {
+	Files []jsonLines `json:"files"`
+	Total jsonLines   `json:"total"`
+}
+
+func renderJSON(w io.Writer, files []fileLines, total fileLines) error {
+	report := jsonReport{Files: []jsonLines{}, Total: total.toJSON()}
+	for _, f := range files {
+		report.Files = append(report.Files, f.toJSON())
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// ndjsonRecord is one line of NDJSON output, a file or the closing total
+type ndjsonRecord struct {
+	Type string `json:"type"`
+	jsonLines
+}
+
+func streamNDJSON(w io.Writer, f fileLines) error {
+	return json.NewEncoder(w).Encode(ndjsonRecord{Type: "file", jsonLines: f.toJSON()})
+}
+
+// renderNDJSON writes the total after the files streamed as they came, or
+// after the groups when the report is grouped
+func renderNDJSON(w io.Writer, files []fileLines, total fileLines) error {
+	enc := json.NewEncoder(w)
+	if streamResult == nil {
+		for _, f := range files {
+			if err := enc.Encode(ndjsonRecord{Type: groupBy, jsonLines: f.toJSON()}); err != nil {
+				return err
+			}
+		}
+	}
+	return enc.Encode(ndjsonRecord{Type: "total", jsonLines: total.toJSON()})
+}
+
+// tokei's JSON output, keyed by language with a "Total" entry, so tools that
+// already read tokei can read this
+type tokeiStats struct {
+	Blanks   int                   `json:"blanks"`
+	Code     int                   `json:"code"`
+	Comments int                   `json:"comments"`
+	Blobs    map[string]tokeiStats `json:"blobs"`
+}
+
+type tokeiReport struct {
+	Name  string     `json:"name"`
+	Stats tokeiStats `json:"stats"`
+}
+
+type tokeiLanguage struct {
+	Blanks     int                      `json:"blanks"`
+	Code       int                      `json:"code"`
+	Comments   int                      `json:"comments"`
+	Reports    []tokeiReport            `json:"reports"`
+	Children   map[string][]tokeiReport `json:"children"`
+	Inaccurate bool                     `json:"inaccurate"`
+}
+
+func renderTokei(w io.Writer, files []fileLines, total fileLines) error {
+	report := map[string]tokeiLanguage{}
+	sum := tokeiLanguage{
+		Blanks:   total.whitespaceLines,
+		Code:     total.codeLines,
+		Comments: total.commentLines,
+		Reports:  []tokeiReport{},
+		Children: map[string][]tokeiReport{},
+	}
+	for _, f := range files {
+		lang, ok := report[f.language]
+		if !ok {
+			lang = tokeiLanguage{Reports: []tokeiReport{}, Children: map[string][]tokeiReport{}}
+		}
+		lang.Blanks += f.whitespaceLines
+		lang.Code += f.codeLines
+		lang.Comments += f.commentLines
+		lang.Reports = append(lang.Reports, tokeiReport{
+			Name:  f.filename,
+			Stats: tokeiStats{Blanks: f.whitespaceLines, Code: f.codeLines, Comments: f.commentLines, Blobs: map[string]tokeiStats{}},
+		})
+		report[f.language] = lang
+	}
+	for name, lang := range report {
+		sum.Children[name] = lang.Reports
+	}
+	report["Total"] = sum
+	return json.NewEncoder(w).Encode(report)
+}
+
+// renderDelimited writes a header, a row per file and a totals row, quoted
+// as needed so filenames with spaces or separators survive
+func renderDelimited(comma rune) renderer {
+	return func(w io.Writer, files []fileLines, total fileLines) error {
+		out := csv.NewWriter(w)
+		out.Comma = comma
+		// the license, directive and mixed columns follow when they're
+		// counted apart, as in the table
+		header := []string{"filename", "whitespace", "comment", "code"}
+		if countLicenses {
+			header = append(header, "license")
+		}
+		if directiveMode == "separate" {
+			header = append(header, "directive")
+		}
+		if mixedMode == "separate" {
+			header = append(header, "mixed")
+		}
+		row := func(f fileLines) []string {
+			res := []string{f.filename, strconv.Itoa(f.whitespaceLines), strconv.Itoa(f.commentLines), strconv.Itoa(f.codeLines)}
+			if countLicenses {
+				res = append(res, strconv.Itoa(f.licenseLines))
+			}
+			if directiveMode == "separate" {
+				res = append(res, strconv.Itoa(f.directiveLines))
+			}
+			if mixedMode == "separate" {
+				res = append(res, strconv.Itoa(f.mixedLines))
+			}
+			return res
+		}
+
+		out.Write(header)
+		for _, f := range files {
+			out.Write(row(f))
+		}
+		out.Write(row(total))
+		out.Flush()
+		return out.Error()
+	}
+}
+
+// newLineTemplate renders a text/template once per file and once for the
+// total, each on its own line; fields are those of the JSON output
+func newLineTemplate(text string) (renderer, error) {
+	tmpl, err := template.New("line").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return func(w io.Writer, files []fileLines, total fileLines) error {
+		for _, f := range append(files, total) {
+			var b bytes.Buffer
+			if err := tmpl.Execute(&b, f.toJSON()); err != nil {
+				return err
+			}
+			if !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+				b.WriteByte('\n')
+			}
+			if _, err := w.Write(b.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// newReportTemplate renders a template file once for the whole run, given
+// the same .Files and .Total as the JSON output
+func newReportTemplate(path string) (renderer, error) {
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	return func(w io.Writer, files []fileLines, total fileLines) error {
+		report := jsonReport{Files: []jsonLines{}, Total: total.toJSON()}
+		for _, f := range files {
+			report.Files = append(report.Files, f.toJSON())
+		}
+		return tmpl.Execute(w, report)
+	}, nil
+}
+
+// renderOneline prints just the totals, compactly enough for a status bar
+// or prompt
+func renderOneline(w io.Writer, files []fileLines, total fileLines) error {
+	// name the language when there's only one
+	label := "all"
+	langs := languageTotals(files)
+	if len(langs) == 1 {
+		for name := range langs {
+			label = strings.ToLower(name)
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s: %s code / %s comments / %s blank\n", label,
+		compactCount(total.codeLines), compactCount(total.commentLines), compactCount(total.whitespaceLines))
+	return err
+}
+
+// compactCount abbreviates thousands and millions, e.g. 45.2k
+func compactCount(n int) string {
+	switch {
+	case n >= 1000000:
+		return strconv.FormatFloat(float64(n)/1000000, 'f', 1, 64) + "M"
+	case n >= 1000:
+		return strconv.FormatFloat(float64(n)/1000, 'f', 1, 64) + "k"
+	}
+	return strconv.Itoa(n)
+}
+
+// SonarQube generic import: per-file measures plus external issues
+type sonarReport struct {
+	Measures []sonarMeasure `json:"measures"`
+	Issues   []sonarIssue   `json:"issues"`
+}
+
+type sonarMeasure struct {
+	Component string         `json:"component"`
+	Metrics   map[string]int `json:"metrics"`
+}
+
+type sonarIssue struct {
+	EngineID        string        `json:"engineId"`
+	RuleID          string        `json:"ruleId"`
+	Severity        string        `json:"severity"`
+	Type            string        `json:"type"`
+	PrimaryLocation sonarLocation `json:"primaryLocation"`
+}
+
+type sonarLocation struct {
+	Message  string `json:"message"`
+	FilePath string `json:"filePath"`
+}
+
+func renderSonar(w io.Writer, files []fileLines, total fileLines) error {
+	report := sonarReport{Measures: []sonarMeasure{}, Issues: []sonarIssue{}}
+	for _, f := range files {
+		report.Measures = append(report.Measures, sonarMeasure{
+			Component: f.filename,
+			Metrics: map[string]int{
+				"ncloc":         f.codeLines,
+				"comment_lines": f.commentLines,
+				"lines":         f.codeLines + f.commentLines + f.whitespaceLines,
+			},
+		})
+	}
+	// budgets broken and comments too sparse, as external issues
+	for _, f := range append(budgetFindings(files), densityFindings(files)...) {
+		report.Issues = append(report.Issues, sonarIssue{
+			EngineID:        "sloc",
+			RuleID:          f.rule,
+			Severity:        "MAJOR",
+			Type:            "CODE_SMELL",
+			PrimaryLocation: sonarLocation{Message: f.message, FilePath: f.path},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// Backstage Tech Insights fact document
+type backstageFacts struct {
+	Entity    backstageEntityRef `json:"entity"`
+	Timestamp time.Time          `json:"timestamp"`
+	Facts     backstageFactSet   `json:"facts"`
+}
+
+type backstageEntityRef struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+type backstageFactSet struct {
+	Files          int                          `json:"files"`
+	CodeLines      int                          `json:"codeLines"`
+	CommentLines   int                          `json:"commentLines"`
+	BlankLines     int                          `json:"blankLines"`
+	Languages      map[string]backstageLanguage `json:"languages"`
+	TestRatio      float64                      `json:"testRatio"`
+	CommentDensity float64                      `json:"commentDensity"`
+}
+
+type backstageLanguage struct {
+	Files        int `json:"files"`
+	CodeLines    int `json:"codeLines"`
+	CommentLines int `json:"commentLines"`
+	BlankLines   int `json:"blankLines"`
+}
+
+func parseEntityRef(ref string) backstageEntityRef {
+	entity := backstageEntityRef{Kind: "component", Namespace: "default", Name: ref}
+	if i := strings.Index(entity.Name, ":"); i >= 0 {
+		entity.Kind, entity.Name = entity.Name[:i], entity.Name[i+1:]
+	}
+	if i := strings.Index(entity.Name, "/"); i >= 0 {
+		entity.Namespace, entity.Name = entity.Name[:i], entity.Name[i+1:]
+	}
+	return entity
+}
+
+// languageTotal sums the files in one language
+type languageTotal struct {
+	fileLines
+	files int
+}
+
+func languageTotals(files []fileLines) map[string]*languageTotal {
+	res := map[string]*languageTotal{}
+	for _, f := range files {
+		if res[f.language] == nil {
+			res[f.language] = &languageTotal{fileLines: fileLines{filename: f.language, language: f.language}}
+		}
+		res[f.language].join(f)
+		res[f.language].files++
+	}
+	return res
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+// countedFiles are all the files a run counted, which --group-by, --top and
+// --summary narrow the rows of the report down from
+var countedFiles []fileLines
+
+// renderBackstage reports facts about everything counted, whatever rows
+// the report is narrowed to
+func renderBackstage(w io.Writer, files []fileLines, total fileLines) error {
+	if countedFiles != nil {
+		files = countedFiles
+	}
+
+	ref := backstageEntity
+	if ref == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		ref = filepath.Base(cwd)
+	}
+
+	var testCode int
+	for _, f := range files {
+		if isTestFile(f.filename) {
+			testCode += f.codeLines
+		}
+	}
+	languages := map[string]backstageLanguage{}
+	for name, lang := range languageTotals(files) {
+		languages[name] = backstageLanguage{
+			Files:        lang.files,
+			CodeLines:    lang.codeLines,
+			CommentLines: lang.commentLines,
+			BlankLines:   lang.whitespaceLines,
+		}
+	}
+
+	doc := backstageFacts{
+		Entity:    parseEntityRef(ref),
+		Timestamp: time.Now().UTC(),
+		Facts: backstageFactSet{
+			Files:          len(files),
+			CodeLines:      total.codeLines,
+			CommentLines:   total.commentLines,
+			BlankLines:     total.whitespaceLines,
+			Languages:      languages,
+			TestRatio:      ratio(testCode, total.codeLines-testCode),
+			CommentDensity: ratio(total.commentLines, total.codeLines+total.commentLines),
+		},
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
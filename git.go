@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// with --git-tracked, the files git tracks are counted instead of those a
+// walk finds; --changed and --staged narrow them to the files a change
+// touches
+var gitTracked, gitChanged, gitStaged bool
+
+// gitSelecting reports whether git rather than a walk picks the files
+func gitSelecting() bool {
+	return gitTracked || gitChanged || gitStaged
+}
+
+// gitSelectedFiles lists the files under root the git flags pick: tracked
+// ones, those changed since HEAD along with new untracked ones, or those
+// changed in the index
+func gitSelectedFiles(root string) ([]string, error) {
+	switch {
+	case gitStaged:
+		return gitFiles(root, "diff", "--cached", "--name-only", "--relative", "--diff-filter=d")
+	case gitChanged:
+		changed, err := gitFiles(root, "diff", "HEAD", "--name-only", "--relative", "--diff-filter=d")
+		if err != nil {
+			return nil, err
+		}
+		untracked, err := gitFiles(root, "ls-files", "--others", "--exclude-standard")
+		return append(changed, untracked...), err
+	}
+	return gitFiles(root, "ls-files")
+}
+
+// gitFiles runs a git command listing paths with -z under root and returns
+// them joined to root
+func gitFiles(root string, args ...string) ([]string, error) {
+	dir, pathspec := root, "."
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		dir, pathspec = filepath.Dir(root), filepath.Base(root)
+	}
+	cmd := exec.Command("git", append(append([]string{"-C", dir}, args...), "-z", "--", pathspec)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s in %s: %v: %s", args[0], dir, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var res []string
+	for _, name := range strings.Split(string(out), "\x00") {
+		if name != "" {
+			res = append(res, filepath.Join(dir, filepath.FromSlash(name)))
+		}
+	}
+	return res, nil
+}
+
+// countGitFiles counts paths git listed under root, applying the --include
+// and --exclude filters but none of the walk's directory skipping
+func countGitFiles(root string, paths []string, process func(path string, info os.FileInfo, err error) error) {
+	for _, path := range paths {
+		rel, _ := filepath.Rel(root, path)
+		if excludedPath(filepath.ToSlash(rel)) {
+			continue
+		}
+		if included, _ := includeFilter.match(filepath.ToSlash(rel), false); len(includeFilter) > 0 && !included {
+			continue
+		}
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			// deleted in the working tree but not yet in the index
+			log.Debug("skipping missing tracked file", path)
+			continue
+		}
+		process(path, info, err)
+	}
+}
+
+// remoteClones maps the temporary clones of remote targets to the targets
+// they were cloned from, which their files are named by
+var remoteClones = map[string]string{}
+
+// isGitURL reports whether a target is a repository to clone, such as
+// https://github.com/org/repo.git@v1.2 or git@github.com:org/repo.git
+func isGitURL(target string) bool {
+	for _, scheme := range []string{"https://", "http://", "ssh://", "git://", "file://", "git@"} {
+		if strings.HasPrefix(target, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// cloneRemote fetches just the commit a remote target names, its default
+// branch unless a ref follows an @ at the end, into a temporary directory
+func cloneRemote(target string) (string, error) {
+	url, ref := target, "HEAD"
+	if at := strings.LastIndex(target, "@"); at > strings.LastIndex(target, "/") && at > strings.LastIndex(target, ":") {
+		url, ref = target[:at], target[at+1:]
+	}
+	// git would take it for one of its options
+	if strings.HasPrefix(ref, "-") {
+		return "", usageErrorf("%s: a ref can't start with -", target)
+	}
+
+	dir, err := os.MkdirTemp("", "sloc-clone-")
+	if err != nil {
+		return "", err
+	}
+	log.Infof("fetching %s at %s", url, ref)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"fetch", "-q", "--depth", "1", "--end-of-options", url, ref},
+		{"checkout", "-q", "FETCH_HEAD"},
+	} {
+		if _, err := gitOutput(append([]string{"-C", dir}, args...)...); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	remoteClones[dir] = target
+	return dir, nil
+}
+
+// remoteName names a file of a clone after its target, as in
+// https://github.com/org/repo.git//cmd/main.go
+func remoteName(path string) string {
+	for dir, target := range remoteClones {
+		if rel, ok := strings.CutPrefix(path, dir+string(filepath.Separator)); ok {
+			return target + "//" + filepath.ToSlash(rel)
+		}
+	}
+	return path
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/mod/modfile"
+)
+
+// goModule is the Go module a directory belongs to
+type goModule struct {
+	dir, path string // path is "" outside any module
+}
+
+// modules remembers the module found for each directory looked at
+var modules = struct {
+	sync.Mutex
+	byDir map[string]goModule
+}{byDir: map[string]goModule{}}
+
+// moduleFor finds the closest go.mod at or above dir, as the go command would
+func moduleFor(dir string) goModule {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return goModule{}
+	}
+	modules.Lock()
+	mod, ok := modules.byDir[abs]
+	modules.Unlock()
+	if ok {
+		return mod
+	}
+
+	if data, err := os.ReadFile(filepath.Join(abs, "go.mod")); err == nil {
+		mod = goModule{dir: abs, path: modfile.ModulePath(data)}
+		if mod.path == "" {
+			log.Debugf("%s: no module directive", filepath.Join(abs, "go.mod"))
+		}
+	} else if parent := filepath.Dir(abs); parent != abs {
+		mod = moduleFor(parent)
+	}
+
+	modules.Lock()
+	modules.byDir[abs] = mod
+	modules.Unlock()
+	return mod
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// grafanaKinds are the totals each target's runs record, served as the
+// metrics "<target>.code" and so on
+var grafanaKinds = []string{"files", "code", "comment", "whitespace"}
+
+type grafanaSearch struct {
+	Target string `json:"target"`
+}
+
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+// grafanaSeries is a metric's points as [value, unix milliseconds]
+type grafanaSeries struct {
+	Target     string     `json:"target"`
+	Datapoints [][2]int64 `json:"datapoints"`
+}
+
+// grafanaRoutes serve the runs recorded in the SQLite database to
+// Grafana's simple JSON datasource: / answers its connection test, /search
+// lists the metrics and /query their points in the dashboard's time range
+func grafanaRoutes(db *sql.DB) []apiRoute {
+	return []apiRoute{
+		{method: "GET", path: "/", summary: "connection test for the Grafana simple JSON datasource", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok\n"))
+		}},
+		{method: "POST", path: "/search", summary: "metrics recorded in the runs database, filtered by the target in the body", request: grafanaSearch{}, response: []string{}, errors: []int{http.StatusBadRequest}, handler: func(w http.ResponseWriter, r *http.Request) {
+			handleGrafanaSearch(db, w, r)
+		}},
+		{method: "POST", path: "/query", summary: "time series of the metrics in the body over its range", request: grafanaQuery{}, response: []grafanaSeries{}, errors: []int{http.StatusBadRequest}, handler: func(w http.ResponseWriter, r *http.Request) {
+			handleGrafanaQuery(db, w, r)
+		}},
+	}
+}
+
+func handleGrafanaSearch(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	var req grafanaSearch
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`SELECT DISTINCT target FROM runs ORDER BY target`)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	metrics := []string{}
+	for rows.Next() {
+		var target string
+		if err := rows.Scan(&target); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, kind := range grafanaKinds {
+			if metric := target + "." + kind; strings.Contains(metric, req.Target) {
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, r, metrics)
+}
+
+func handleGrafanaQuery(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	var req grafanaQuery
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res := []grafanaSeries{}
+	for _, t := range req.Targets {
+		series, err := grafanaPoints(db, t.Target, req.Range.From, req.Range.To)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res = append(res, series)
+	}
+	writeJSON(w, r, res)
+}
+
+// grafanaPoints reads a metric's runs between from and to, oldest first; a
+// zero end leaves that side open
+func grafanaPoints(db *sql.DB, metric string, from, to time.Time) (grafanaSeries, error) {
+	series := grafanaSeries{Target: metric, Datapoints: [][2]int64{}}
+	dot := strings.LastIndex(metric, ".")
+	column := metric[dot+1:]
+	if dot < 0 || !slices.Contains(grafanaKinds, column) {
+		return series, fmt.Errorf("unknown metric %q, want <target>.%s", metric, strings.Join(grafanaKinds, "|"))
+	}
+
+	if from.IsZero() {
+		from = time.Unix(0, 0)
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	// column is one of grafanaKinds, so safe to put in the query; times are
+	// stored in UTC, so the range must be too for them to compare
+	rows, err := db.Query(`SELECT time, `+column+` FROM runs WHERE target = ? AND time BETWEEN ? AND ? ORDER BY time`,
+		metric[:dot], from.UTC(), to.UTC())
+	if err != nil {
+		return series, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var at time.Time
+		var value int64
+		if err := rows.Scan(&at, &value); err != nil {
+			return series, err
+		}
+		series.Datapoints = append(series.Datapoints, [2]int64{value, at.UnixMilli()})
+	}
+	return series, rows.Err()
+}
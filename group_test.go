@@ -0,0 +1,45 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// withGroupDepth sets --group-by dir:N's depth and targets for a test
+func withGroupDepth(t *testing.T, depth int, roots ...string) {
+	oldDepth, oldRoots := groupDepth, groupRoots
+	t.Cleanup(func() { groupDepth, groupRoots = oldDepth, oldRoots })
+	groupDepth, groupRoots = depth, roots
+}
+
+func TestGroupDir(t *testing.T) {
+	cases := []struct {
+		depth int
+		roots []string
+		path  string
+		want  string
+	}{
+		{0, nil, "a/b/c/x.go", "a/b/c"},
+		{1, nil, "a/b/c/x.go", "a"},
+		{2, nil, "a/b/c/x.go", "a/b"},
+		{5, nil, "a/b/c/x.go", "a/b/c"},
+		{1, nil, "x.go", "."},
+		{1, nil, "/src/a/x.go", "/src"},
+		{1, []string{"/src"}, "/src/a/b/x.go", "/src/a"},
+		{1, []string{"/src"}, "/src/x.go", "/src"},
+		{1, []string{"/"}, "/a/b/x.go", "/a"},
+		{1, []string{"/src", "/src/a"}, "/src/a/b/c/x.go", "/src/a/b"},
+		{1, []string{"/src"}, "/srcs/a/x.go", "/srcs"},
+	}
+	for _, tc := range cases {
+		var roots []string
+		for _, root := range tc.roots {
+			roots = append(roots, filepath.FromSlash(root))
+		}
+		withGroupDepth(t, tc.depth, roots...)
+		path := filepath.FromSlash(tc.path)
+		if got, want := groupKeys["dir"](fileLines{filename: path}), filepath.FromSlash(tc.want); got != want {
+			t.Errorf("dir:%d of %s under %v: got %s, want %s", tc.depth, path, roots, got, want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+// TestGroupDirVolume counts dir:N levels on Windows from the drive or UNC
+// share, which aren't levels themselves
+func TestGroupDirVolume(t *testing.T) {
+	cases := []struct {
+		depth int
+		roots []string
+		path  string
+		want  string
+	}{
+		{1, nil, `C:\src\a\x.go`, `C:\src`},
+		{2, nil, `C:\src\a\b\x.go`, `C:\src\a`},
+		{1, nil, `C:src\a\x.go`, `C:src`},
+		{1, []string{`C:\`}, `C:\src\a\x.go`, `C:\src`},
+		{1, []string{`C:\src`}, `C:\src\a\b\x.go`, `C:\src\a`},
+		{1, nil, `\\server\share\src\a\x.go`, `\\server\share\src`},
+		{1, []string{`\\server\share\src`}, `\\server\share\src\a\b\x.go`, `\\server\share\src\a`},
+		{1, []string{`\\server\share\src`}, `\\server\share\src\x.go`, `\\server\share\src`},
+	}
+	for _, tc := range cases {
+		withGroupDepth(t, tc.depth, tc.roots...)
+		if got := groupKeys["dir"](fileLines{filename: tc.path}); got != tc.want {
+			t.Errorf("dir:%d of %s under %v: got %s, want %s", tc.depth, tc.path, tc.roots, got, tc.want)
+		}
+	}
+}
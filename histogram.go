@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// with --histogram, files are bucketed by their code lines after the table
+var showHistogram bool
+
+// histogramBuckets are the --histogram columns, each holding the files with
+// at most max code lines, the last holding the rest
+var histogramBuckets = []struct {
+	title string
+	max   int
+}{
+	{"0-50", 50},
+	{"51-200", 200},
+	{"201-500", 500},
+	{"500+", 1<<63 - 1},
+}
+
+func histogramBucket(code int) int {
+	for i, bucket := range histogramBuckets {
+		if code <= bucket.max {
+			return i
+		}
+	}
+	return len(histogramBuckets) - 1
+}
+
+// writeHistogram adds the distribution of files by code lines, per language
+// and in total, to the table report
+func writeHistogram(w io.Writer, files []fileLines) {
+	if len(files) == 0 {
+		return
+	}
+	counts := map[string][]int{}
+	total := make([]int, len(histogramBuckets))
+	for _, f := range files {
+		if counts[f.language] == nil {
+			counts[f.language] = make([]int, len(histogramBuckets))
+		}
+		i := histogramBucket(f.codeLines)
+		counts[f.language][i]++
+		total[i]++
+	}
+	var langs []string
+	for lang := range counts {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+
+	row := func(name string, counts []int) []string {
+		n := 0
+		for _, c := range counts {
+			n += c
+		}
+		res := []string{name}
+		for _, c := range counts {
+			res = append(res, fmt.Sprintf("%s (%s)", tableCount(c), percent(c, n)))
+		}
+		return res
+	}
+
+	fmt.Fprintln(w)
+	table := tablewriter.NewWriter(w)
+	header := []string{"Files By Code Lines"}
+	for _, bucket := range histogramBuckets {
+		header = append(header, bucket.title)
+	}
+	table.SetHeader(header)
+	table.SetFooter(row("TOTAL", total))
+	table.SetBorder(false)
+	for _, lang := range langs {
+		table.Append(row(lang, counts[lang]))
+	}
+	table.Render()
+
+	// and the total as bars, for the shape at a glance
+	most := 0
+	for _, c := range total {
+		most = max(most, c)
+	}
+	for i, bucket := range histogramBuckets {
+		bar := 0
+		if most > 0 {
+			bar = (total[i]*40 + most - 1) / most
+		}
+		fmt.Fprintf(w, "%8s %-40s %s\n", bucket.title, strings.Repeat("#", bar), tableCount(total[i]))
+	}
+}
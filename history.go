@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// historyIntervals pick the snapshots counted: every commit, every tag, or
+// the last commit of each time bucket
+var historyIntervals = []string{"commit", "tag", "day", "week", "month", "year"}
+
+// snapshot is a commit of the history to count
+type snapshot struct {
+	commit, tag string
+	time        time.Time
+}
+
+// historyPoint is one snapshot's totals
+type historyPoint struct {
+	Time    time.Time `json:"time"`
+	Commit  string    `json:"commit"`
+	Tag     string    `json:"tag,omitempty"`
+	Files   int       `json:"files"`
+	Code    int       `json:"code"`
+	Comment int       `json:"comment"`
+	Blank   int       `json:"blank"`
+}
+
+func runHistory(args []string, format string) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	since := flags.String("since", "", "only count commits since this date, e.g. 2023-01-01")
+	until := flags.String("until", "", "only count commits until this date")
+	intervalFlag := newEnumFlag("month", historyIntervals...)
+	flags.Var(intervalFlag, "interval", "count every commit or tag, or the last commit of each "+intervalFlag.choices())
+	ref := flags.String("ref", "HEAD", "the branch or commit whose history is counted")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return usageErrorf("usage: sloc history [flags] [path]")
+	}
+	dir := "."
+	if flags.NArg() == 1 {
+		dir = flags.Arg(0)
+	}
+	if !slices.Contains([]string{"table", "csv", "json"}, format) {
+		return usageErrorf("sloc history writes table, csv or json, not %s", format)
+	}
+
+	snapshots, err := historySnapshots(dir, *ref, intervalFlag.value, *since, *until)
+	if err != nil {
+		return err
+	}
+
+	blobs, err := newBlobReader(dir)
+	if err != nil {
+		return err
+	}
+	defer blobs.close()
+
+	var points []historyPoint
+	for _, snap := range snapshots {
+		log.Debugf("counting %s at %s", snap.commit, snap.time.Format(time.DateOnly))
+		total, files, err := countSnapshot(dir, snap.commit, blobs)
+		if err != nil {
+			return err
+		}
+		points = append(points, historyPoint{
+			Time:    snap.time,
+			Commit:  snap.commit,
+			Tag:     snap.tag,
+			Files:   files,
+			Code:    total.codeLines,
+			Comment: total.commentLines,
+			Blank:   total.whitespaceLines,
+		})
+	}
+	return writeHistory(reportOutput, points, format)
+}
+
+// historySnapshots lists the commits to count, oldest first
+func historySnapshots(dir, ref, interval, since, until string) ([]snapshot, error) {
+	if interval == "tag" {
+		return tagSnapshots(dir, ref, since, until)
+	}
+
+	args := []string{"-C", dir, "log", "--first-parent", "--format=%H %ct"}
+	if since != "" {
+		args = append(args, "--since="+since)
+	}
+	if until != "" {
+		args = append(args, "--until="+until)
+	}
+	out, err := gitOutput(append(args, ref, "--")...)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []snapshot
+	seen := map[string]bool{}
+	// newest first, so the first commit seen in a bucket is its last
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		commit, stamp, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		secs, _ := strconv.ParseInt(stamp, 10, 64)
+		when := time.Unix(secs, 0).UTC()
+		if bucket := timeBucket(when, interval); bucket != "" {
+			if seen[bucket] {
+				continue
+			}
+			seen[bucket] = true
+		}
+		res = append(res, snapshot{commit: commit, time: when})
+	}
+	slices.Reverse(res)
+	return res, nil
+}
+
+// timeBucket names the bucket t falls in, or "" to count every commit
+func timeBucket(t time.Time, interval string) string {
+	switch interval {
+	case "day":
+		return t.Format(time.DateOnly)
+	case "week":
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case "month":
+		return t.Format("2006-01")
+	case "year":
+		return t.Format("2006")
+	}
+	return ""
+}
+
+// tagSnapshots lists the tags reachable from ref, oldest first
+func tagSnapshots(dir, ref, since, until string) ([]snapshot, error) {
+	out, err := gitOutput("-C", dir, "for-each-ref", "--merged="+ref, "--sort=creatordate",
+		"--format=%(refname:short) %(*objectname) %(objectname) %(creatordate:unix)", "refs/tags")
+	if err != nil {
+		return nil, err
+	}
+	parseDate := func(s string) (time.Time, error) {
+		if s == "" {
+			return time.Time{}, nil
+		}
+		return time.Parse(time.DateOnly, s)
+	}
+	from, err := parseDate(since)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --since %q for tags, want YYYY-MM-DD", since)
+	}
+	to, err := parseDate(until)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --until %q for tags, want YYYY-MM-DD", until)
+	}
+
+	var res []snapshot
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		// annotated tags name the commit they point at first, lightweight
+		// ones only have the commit itself
+		tag, commit, stamp := fields[0], fields[1], fields[len(fields)-1]
+		secs, _ := strconv.ParseInt(stamp, 10, 64)
+		when := time.Unix(secs, 0).UTC()
+		if !from.IsZero() && when.Before(from) || !to.IsZero() && when.After(to) {
+			continue
+		}
+		res = append(res, snapshot{commit: commit, tag: tag, time: when})
+	}
+	return res, nil
+}
+
+// countSnapshot totals the source files in a commit's tree under dir
+func countSnapshot(dir, commit string, blobs *blobReader) (fileLines, int, error) {
+	total := fileLines{filename: "TOTAL"}
+	out, err := gitOutput("-C", dir, "ls-tree", "-r", "-z", commit)
+	if err != nil {
+		return total, 0, err
+	}
+
+	files := 0
+	for _, entry := range strings.Split(out, "\x00") {
+		// <mode> SP <type> SP <object> TAB <path>
+		meta, path, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 3 || fields[1] != "blob" || !countsPath(path) {
+			continue
+		}
+		res, err := blobs.count(fields[2], path)
+		if err != nil {
+			log.Debugf("%s:%s: %v", commit, path, err)
+			continue
+		}
+		if res.generated && !includeGenerated {
+			continue
+		}
+		total.join(res)
+		files++
+	}
+	return total, files, nil
+}
+
+// countsPath reports whether a path found outside a walk, in a git tree or
+// an archive, would be counted by one: a source file outside excluded,
+// hidden or too deep directories, passing the filters
+func countsPath(path string) bool {
+	if !isSourceFile(path) {
+		return false
+	}
+	path = strings.TrimPrefix(filepath.ToSlash(path), "./")
+	var dirs []string
+	if dir := filepath.ToSlash(filepath.Dir(path)); dir != "." {
+		dirs = strings.Split(dir, "/")
+	}
+	if slices.ContainsFunc(dirs, excludeDir) {
+		return false
+	}
+	if skipHidden && slices.ContainsFunc(append(dirs, filepath.Base(path)), func(name string) bool { return strings.HasPrefix(name, ".") }) {
+		return false
+	}
+	// a walk doesn't enter directories at --max-depth
+	if maxDepth > 0 && len(dirs) >= maxDepth {
+		return false
+	}
+	if excludedPath(path) {
+		return false
+	}
+	included, _ := includeFilter.match(path, false)
+	return len(includeFilter) == 0 || included
+}
+
+// blobReader reads blobs through one git cat-file process, counting each
+// blob once however many snapshots share it
+type blobReader struct {
+	cmd    *exec.Cmd
+	in     io.WriteCloser
+	out    *bufio.Reader
+	counts map[string]fileLines // by blob and file name, which picks the language
+}
+
+func newBlobReader(dir string) (*blobReader, error) {
+	cmd := exec.Command("git", "-C", dir, "cat-file", "--batch")
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &blobReader{cmd: cmd, in: in, out: bufio.NewReader(out), counts: map[string]fileLines{}}, nil
+}
+
+func (this *blobReader) count(blob, path string) (fileLines, error) {
+	key := blob + " " + filepath.Base(path)
+	if res, ok := this.counts[key]; ok {
+		res.filename = path
+		return res, nil
+	}
+
+	if _, err := fmt.Fprintln(this.in, blob); err != nil {
+		return fileLines{}, err
+	}
+	// <object> SP <type> SP <size> LF <contents> LF
+	header, err := this.out.ReadString('\n')
+	if err != nil {
+		return fileLines{}, err
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return fileLines{}, fmt.Errorf("git cat-file: %s", strings.TrimSpace(header))
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return fileLines{}, fmt.Errorf("git cat-file: %s", strings.TrimSpace(header))
+	}
+	data := make([]byte, size+1)
+	if _, err := io.ReadFull(this.out, data); err != nil {
+		return fileLines{}, err
+	}
+
+	res, err := countLines(path, bytes.NewReader(data[:size]))
+	if err != nil {
+		return res, err
+	}
+	this.counts[key] = res
+	return res, nil
+}
+
+func (this *blobReader) close() {
+	this.in.Close()
+	this.cmd.Wait()
+}
+
+func gitOutput(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+func writeHistory(w io.Writer, points []historyPoint, format string) error {
+	row := func(p historyPoint) []string {
+		return []string{p.Time.Format(time.RFC3339), p.Commit, p.Tag,
+			strconv.Itoa(p.Files), strconv.Itoa(p.Code), strconv.Itoa(p.Comment), strconv.Itoa(p.Blank)}
+	}
+	header := []string{"time", "commit", "tag", "files", "code", "comment", "blank"}
+
+	switch format {
+	case "json":
+		if points == nil {
+			points = []historyPoint{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(points)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		for _, p := range points {
+			cw.Write(row(p))
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	fmt.Fprintln(w)
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(header)
+	table.SetBorder(false)
+	for _, p := range points {
+		r := row(p)
+		r[1] = r[1][:min(len(r[1]), 12)]
+		table.Append(r)
+	}
+	table.Render()
+	return nil
+}
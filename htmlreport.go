@@ -0,0 +1,128 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type htmlRow struct {
+	Name                 string
+	Code, Comment, Blank int
+	CodePct, CommentPct  float64 // bar widths, relative to the largest row
+	BlankPct             float64
+}
+
+type htmlReport struct {
+	Generated   string
+	Total       htmlRow
+	Files, Dirs []htmlRow
+}
+
+// htmlRows sizes each row's bar against the largest in the table
+func htmlRows(lines []fileLines) []htmlRow {
+	largest := 0
+	for _, f := range lines {
+		largest = max(largest, f.codeLines+f.commentLines+f.whitespaceLines)
+	}
+
+	var rows []htmlRow
+	for _, f := range lines {
+		rows = append(rows, htmlRow{
+			Name:       displayPath(f.filename),
+			Code:       f.codeLines,
+			Comment:    f.commentLines,
+			Blank:      f.whitespaceLines,
+			CodePct:    100 * ratio(f.codeLines, largest),
+			CommentPct: 100 * ratio(f.commentLines, largest),
+			BlankPct:   100 * ratio(f.whitespaceLines, largest),
+		})
+	}
+	return rows
+}
+
+// renderHTML writes a single self-contained page with sortable tables of
+// files and directories
+func renderHTML(w io.Writer, files []fileLines, total fileLines) error {
+	dirs := map[string]*fileLines{}
+	for _, f := range files {
+		dir := filepath.Dir(f.filename)
+		if dirs[dir] == nil {
+			dirs[dir] = &fileLines{filename: dir}
+		}
+		dirs[dir].join(f)
+	}
+	var byDir []fileLines
+	for _, d := range dirs {
+		byDir = append(byDir, *d)
+	}
+	sort.Slice(byDir, func(i, j int) bool { return byDir[i].filename < byDir[j].filename })
+
+	return htmlTemplate.Execute(w, htmlReport{
+		Generated: time.Now().Format(time.RFC1123),
+		Total:     htmlRows([]fileLines{total})[0],
+		Files:     htmlRows(files),
+		Dirs:      htmlRows(byDir),
+	})
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>sloc report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 2px 8px; text-align: right; }
+th { cursor: pointer; border-bottom: 1px solid #888; user-select: none; }
+td:first-child, th:first-child { text-align: left; font-family: monospace; }
+tfoot td { border-top: 1px solid #888; font-weight: bold; }
+.bar { width: 300px; }
+.bar span { display: inline-block; height: 10px; }
+.code { background: #4e79a7; } .comment { background: #59a14f; } .blank { background: #bab0ac; }
+</style>
+</head>
+<body>
+<h1>sloc report</h1>
+<p>{{.Total.Code}} code, {{.Total.Comment}} comment and {{.Total.Blank}} blank lines. Generated {{.Generated}}.
+<span class="code">&nbsp;&nbsp;</span> code <span class="comment">&nbsp;&nbsp;</span> comment <span class="blank">&nbsp;&nbsp;</span> blank</p>
+{{define "table"}}
+<table class="sortable">
+<thead><tr><th>Name</th><th>Code</th><th>Comment</th><th>Blank</th><th class="bar"></th></tr></thead>
+<tbody>
+{{- range .}}
+<tr><td>{{.Name}}</td><td>{{.Code}}</td><td>{{.Comment}}</td><td>{{.Blank}}</td>
+<td class="bar"><span class="code" style="width: {{printf "%.2f" .CodePct}}%"></span><span class="comment" style="width: {{printf "%.2f" .CommentPct}}%"></span><span class="blank" style="width: {{printf "%.2f" .BlankPct}}%"></span></td></tr>
+{{- end}}
+</tbody>
+</table>
+{{end}}
+<h2>Directories</h2>
+{{template "table" .Dirs}}
+<h2>Files</h2>
+{{template "table" .Files}}
+<script>
+// click a header to sort by it, again to reverse
+document.querySelectorAll("table.sortable").forEach(function (table) {
+  table.querySelectorAll("th:not(.bar)").forEach(function (th, col) {
+    var asc = col === 0;
+    th.addEventListener("click", function () {
+      var body = table.tBodies[0];
+      var rows = Array.from(body.rows);
+      rows.sort(function (a, b) {
+        var x = a.cells[col].textContent, y = b.cells[col].textContent;
+        var cmp = col === 0 ? x.localeCompare(y) : Number(x) - Number(y);
+        return asc ? cmp : -cmp;
+      });
+      asc = !asc;
+      rows.forEach(function (row) { body.appendChild(row); });
+    });
+  });
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// with --respect-gitignore, paths git ignores aren't counted
+var respectGitignore = true
+
+// slocIgnoreFile holds a project's own exclusions, in gitignore syntax
+const slocIgnoreFile = ".slocignore"
+
+// --include and --exclude patterns, matched against paths relative to the
+// target with gitignore's syntax; with includes, only matching files count
+var includeFilter, excludeFilter ignoreList
+
+// ignorePattern is one line of a .gitignore style file
+type ignorePattern struct {
+	re      *regexp.Regexp // matches the slash-separated path relative to the file
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreList holds the patterns of one file, later lines taking precedence
+type ignoreList []ignorePattern
+
+// parseIgnore reads patterns with gitignore's syntax: # comments, ! to
+// re-include, a trailing / for directories only, and patterns with a slash
+// anchored to the file's directory while the rest match at any depth
+func parseIgnore(data []byte) ignoreList {
+	var res ignoreList
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		// trailing spaces are ignored unless escaped
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+			line = line[:len(line)-1]
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var p ignorePattern
+		if line[0] == '!' {
+			p.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+
+		expr := globRegexp(line)
+		if !anchored {
+			expr = "(.*/)?" + expr
+		}
+		re, err := regexp.Compile("^" + expr + "$")
+		if err != nil {
+			log.Debugf("ignoring invalid pattern %q: %v", line, err)
+			continue
+		}
+		p.re = re
+		res = append(res, p)
+	}
+	return res
+}
+
+// globRegexp translates a gitignore glob, where * and ? stop at slashes and
+// ** crosses them
+func globRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// match decides rel, relative to the list's directory, by the last pattern
+// that matches it, if any does
+func (this ignoreList) match(rel string, dir bool) (ignored, matched bool) {
+	for i := len(this) - 1; i >= 0; i-- {
+		p := this[i]
+		if p.dirOnly && !dir {
+			continue
+		}
+		if p.re.MatchString(rel) {
+			return !p.negate, true
+		}
+	}
+	return false, false
+}
+
+// excludedPath reports whether --exclude leaves out a file, given by its
+// slash-separated path relative to the target, or any directory above it,
+// which a walk wouldn't enter
+func excludedPath(rel string) bool {
+	if excluded, _ := excludeFilter.match(rel, false); excluded {
+		return true
+	}
+	for i := strings.LastIndex(rel, "/"); i > 0; i = strings.LastIndex(rel[:i], "/") {
+		if excluded, _ := excludeFilter.match(rel[:i], true); excluded {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoreRules are the ignore files found on the way to a path during a walk
+type ignoreRules struct {
+	lists map[string]ignoreList // by absolute directory
+}
+
+// newIgnoreRules starts from the ignore files above root in its work tree,
+// so counting a subdirectory ignores what counting the project would
+func newIgnoreRules(root string) *ignoreRules {
+	this := &ignoreRules{lists: map[string]ignoreList{}}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return this
+	}
+
+	top := workTreeTop(abs)
+	if top == "" {
+		return this
+	}
+	if respectGitignore {
+		this.add(top, filepath.Join(top, ".git", "info", "exclude"))
+	}
+	for dir := filepath.Dir(abs); strings.HasPrefix(dir, top); dir = filepath.Dir(dir) {
+		this.load(dir)
+		if dir == top {
+			break
+		}
+	}
+	return this
+}
+
+// workTreeTop finds the directory holding .git at or above dir, or ""
+func workTreeTop(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// load reads the ignore files in a directory being walked
+func (this *ignoreRules) load(dir string) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	if respectGitignore {
+		this.add(abs, filepath.Join(abs, ".gitignore"))
+	}
+	// after .gitignore, so it can re-include what git ignores
+	this.add(abs, filepath.Join(abs, slocIgnoreFile))
+}
+
+func (this *ignoreRules) add(dir, file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return
+	}
+	this.lists[dir] = append(this.lists[dir], parseIgnore(data)...)
+}
+
+// ignored reports whether path is ignored, the closest ignore file that
+// matches it deciding, as in git
+func (this *ignoreRules) ignored(path string, dir bool) bool {
+	if len(this.lists) == 0 {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for d := filepath.Dir(abs); ; d = filepath.Dir(d) {
+		if list, ok := this.lists[d]; ok {
+			rel, _ := filepath.Rel(d, abs)
+			if ignored, matched := list.match(filepath.ToSlash(rel), dir); matched {
+				return ignored
+			}
+		}
+		if d == filepath.Dir(d) {
+			return false
+		}
+	}
+}
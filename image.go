@@ -0,0 +1,42 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func processImage(ctx context.Context, ref string, out collector) error {
+	img, err := crane.Pull(ref, crane.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	// walk the flattened filesystem of all layers
+	fs := mutate.Extract(img)
+	defer fs.Close()
+
+	tr := tar.NewReader(fs)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// ignore non-regular files, the rest are filtered as a walk would
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		countArchived(ref, hdr.Name, hdr.Size, tr, out)
+	}
+}
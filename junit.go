@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name       string          `xml:"name,attr"`
+	Classname  string          `xml:"classname,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+}
+
+type junitTestSuite struct {
+	XMLName    xml.Name        `xml:"testsuite"`
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	TestCases  []junitTestCase `xml:"testcase"`
+}
+
+func junitProperties(f fileLines) []junitProperty {
+	return []junitProperty{
+		{Name: "code", Value: strconv.Itoa(f.codeLines)},
+		{Name: "comment", Value: strconv.Itoa(f.commentLines)},
+		{Name: "blank", Value: strconv.Itoa(f.whitespaceLines)},
+	}
+}
+
+// newJUnitSink writes each file as a passing test case with its counts as
+// properties, for CI servers that only show test reports
+func newJUnitSink(path string) reportSink {
+	return func(target string, files []fileLines, total fileLines) error {
+		suite := junitTestSuite{
+			Name:       "sloc " + target,
+			Tests:      len(files),
+			Properties: junitProperties(total),
+		}
+		for _, f := range files {
+			suite.TestCases = append(suite.TestCases, junitTestCase{
+				Name:       f.filename,
+				Classname:  filepath.Dir(f.filename),
+				Properties: junitProperties(f),
+			})
+		}
+
+		data, err := xml.MarshalIndent(suite, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644)
+	}
+}
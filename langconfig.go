@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chriskirkland/go-utils/sloc"
+	"gopkg.in/yaml.v3"
+)
+
+// languageConfig is a language registered from a languages file, e.g.
+//
+//	languages:
+//	  - name: Jsonnet
+//	    extensions: [.jsonnet, .libsonnet]
+//	    line_comments: ["//", "#"]
+//	    block_comments: [["/*", "*/"]]
+//	    directives: ["// jsonnetfmt"]
+//	    quotes:
+//	      - {open: '"', close: '"', escapes: true}
+//	      - {open: "|||", close: "|||", multiline: true}
+type languageConfig struct {
+	Name          string      `yaml:"name"`
+	Extensions    []string    `yaml:"extensions"`
+	LineComments  []string    `yaml:"line_comments"`
+	BlockComments [][2]string `yaml:"block_comments"`
+	Directives    []string    `yaml:"directives"`
+	Quotes        []struct {
+		Open      string `yaml:"open"`
+		Close     string `yaml:"close"`
+		Escapes   bool   `yaml:"escapes"`
+		Multiline bool   `yaml:"multiline"`
+	} `yaml:"quotes"`
+	// nested block comments, line comments only at the start of a word, and
+	// shell here-documents
+	NestedComments bool `yaml:"nested_comments"`
+	WordComments   bool `yaml:"word_comments"`
+	Heredocs       bool `yaml:"heredocs"`
+}
+
+// defaultLanguagesFile is loaded when --languages isn't given, if it exists
+func defaultLanguagesFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "sloc", "languages.yaml")
+}
+
+// loadLanguages registers the languages in path, taking over any
+// extensions they list from the built-in languages
+func loadLanguages(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var cfg struct {
+		Languages []languageConfig `yaml:"languages"`
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return registerLanguages(path, cfg.Languages)
+}
+
+// registerLanguages registers languages read from path
+func registerLanguages(path string, configs []languageConfig) error {
+	for i, lc := range configs {
+		if lc.Name == "" || len(lc.Extensions) == 0 {
+			return fmt.Errorf("%s: language %d needs a name and extensions", path, i)
+		}
+		lang := &sloc.Language{
+			Name:          lc.Name,
+			LineComments:  lc.LineComments,
+			BlockComments: lc.BlockComments,
+			Directives:    lc.Directives,
+
+			NestedComments: lc.NestedComments,
+			WordComments:   lc.WordComments,
+			Heredocs:       lc.Heredocs,
+		}
+		for _, q := range lc.Quotes {
+			if q.Open == "" {
+				return fmt.Errorf("%s: %s has a quote without an open delimiter", path, lc.Name)
+			}
+			if q.Close == "" {
+				q.Close = q.Open
+			}
+			lang.Quotes = append(lang.Quotes, sloc.Quote{Open: q.Open, Close: q.Close, Escapes: q.Escapes, Multiline: q.Multiline})
+		}
+		for _, ext := range lc.Extensions {
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			lang.Extensions = append(lang.Extensions, strings.ToLower(ext))
+		}
+		sloc.RegisterLanguage(lang)
+		log.Debugf("registered %s for %s", lang.Name, strings.Join(lang.Extensions, " "))
+	}
+	return nil
+}
+
+// forceLanguages maps extensions to languages from ext:lang pairs, e.g.
+// "inc:cpp,tmpl:go"
+func forceLanguages(spec string) error {
+	for _, pair := range strings.Split(spec, ",") {
+		ext, name, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || ext == "" {
+			return fmt.Errorf("invalid --force-lang %q, want ext:language", pair)
+		}
+		lang := sloc.FindLanguage(name)
+		if lang == nil {
+			return fmt.Errorf("invalid --force-lang %q: unknown language %q", pair, name)
+		}
+		sloc.SetExtension(ext, lang)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/chriskirkland/go-utils/sloc"
+)
+
+// with --license-headers, license boilerplate at the top of a file is counted
+// apart from the comments
+var countLicenses bool
+
+var licenseMarker = regexp.MustCompile(`(?i)copyright|licen[cs]|spdx-license-identifier|permission is hereby granted|all rights reserved`)
+
+// licenseHeader finds the license at the top of a file: the comment
+// paragraphs before the first line of code, for as long as each of them
+// mentions a copyright or license. The first paragraph that doesn't, such as
+// a package doc, ends it.
+type licenseHeader struct {
+	done   bool
+	lines  int  // comment lines in the current paragraph
+	marked bool // the current paragraph mentions a license
+	total  int
+}
+
+func (this *licenseHeader) add(kind sloc.LineKind, raw []byte) {
+	if this.done {
+		return
+	}
+	switch kind {
+	case sloc.CommentLine:
+		this.lines++
+		this.marked = this.marked || licenseMarker.Match(raw)
+	case sloc.BlankLine:
+		this.end()
+	default:
+		this.end()
+		this.done = true
+	}
+}
+
+// end closes the current paragraph
+func (this *licenseHeader) end() {
+	if this.lines == 0 {
+		return
+	}
+	if this.marked && !this.done {
+		this.total += this.lines
+	} else {
+		this.done = true
+	}
+	this.lines, this.marked = 0, false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// with --line-lengths, the lengths of non-blank lines are measured
+var measureLineLengths bool
+
+// maxLineLength lists the lines longer than this after the table, with
+// --max-line-length
+var maxLineLength int
+
+// longLine is a line over --max-line-length
+type longLine struct {
+	Line, Length int
+}
+
+// lineLengths counts lines by their length in characters
+type lineLengths map[int]int
+
+func (this *lineLengths) join(l lineLengths) {
+	for length, n := range l {
+		if *this == nil {
+			*this = lineLengths{}
+		}
+		(*this)[length] += n
+	}
+}
+
+// stats is the longest, the mean and the 95th percentile length
+func (this lineLengths) stats() (longest int, mean float64, p95 int) {
+	var lengths []int
+	lines, sum := 0, 0
+	for length, n := range this {
+		lengths = append(lengths, length)
+		lines += n
+		sum += length * n
+	}
+	if lines == 0 {
+		return 0, 0, 0
+	}
+	sort.Ints(lengths)
+	// the shortest length that 95% of lines are within
+	rank := int(math.Ceil(0.95 * float64(lines)))
+	seen := 0
+	for _, length := range lengths {
+		seen += this[length]
+		if seen >= rank {
+			p95 = length
+			break
+		}
+	}
+	return lengths[len(lengths)-1], float64(sum) / float64(lines), p95
+}
+
+// jsonLineLengths is the machine-readable form of lineLengths' stats
+type jsonLineLengths struct {
+	Max     int     `json:"max"`
+	Average float64 `json:"average"`
+	P95     int     `json:"p95"`
+}
+
+func (this lineLengths) toJSON() *jsonLineLengths {
+	longest, mean, p95 := this.stats()
+	return &jsonLineLengths{Max: longest, Average: math.Round(mean*10) / 10, P95: p95}
+}
+
+// writeLongLines lists the lines over --max-line-length after the table
+func writeLongLines(w io.Writer, files []fileLines) {
+	var data [][]string
+	for _, f := range files {
+		for _, l := range f.overLong {
+			data = append(data, []string{fmt.Sprintf("%s:%d", displayPath(f.filename), l.Line), strconv.Itoa(l.Length)})
+		}
+	}
+	if len(data) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Location", "Length"})
+	table.SetBorder(false)
+	table.AppendBulk(data)
+	table.Render()
+	fmt.Fprintf(w, "%d lines over %d characters\n", len(data), maxLineLength)
+}
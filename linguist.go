@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// with --language-summary, the table is followed by each language's share of
+// the code on one line, the way GitHub shows a repository's languages, and
+// with --language-bar by a bar of the shares too
+var languageSummary, languageBar bool
+
+// languageBarWidth is how many cells the --language-bar spans
+const languageBarWidth = 60
+
+// languageOther collects the languages too small to show on their own
+const languageOther = "Other"
+
+// languageGlyphs tell the bar's languages apart without color
+var languageGlyphs = []string{"█", "▓", "▒", "░"}
+
+// languageColors are the ANSI foregrounds the bar's languages cycle through
+// when the report is colored
+var languageColors = []int{34, 32, 33, 35, 36, 31}
+
+type languageShare struct {
+	name  string
+	code  int
+	share float64
+}
+
+// languageShares totals the code of each language, largest first, folding
+// those under 0.1% of it into Other
+func languageShares(files []fileLines) []languageShare {
+	codes := map[string]int{}
+	total := 0
+	for _, f := range files {
+		codes[f.language] += f.codeLines
+		total += f.codeLines
+	}
+	if total == 0 {
+		return nil
+	}
+
+	var res []languageShare
+	other := languageShare{name: languageOther}
+	for lang, code := range codes {
+		share := languageShare{name: lang, code: code, share: float64(code) / float64(total)}
+		if share.share < 0.001 {
+			other.code += code
+			other.share += share.share
+			continue
+		}
+		res = append(res, share)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].code != res[j].code {
+			return res[i].code > res[j].code
+		}
+		return res[i].name < res[j].name
+	})
+	if other.code > 0 {
+		res = append(res, other)
+	}
+	return res
+}
+
+// writeLanguageSummary writes the languages' shares of the code, e.g.
+// "Go 82.4% · Shell 10.1% · YAML 7.5%", after a bar of them if asked for
+func writeLanguageSummary(w io.Writer, files []fileLines) {
+	shares := languageShares(files)
+	if len(shares) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+
+	if languageBar {
+		var bar strings.Builder
+		for i, width := range languageBarCells(shares) {
+			bar.WriteString(languagePaint(i, strings.Repeat(languageGlyph(i), width)))
+		}
+		fmt.Fprintln(w, bar.String())
+	}
+
+	var parts []string
+	for i, s := range shares {
+		part := fmt.Sprintf("%s %.1f%%", s.name, 100*s.share)
+		if languageBar {
+			part = languagePaint(i, languageGlyph(i)) + " " + part
+		}
+		parts = append(parts, part)
+	}
+	fmt.Fprintln(w, strings.Join(parts, " · "))
+}
+
+// languageBarCells splits the bar's width between the shares, the cells
+// rounding leaves over going to the shares rounded down the most
+func languageBarCells(shares []languageShare) []int {
+	res := make([]int, len(shares))
+	left := languageBarWidth
+	for i, s := range shares {
+		res[i] = int(s.share * languageBarWidth)
+		left -= res[i]
+	}
+	order := make([]int, len(shares))
+	for i := range order {
+		order[i] = i
+	}
+	remainder := func(i int) float64 {
+		return shares[i].share*languageBarWidth - float64(res[i])
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainder(order[a]) > remainder(order[b]) })
+	for _, i := range order[:left] {
+		res[i]++
+	}
+	return res
+}
+
+// languageGlyph is what the i-th language's part of the bar is drawn with:
+// one block for all of them when colored, alternating shades when not
+func languageGlyph(i int) string {
+	if colorTables {
+		return languageGlyphs[0]
+	}
+	return languageGlyphs[i%len(languageGlyphs)]
+}
+
+func languagePaint(i int, s string) string {
+	if !colorTables || s == "" {
+		return s
+	}
+	return fmt.Sprintf("\x1b[%dm%s\x1b[0m", languageColors[i%len(languageColors)], s)
+}
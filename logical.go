@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"hash/fnv"
+)
+
+// with --uloc, the distinct non-blank lines are counted: per file, and
+// across all of them in the total
+var countUnique bool
+
+// with --logical, Go files' statements and declarations are counted as well
+// as their physical lines
+var countLogical bool
+
+// uniqueLines is a set of lines by the hash of their trimmed text
+type uniqueLines map[uint64]bool
+
+func (this *uniqueLines) add(line []byte) {
+	if *this == nil {
+		*this = uniqueLines{}
+	}
+	h := fnv.New64a()
+	h.Write(bytes.TrimSpace(line))
+	(*this)[h.Sum64()] = true
+}
+
+func (this *uniqueLines) join(u uniqueLines) {
+	for line := range u {
+		if *this == nil {
+			*this = uniqueLines{}
+		}
+		(*this)[line] = true
+	}
+}
+
+// goLogicalLines counts a file's logical lines: each statement, blocks
+// aside, and each declaration, so a call split over several lines is one
+// and code crammed onto one line is several
+func goLogicalLines(file *ast.File) int {
+	n := 0
+	ast.Inspect(file, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.BlockStmt, *ast.EmptyStmt:
+		case *ast.CaseClause, *ast.CommClause, *ast.FuncDecl, *ast.ValueSpec, *ast.TypeSpec, *ast.ImportSpec:
+			n++
+		case ast.Stmt:
+			// the declaration statements inside have their specs counted
+			if _, ok := node.(*ast.DeclStmt); !ok {
+				n++
+			}
+		}
+		return true
+	})
+	return n
+}
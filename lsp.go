@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSON-RPC 2.0 envelopes, framed with LSP-style Content-Length headers
+type rpcRequest struct {
+	ID     *json.RawMessage `json:"id,omitempty"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params,omitempty"`
+}
+
+// rpcResponse answers a request with either a result, null if there's
+// none, or an error, never both
+type rpcResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+type rpcErrorResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   *rpcError        `json:"error"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspWorkspace struct {
+	Root  string      `json:"root"`
+	Files []jsonLines `json:"files"`
+	Total jsonLines   `json:"total"`
+}
+
+type lspEntry struct {
+	modTime time.Time
+	size    int64
+	stats   fileLines
+}
+
+type lspServer struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	root     string
+	files    map[string]lspEntry  // cached counts of files on disk
+	overlays map[string]fileLines // counts of unsaved editor buffers
+
+	// the workspace's files as last reported, buffers over the files on
+	// disk, which a change to one file updates without walking the rest
+	counts     map[string]fileLines
+	countsRoot string
+}
+
+// uriToPath turns a file:// URI, percent-encoded and with a drive letter on
+// Windows as in file:///C:/src, into an absolute path; anything else is
+// taken as a path already
+func uriToPath(uri string) string {
+	path := uri
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		path = u.Path
+		if len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+			// /C:/src
+			path = path[1:]
+		}
+		if u.Host != "" && u.Host != "localhost" {
+			// a UNC share, file://server/share
+			path = "//" + u.Host + path
+		}
+		path = filepath.FromSlash(path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
+
+// maxLSPMessage bounds a message's Content-Length, well above the largest
+// buffer an editor sends
+const maxLSPMessage = 64 << 20
+
+func serveLSP(in io.Reader, out io.Writer) error {
+	s := &lspServer{
+		in:       bufio.NewReader(in),
+		out:      out,
+		files:    map[string]lspEntry{},
+		overlays: map[string]fileLines{},
+	}
+
+	for {
+		body, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req rpcRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			// the request's id can't be known, which JSON-RPC answers with null
+			null := json.RawMessage("null")
+			s.respond(&null, nil, &rpcError{Code: -32700, Message: err.Error()})
+			continue
+		}
+		if req.Method == "exit" {
+			return nil
+		}
+
+		log.Debug("lsp", req.Method)
+		result, rerr := s.handle(req)
+
+		// notifications don't get a response
+		if req.ID == nil {
+			continue
+		}
+		s.respond(req.ID, result, rerr)
+	}
+}
+
+func (this *lspServer) read() ([]byte, error) {
+	header, err := textproto.NewReader(this.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %v", err)
+	}
+	if length < 0 || length > maxLSPMessage {
+		return nil, fmt.Errorf("invalid Content-Length: %d", length)
+	}
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(this.in, body)
+	return body, err
+}
+
+// respond answers the request with id
+func (this *lspServer) respond(id *json.RawMessage, result interface{}, rerr *rpcError) {
+	if rerr != nil {
+		this.write(rpcErrorResponse{JSONRPC: "2.0", ID: id, Error: rerr})
+		return
+	}
+	this.write(rpcResponse{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+// notify sends the client a notification, which it doesn't answer
+func (this *lspServer) notify(method string, params interface{}) {
+	this.write(rpcNotification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (this *lspServer) write(msg interface{}) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	fmt.Fprintf(this.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (this *lspServer) handle(req rpcRequest) (interface{}, *rpcError) {
+	var params struct {
+		RootURI string  `json:"rootUri"`
+		Root    string  `json:"root"`
+		Path    string  `json:"path"`
+		Text    *string `json:"text"`
+	}
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{Code: -32602, Message: err.Error()}
+		}
+	}
+
+	switch req.Method {
+	case "initialize":
+		if params.RootURI != "" {
+			this.root = uriToPath(params.RootURI)
+		}
+		return map[string]interface{}{"serverInfo": map[string]string{"name": "sloc"}}, nil
+	case "shutdown":
+		return nil, nil
+	case "sloc/file":
+		path := uriToPath(params.Path)
+
+		// stats for an editor buffer shadow the file on disk
+		if params.Text != nil {
+			stats, err := countLines(path, strings.NewReader(*params.Text))
+			if err != nil {
+				return nil, &rpcError{Code: -32603, Message: err.Error()}
+			}
+			this.overlays[path] = stats
+			this.notifyWorkspace(path)
+			return stats.toJSON(), nil
+		}
+
+		stats, err := this.fileStats(path)
+		if err != nil {
+			return nil, &rpcError{Code: -32603, Message: err.Error()}
+		}
+		return stats.toJSON(), nil
+	case "sloc/didClose":
+		path := uriToPath(params.Path)
+		delete(this.overlays, path)
+		this.notifyWorkspace(path)
+		return nil, nil
+	case "sloc/workspace":
+		if params.Root != "" {
+			this.root = uriToPath(params.Root)
+		}
+		if this.root == "" {
+			return nil, &rpcError{Code: -32602, Message: "no workspace root"}
+		}
+		return this.workspace(), nil
+	}
+	return nil, &rpcError{Code: -32601, Message: "method not found: " + req.Method}
+}
+
+// fileStats recounts a file only when it changed since it was last seen
+func (this *lspServer) fileStats(path string) (fileLines, error) {
+	if stats, ok := this.overlays[path]; ok {
+		return stats, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileLines{}, err
+	}
+	entry, ok := this.files[path]
+	if !ok || !entry.modTime.Equal(info.ModTime()) || entry.size != info.Size() {
+		stats, err := getFileStats(path)
+		if err != nil {
+			return fileLines{}, err
+		}
+		entry = lspEntry{modTime: info.ModTime(), size: info.Size(), stats: stats}
+		this.files[path] = entry
+	}
+	return entry.stats, nil
+}
+
+// workspace walks the root for the counts of every file in it
+func (this *lspServer) workspace() lspWorkspace {
+	this.counts, this.countsRoot = map[string]fileLines{}, this.root
+	seen := map[string]bool{}
+
+	walkTree(this.root, func(path string, info os.FileInfo, err error) error {
+		// ignore unreadable, non-regular and non-Golang files
+		if err != nil || !isSourceFile(path) && !isScript(path, info) || !isRegularFile(path, info) {
+			return nil
+		}
+
+		stats, err := this.fileStats(path)
+		if err != nil {
+			log.Error(err)
+			return nil
+		}
+		seen[path] = true
+		this.counts[path] = stats
+		return nil
+	})
+
+	// forget files deleted since the last walk
+	for path := range this.files {
+		if !seen[path] {
+			delete(this.files, path)
+		}
+	}
+
+	return this.countedWorkspace()
+}
+
+// countedWorkspace totals the workspace's counts as they stand
+func (this *lspServer) countedWorkspace() lspWorkspace {
+	res := lspWorkspace{Root: this.root}
+	total := fileLines{filename: "TOTAL"}
+	for _, path := range slices.Sorted(maps.Keys(this.counts)) {
+		total.join(this.counts[path])
+		res.Files = append(res.Files, this.counts[path].toJSON())
+	}
+	res.Total = total.toJSON()
+	return res
+}
+
+// notifyWorkspace sends the workspace's counts after path changed,
+// recounting only it once the workspace has been walked
+func (this *lspServer) notifyWorkspace(path string) {
+	if this.root == "" {
+		return
+	}
+	if this.countsRoot != this.root {
+		this.notify("sloc/workspaceChanged", this.workspace())
+		return
+	}
+
+	_, counted := this.counts[path]
+	if counted || strings.HasPrefix(path, this.root+string(filepath.Separator)) && isSourceFile(path) {
+		// a buffer closed on a file that was never saved leaves nothing
+		if stats, err := this.fileStats(path); err == nil {
+			this.counts[path] = stats
+		} else {
+			delete(this.counts, path)
+		}
+	}
+	this.notify("sloc/workspaceChanged", this.countedWorkspace())
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readReport reads back the files of a report saved with --format json or
+// ndjson. A report of only its totals, from --summary, is taken as a single
+// row named after it.
+func readReport(path string) ([]fileLines, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var report jsonReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		// ndjson: a record per line, the files and then the total
+		report = jsonReport{}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, len(data)+1)
+		for scanner.Scan() {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			var record ndjsonRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				return nil, fmt.Errorf("%s is not a JSON or NDJSON report: %v", path, err)
+			}
+			if record.Type == "total" {
+				report.Total = record.jsonLines
+			} else {
+				report.Files = append(report.Files, record.jsonLines)
+			}
+		}
+	}
+
+	var res []fileLines
+	for _, f := range report.Files {
+		res = append(res, f.fromJSON())
+	}
+	if len(res) == 0 && report.Total.Code+report.Total.Comment+report.Total.Whitespace > 0 {
+		total := report.Total.fromJSON()
+		total.filename = path
+		res = append(res, total)
+	}
+	return res, nil
+}
+
+// mergeReports reads the rows of each saved report, to be totaled, grouped
+// and rendered like files counted in this run. Shards of one tree don't
+// share paths; reports that do, say of repos each run from its root, have
+// every path put under its report's name to keep them apart.
+func mergeReports(paths []string) ([]fileLines, error) {
+	reports := make([][]fileLines, len(paths))
+	seen := map[string]bool{}
+	overlap := false
+	for i, path := range paths {
+		files, err := readReport(path)
+		if err != nil {
+			return nil, err
+		}
+		reports[i] = files
+		names := map[string]bool{}
+		for _, f := range files {
+			names[f.filename] = true
+		}
+		for name := range names {
+			overlap = overlap || seen[name]
+			seen[name] = true
+		}
+	}
+	var prefixes []string
+	if overlap {
+		log.Infof("the reports share paths, prefixing each report's with its name")
+		var err error
+		if prefixes, err = reportPrefixes(paths); err != nil {
+			return nil, err
+		}
+	}
+
+	var res []fileLines
+	for i, files := range reports {
+		for _, f := range files {
+			if overlap {
+				f.filename = prefixes[i] + "/" + f.filename
+			}
+			res = append(res, f)
+		}
+	}
+	return res, nil
+}
+
+// reportPrefixes names each report by its path below the directory they all
+// share, without the extension, so shard1/report.json and
+// shard2/report.json stay apart; reports the names still can't tell apart,
+// such as one given twice, are refused
+func reportPrefixes(paths []string) ([]string, error) {
+	abs := make([]string, len(paths))
+	for i, path := range paths {
+		var err error
+		if abs[i], err = filepath.Abs(path); err != nil {
+			return nil, err
+		}
+	}
+	parent := filepath.Dir(abs[0])
+	for _, path := range abs[1:] {
+		for parent != filepath.Dir(parent) && !strings.HasPrefix(path, parent+string(filepath.Separator)) {
+			parent = filepath.Dir(parent)
+		}
+	}
+
+	res := make([]string, len(paths))
+	by := map[string]string{}
+	for i, path := range abs {
+		rel, err := filepath.Rel(parent, path)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel)))
+		if other, ok := by[res[i]]; ok {
+			return nil, fmt.Errorf("%s and %s share paths and would both be merged as %s/", other, paths[i], res[i])
+		}
+		by[res[i]] = paths[i]
+	}
+	return res, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiRoute describes an HTTP endpoint; the OpenAPI spec is generated from
+// the same table the handlers are registered from so the two can't drift.
+type apiRoute struct {
+	method   string
+	path     string
+	summary  string
+	query    []string    // the query parameters it takes
+	request  interface{} // zero value of the JSON request body, nil for none
+	uploads  []string    // content types the body may be sent as instead of JSON, such as archives
+	response interface{} // zero value of the JSON response body, nil for plain text
+	produces string      // the response's content type when it's neither JSON nor plain text
+	errors   []int       // the error statuses it answers with, their message as plain text
+	handler  http.HandlerFunc
+}
+
+func registerRoutes(mux *http.ServeMux, title string, routes []apiRoute) {
+	for _, route := range routes {
+		route := route
+		pattern := route.path
+		if pattern == "/" {
+			// "/" alone would answer every path no other route does
+			pattern = "/{$}"
+		}
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != route.method {
+				w.Header().Set("Allow", route.method)
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+				return
+			}
+			route.handler(w, r)
+		})
+	}
+
+	spec := openAPISpec(title, routes)
+	mux.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(spec)
+	})
+}
+
+func openAPISpec(title string, routes []apiRoute) map[string]interface{} {
+	paths := map[string]interface{}{}
+	for _, route := range routes {
+		text := map[string]interface{}{"text/plain": map[string]interface{}{"schema": map[string]string{"type": "string"}}}
+		content := text
+		switch {
+		case route.response != nil:
+			content = map[string]interface{}{"application/json": map[string]interface{}{"schema": jsonSchema(reflect.TypeOf(route.response))}}
+		case route.produces != "":
+			content = map[string]interface{}{route.produces: map[string]interface{}{"schema": map[string]string{"type": "string"}}}
+		}
+		responses := map[string]interface{}{
+			"200": map[string]interface{}{"description": "OK", "content": content},
+		}
+		for _, code := range route.errors {
+			responses[strconv.Itoa(code)] = map[string]interface{}{"description": http.StatusText(code), "content": text}
+		}
+		op := map[string]interface{}{"summary": route.summary, "responses": responses}
+
+		var params []interface{}
+		for _, name := range route.query {
+			params = append(params, map[string]interface{}{"name": name, "in": "query", "schema": map[string]string{"type": "string"}})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if route.request != nil || len(route.uploads) > 0 {
+			body := map[string]interface{}{}
+			if route.request != nil {
+				body["application/json"] = map[string]interface{}{"schema": jsonSchema(reflect.TypeOf(route.request))}
+			}
+			for _, upload := range route.uploads {
+				body[upload] = map[string]interface{}{"schema": map[string]string{"type": "string", "format": "binary"}}
+			}
+			op["requestBody"] = map[string]interface{}{"required": true, "content": body}
+		}
+
+		ops, ok := paths[route.path].(map[string]interface{})
+		if !ok {
+			ops = map[string]interface{}{}
+			paths[route.path] = ops
+		}
+		ops[strings.ToLower(route.method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]string{"title": title, "version": "1"},
+		"paths":   paths,
+	}
+}
+
+// jsonSchema derives a schema from a type the way encoding/json marshals it
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		// byte slices, unlike byte arrays, are written as base64
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if field.PkgPath != "" || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			props[name] = jsonSchema(field.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	}
+	return map[string]interface{}{}
+}
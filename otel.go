@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const instrumentationName = "github.com/chriskirkland/go-utils/sloc"
+
+// the global providers are no-ops until setupTelemetry installs exporters
+var tracer = otel.Tracer(instrumentationName)
+
+var (
+	filesCounter, _  = otel.Meter(instrumentationName).Int64Counter("sloc.files", metric.WithDescription("files counted"))
+	linesCounter, _  = otel.Meter(instrumentationName).Int64Counter("sloc.lines", metric.WithDescription("lines counted by kind"))
+	countDuration, _ = otel.Meter(instrumentationName).Float64Histogram("sloc.count.duration", metric.WithUnit("s"), metric.WithDescription("time spent counting a single file"))
+)
+
+func setupTelemetry(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName("sloc")))
+	if err != nil {
+		return nil, err
+	}
+
+	traceExporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tracerProvider)
+
+	metricExporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)), sdkmetric.WithResource(res))
+	otel.SetMeterProvider(meterProvider)
+
+	// flush everything still buffered when the run ends
+	return func(ctx context.Context) error {
+		return errors.Join(tracerProvider.Shutdown(ctx), meterProvider.Shutdown(ctx))
+	}, nil
+}
+
+func recordFileMetrics(f fileLines, elapsed time.Duration) {
+	ctx := context.Background()
+	filesCounter.Add(ctx, 1)
+	linesCounter.Add(ctx, int64(f.codeLines), metric.WithAttributes(attribute.String("kind", "code")))
+	linesCounter.Add(ctx, int64(f.commentLines), metric.WithAttributes(attribute.String("kind", "comment")))
+	linesCounter.Add(ctx, int64(f.whitespaceLines), metric.WithAttributes(attribute.String("kind", "whitespace")))
+	countDuration.Record(ctx, elapsed.Seconds())
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/chriskirkland/go-utils/sloc"
+	"github.com/olekukonko/tablewriter"
+)
+
+// patchFile is the lines a patch adds to and removes from one file
+type patchFile struct {
+	name           string
+	added, removed fileLines
+}
+
+// patchSide classifies one side of a hunk, context lines included so
+// comments opened before a change are followed into it
+type patchSide struct {
+	lang  *sloc.Language
+	class *sloc.Classifier
+	lines *fileLines
+}
+
+func (this *patchSide) add(line string, count bool) {
+	if this.lang == nil {
+		return
+	}
+	kind := this.class.Classify(line)
+	if !count {
+		return
+	}
+	switch {
+	case kind&sloc.CodeLine != 0:
+		this.lines.codeLines++
+	case kind&sloc.CommentLine != 0:
+		this.lines.commentLines++
+	default:
+		this.lines.whitespaceLines++
+	}
+}
+
+// runPatch classifies the lines of the patch file in args, or of stdin,
+// without either tree
+func runPatch(args []string, format string) error {
+	if len(args) > 1 {
+		return usageErrorf("usage: sloc patch [file.patch]")
+	}
+	r := io.Reader(os.Stdin)
+	if len(args) == 1 && args[0] != stdinTarget {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	patched, err := parsePatch(r)
+	if err != nil {
+		return err
+	}
+	return renderPatch(reportOutput, patched, format)
+}
+
+// parsePatch classifies the added and removed lines of a unified diff, such
+// as git diff or diff -u writes. Each hunk is classified on its own, so a
+// hunk starting inside a block comment is taken as code.
+func parsePatch(r io.Reader) ([]patchFile, error) {
+	var res []patchFile
+	var cur *patchFile
+	var oldName string
+	var old, new patchSide
+	oldLeft, newLeft := 0, 0
+
+	// lines past --max-line-bytes are classified truncated, as when counting
+	reader := bufio.NewReader(r)
+	for {
+		raw, _, _, err := sloc.ReadLine(reader, maxLineBytes)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line := string(raw)
+		if oldLeft > 0 || newLeft > 0 {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				new.add(line[1:], true)
+				newLeft--
+			case strings.HasPrefix(line, "-"):
+				old.add(line[1:], true)
+				oldLeft--
+			case strings.HasPrefix(line, `\`):
+				// "\ No newline at end of file"
+			default:
+				text := strings.TrimPrefix(line, " ")
+				old.add(text, false)
+				new.add(text, false)
+				oldLeft--
+				newLeft--
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "--- "):
+			oldName = patchPath(line[4:])
+		case strings.HasPrefix(line, "+++ "):
+			name := patchPath(line[4:])
+			if name == "" {
+				name = oldName
+			}
+			res = append(res, patchFile{name: name})
+			cur = &res[len(res)-1]
+			cur.added.filename, cur.removed.filename = name, name
+			if lang := sloc.LanguageFor(name); lang != nil {
+				cur.added.language, cur.removed.language = lang.Name, lang.Name
+			}
+		case strings.HasPrefix(line, "@@ "):
+			if cur == nil {
+				return nil, fmt.Errorf("hunk before any file header: %q", line)
+			}
+			var err error
+			if oldLeft, newLeft, err = parseHunkHeader(line); err != nil {
+				return nil, err
+			}
+			lang := sloc.LanguageFor(cur.name)
+			old = patchSide{lang: lang, lines: &cur.removed}
+			new = patchSide{lang: lang, lines: &cur.added}
+			if lang != nil {
+				old.class, new.class = sloc.NewClassifier(lang), sloc.NewClassifier(lang)
+			}
+		}
+	}
+
+	// only files in languages that can be counted
+	var counted []patchFile
+	for _, f := range res {
+		if sloc.LanguageFor(f.name) != nil {
+			counted = append(counted, f)
+		}
+	}
+	return counted, nil
+}
+
+// patchPath takes the path from a --- or +++ line, without git's a/ and b/
+// prefixes, or "" for /dev/null
+func patchPath(s string) string {
+	// diff -u follows the name with a tab and a timestamp
+	s, _, _ = strings.Cut(s, "\t")
+	if s == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(s, "a/") || strings.HasPrefix(s, "b/") {
+		return s[2:]
+	}
+	return s
+}
+
+// parseHunkHeader reads the old and new line counts from "@@ -1,5 +1,7 @@"
+func parseHunkHeader(line string) (int, int, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return 0, 0, fmt.Errorf("invalid hunk header %q", line)
+	}
+	count := func(r string) (int, error) {
+		_, n, ok := strings.Cut(r[1:], ",")
+		if !ok {
+			return 1, nil
+		}
+		return strconv.Atoi(n)
+	}
+	oldN, err := count(fields[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid hunk header %q", line)
+	}
+	newN, err := count(fields[2])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid hunk header %q", line)
+	}
+	return oldN, newN, nil
+}
+
+// renderPatch writes the added and removed lines per file, as a table or
+// as JSON
+func renderPatch(w io.Writer, files []patchFile, format string) error {
+	added, removed := fileLines{filename: "TOTAL"}, fileLines{filename: "TOTAL"}
+	for _, f := range files {
+		added.join(f.added)
+		removed.join(f.removed)
+	}
+
+	switch format {
+	case "json":
+		type jsonPatchFile struct {
+			Filename string    `json:"filename"`
+			Added    jsonLines `json:"added"`
+			Removed  jsonLines `json:"removed"`
+		}
+		report := struct {
+			Files []jsonPatchFile `json:"files"`
+			Total jsonPatchFile   `json:"total"`
+		}{Files: []jsonPatchFile{}, Total: jsonPatchFile{Filename: "TOTAL", Added: added.toJSON(), Removed: removed.toJSON()}}
+		for _, f := range files {
+			report.Files = append(report.Files, jsonPatchFile{Filename: f.name, Added: f.added.toJSON(), Removed: f.removed.toJSON()})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "table":
+		row := func(name string, a, r fileLines) []string {
+			return []string{displayPath(name),
+				tableCount(a.whitespaceLines), tableCount(a.commentLines), tableCount(a.codeLines),
+				tableCount(r.whitespaceLines), tableCount(r.commentLines), tableCount(r.codeLines)}
+		}
+		fmt.Fprintln(w)
+		table := tablewriter.NewWriter(w)
+		table.SetHeader([]string{"Filename", "+ White Space", "+ Comment", "+ Code", "- White Space", "- Comment", "- Code"})
+		table.SetFooter(row("TOTAL", added, removed))
+		table.SetBorder(false)
+		for _, f := range files {
+			table.Append(row(f.name, f.added, f.removed))
+		}
+		table.Render()
+		return nil
+	}
+	return usageErrorf("sloc patch writes table or json, not %s", format)
+}
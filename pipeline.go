@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/chriskirkland/go-utils/sloc"
+	"golang.org/x/sync/errgroup"
+)
+
+// countError records why a path couldn't be counted
+type countError struct {
+	path string
+	err  error
+}
+
+func (this *countError) Error() string {
+	// the path is already part of the message, don't repeat it
+	if pe, ok := this.err.(*fs.PathError); ok && pe.Path == this.path {
+		return fmt.Sprintf("%s: %s: %v", displayPath(this.path), pe.Op, pe.Err)
+	}
+	return fmt.Sprintf("%s: %v", displayPath(this.path), this.err)
+}
+
+func (this *countError) Unwrap() error {
+	return this.err
+}
+
+// collector is where producers send counted files, with per-file failures
+// going to a separate channel so they can't be mistaken for results
+type collector struct {
+	results  chan fileLines
+	failures chan error
+}
+
+func newCollector() collector {
+	return collector{results: make(chan fileLines), failures: make(chan error)}
+}
+
+// send delivers a file's counts, or the reason it couldn't be counted
+func (this collector) send(res fileLines, err error) {
+	if len(remoteClones) > 0 {
+		res.filename = remoteName(res.filename)
+	}
+	// a binary blob named like source isn't a failure to count it
+	if errors.Is(err, sloc.ErrBinaryFile) {
+		log.Debugf("skipping binary file %s", displayPath(res.filename))
+		return
+	}
+	if errors.Is(err, sloc.ErrIgnoredFile) {
+		log.Debugf("skipping %s, %v", displayPath(res.filename), err)
+		return
+	}
+	if err != nil {
+		this.failures <- &countError{path: res.filename, err: err}
+		return
+	}
+	this.results <- res
+}
+
+func (this collector) close() {
+	close(this.results)
+	close(this.failures)
+}
+
+// drain consumes both channels until the producers have closed them
+func (this collector) drain(onResult func(fileLines), onFailure func(error)) {
+	results, failures := this.results, this.failures
+	for results != nil || failures != nil {
+		select {
+		case res, ok := <-results:
+			if !ok {
+				results = nil
+				continue
+			}
+			onResult(res)
+		case err, ok := <-failures:
+			if !ok {
+				failures = nil
+				continue
+			}
+			onFailure(err)
+		}
+	}
+}
+
+// interrupted is the cause of a run cancelled by a signal, which picks its
+// exit code
+type interrupted struct {
+	sig os.Signal
+}
+
+func (this interrupted) Error() string {
+	return "interrupted by " + this.sig.String()
+}
+
+func (this interrupted) Unwrap() error {
+	return context.Canceled
+}
+
+func (this interrupted) exitCode() int {
+	if this.sig == syscall.SIGTERM {
+		return exitTerminated
+	}
+	return exitInterrupted
+}
+
+// handleInterrupts cancels the run on the first Ctrl-C or SIGTERM so the
+// files counted so far can still be reported, and exits immediately on the
+// second
+func handleInterrupts(cancel context.CancelCauseFunc) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Warningf("%v, reporting partial results (Ctrl-C again to quit now)", sig)
+		cancel(interrupted{sig})
+		os.Exit(interrupted{<-sigs}.exitCode())
+	}()
+}
+
+// with --strict, the first path that can't be counted stops the run
+var failFast context.CancelCauseFunc
+
+// reportSink receives the per-file results and totals once a run completes;
+// target names what was scanned (the CLI arguments, or a daemon repo)
+type reportSink func(target string, files []fileLines, total fileLines) error
+
+// runPipeline runs produce, aggregating whatever it sends as it goes, and
+// renders the report once it returns. Both run in a group whose context
+// produce walks and counts under, so the first error it returns stops the
+// workers still counting rather than waiting on them. The channels are only
+// closed after produce has finished and the report is only rendered after
+// they're drained, so what was counted is reported whichever side fails
+// first. It returns the number of paths that couldn't be counted and the
+// error that stopped produce, if any.
+func runPipeline(ctx context.Context, target string, render renderer, sinks []reportSink, produce func(ctx context.Context, out collector) error) (int, error) {
+	out := newCollector()
+	g, groupCtx := errgroup.WithContext(ctx)
+
+	var failed int
+	g.Go(func() error {
+		failed = processResults(ctx, target, out, render, sinks...)
+		return nil
+	})
+	g.Go(func() error {
+		defer out.close()
+		return produce(groupCtx, out)
+	})
+	err := g.Wait()
+	return failed, err
+}
+
+// processResults aggregates results as they arrive, then renders the report
+// and returns the number of paths that couldn't be counted
+func processResults(ctx context.Context, target string, in collector, render renderer, sinks ...reportSink) int {
+	_, span := tracer.Start(ctx, "aggregate")
+	total := fileLines{filename: "TOTAL"}
+	var files []fileLines
+	var failed, oversized []error
+	counted := 0
+	generated := fileLines{}
+	status := startProgress()
+	var stream *tableStream
+
+	in.drain(func(res fileLines) {
+		log.Debugf("counted %s: %d code, %d comment, %d blank", displayPath(res.filename), res.codeLines, res.commentLines, res.whitespaceLines)
+		status.add(res)
+		if res.generated && !includeGenerated {
+			generated.join(res)
+			generated.files++
+			return
+		}
+
+		total.join(res)
+		counted++
+		if streamResult != nil {
+			if err := streamResult(reportOutput, res); err != nil {
+				log.Error(err)
+			}
+			// keep memory flat unless a sink needs the files
+			if len(sinks) == 0 {
+				return
+			}
+		}
+		if stream != nil {
+			stream.add(res)
+			if len(sinks) == 0 {
+				return
+			}
+		}
+		files = append(files, res)
+		if streamTable && stream == nil && streamTableAfter > 0 && len(files) > streamTableAfter {
+			if !quiet {
+				fmt.Fprintf(os.Stderr, "over %d files, writing the rest of the table as they're counted (--stream-table-after)\n", streamTableAfter)
+			}
+			sort.Slice(files, func(i, j int) bool { return files[i].filename < files[j].filename })
+			stream = newTableStream(reportOutput, files)
+			if len(sinks) == 0 {
+				files = nil
+			}
+		}
+	}, func(err error) {
+		// oversized files are left out on purpose, not a failure to count
+		if errors.Is(err, errFileTooLarge) {
+			log.Debugf("skipping %v", err)
+			oversized = append(oversized, err)
+			return
+		}
+		failed = append(failed, err)
+		if failFast != nil {
+			failFast(err)
+			return
+		}
+		log.Warningf("skipping %v", err)
+	})
+	status.stop()
+
+	span.End()
+	if listOnly {
+		return len(failed)
+	}
+
+	// concurrent producers deliver in any order; report in path order so
+	// runs can be diffed
+	sort.Slice(files, func(i, j int) bool { return files[i].filename < files[j].filename })
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Error() < failed[j].Error() })
+	sort.Slice(oversized, func(i, j int) bool { return oversized[i].Error() < oversized[j].Error() })
+	oversizedFiles = nil
+	for _, err := range oversized {
+		var skipped *countError
+		if errors.As(err, &skipped) {
+			oversizedFiles = append(oversizedFiles, finding{rule: "oversized-file", path: skipped.path, message: skipped.err.Error()})
+		}
+	}
+
+	changed := 0
+	for _, err := range failed {
+		if isTreeChange(err) {
+			changed++
+		}
+	}
+
+	// a cancelled run still reports what it got, clearly marked, as does one
+	// taken from a tree that changed underneath it
+	if ctx.Err() != nil {
+		total.filename = "TOTAL (incomplete)"
+	} else if changed > 0 {
+		total.filename = "TOTAL (tree changed)"
+	}
+	if counted == 0 && len(failed) == 0 && len(oversized) == 0 && generated.files == 0 {
+		log.Warningf("no supported source files found in %s", target)
+	}
+
+	// render the report and hand the results to any sinks
+	_, span = tracer.Start(ctx, "output")
+	outputStart := time.Now()
+	countedFiles = files
+	rows := files
+	if key := groupKeys[groupBy]; key != nil {
+		rows = groupResults(files, key)
+	}
+	if sortBy != "name" || sortDesc {
+		rows = slices.Clone(rows)
+		sortRows(rows)
+	}
+	if topN > 0 && len(rows) > topN {
+		rows = rows[:topN]
+	}
+	if summaryOnly {
+		rows = nil
+	}
+	if stream != nil {
+		stream.close(total)
+	} else if err := render(reportOutput, rows, total); err != nil {
+		log.Error(err)
+	}
+	writeReportCopies(rows, total)
+	if showCocomo && ctx.Err() == nil {
+		writeCocomo(reportOutput, total)
+	}
+	if findDuplicates {
+		writeDuplicates(reportOutput, files)
+	}
+	if detectDuplication {
+		writeDuplication(reportOutput, files)
+	}
+	if showHistogram {
+		writeHistogram(reportOutput, files)
+	}
+	if languageSummary {
+		writeLanguageSummary(reportOutput, files)
+	}
+	if maxLineLength > 0 {
+		writeLongLines(reportOutput, files)
+	}
+	if complexityOver > 0 {
+		writeComplexity(reportOutput, files)
+	}
+	if showTags {
+		writeTags(reportOutput, files, total)
+	}
+	if showEmbeds {
+		writeEmbeds(reportOutput, files)
+	}
+	if styleReport {
+		writeStyle(reportOutput, files)
+	}
+	for _, sink := range sinks {
+		// partial totals would skew whatever the sinks feed
+		if ctx.Err() != nil {
+			log.Warning("interrupted, not sending results to sinks")
+			break
+		}
+		if err := sink(target, files, total); err != nil {
+			log.Error(err)
+		}
+	}
+	span.End()
+	if runTimings != nil {
+		runTimings.output = time.Since(outputStart)
+	}
+
+	if cause := context.Cause(ctx); failFast != nil && len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "\nstopped at %v (--strict): the report only covers the %d files counted before it\n", cause, counted)
+	} else if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "\ninterrupted: the report only covers the %d files counted so far\n", counted)
+	}
+
+	// summarize what was skipped after the report so it isn't missed
+	if generated.files > 0 && !quiet {
+		fmt.Fprintf(os.Stderr, "\ngenerated: %d files (code %d, comments %d, blank %d) not counted, --include-generated to count them\n",
+			generated.files, generated.codeLines, generated.commentLines, generated.whitespaceLines)
+	}
+	if n := duplicatePaths.Swap(0); n > 0 && !quiet {
+		fmt.Fprintf(os.Stderr, "\nskipped: %d paths already counted, reached again by a hard link or an overlapping argument (--count-hardlinks counts every link)\n", n)
+	}
+	if len(oversized) > 0 && !quiet {
+		fmt.Fprintf(os.Stderr, "\nskipped: %d files larger than %v (--max-file-size):\n", len(oversized), &maxFileSize)
+		for _, err := range oversized {
+			fmt.Fprintf(os.Stderr, "  %v\n", err)
+		}
+	}
+	if len(failed) > 0 {
+		denied := 0
+		fmt.Fprintf(os.Stderr, "\n%d paths could not be counted, totals are partial:\n", len(failed))
+		for _, err := range failed {
+			fmt.Fprintf(os.Stderr, "  %v\n", err)
+			if errors.Is(err, fs.ErrPermission) {
+				denied++
+			}
+		}
+		if denied > 0 {
+			fmt.Fprintf(os.Stderr, "skipped: %d paths (permission denied)\n", denied)
+		}
+		if changed > 0 {
+			fmt.Fprintf(os.Stderr, "skipped: %d paths (modified or removed during the scan)\n", changed)
+		}
+	}
+
+	return len(failed)
+}
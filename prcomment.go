@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// prCommentMarker starts every comment, so a bot can find and update its
+// earlier one instead of posting another
+const prCommentMarker = "<!-- sloc-pr-comment -->"
+
+// prPackage is the lines a pull request adds and removes in one directory
+type prPackage struct {
+	dir            string
+	added, removed fileLines
+}
+
+// runPRComment writes the summary of a pull request from the base in args
+// to its head, HEAD unless given, for a bot to post
+func runPRComment(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usageErrorf("usage: sloc pr-comment <base> [head]")
+	}
+	head := "HEAD"
+	if len(args) == 2 {
+		head = args[1]
+	}
+	changed, added, err := prChanges(args[0], head)
+	if err != nil {
+		return err
+	}
+	return writePRComment(reportOutput, changed, added)
+}
+
+// prChanges classifies the lines changed between the merge base of base and
+// head, as a pull request's diff shows them, and lists the files it adds
+func prChanges(base, head string) ([]patchFile, map[string]bool, error) {
+	mergeBase, err := gitOutput("merge-base", base, head)
+	if err != nil {
+		return nil, nil, err
+	}
+	mergeBase = strings.TrimSpace(mergeBase)
+
+	patch, err := gitOutput("diff", "--no-renames", "--relative", "--no-color", mergeBase, head)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := parsePatch(strings.NewReader(patch))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out, err := gitOutput("diff", "--name-only", "--diff-filter=A", "--no-renames", "--relative", "-z", mergeBase, head)
+	if err != nil {
+		return nil, nil, err
+	}
+	added := map[string]bool{}
+	for _, path := range strings.Split(out, "\x00") {
+		if path != "" {
+			added[path] = true
+		}
+	}
+	return files, added, nil
+}
+
+// writePRComment writes a GitHub-flavored markdown summary of a pull
+// request's changes per directory, and the source files it adds
+func writePRComment(w io.Writer, files []patchFile, added map[string]bool) error {
+	packages := map[string]*prPackage{}
+	var total prPackage
+	var newFiles []patchFile
+	for _, f := range files {
+		dir := filepath.ToSlash(filepath.Dir(f.name))
+		if packages[dir] == nil {
+			packages[dir] = &prPackage{dir: dir}
+		}
+		packages[dir].added.join(f.added)
+		packages[dir].removed.join(f.removed)
+		total.added.join(f.added)
+		total.removed.join(f.removed)
+		if added[f.name] {
+			newFiles = append(newFiles, f)
+		}
+	}
+	var rows []*prPackage
+	for _, p := range packages {
+		rows = append(rows, p)
+	}
+	// the biggest changes first
+	sort.Slice(rows, func(i, j int) bool {
+		ci := rows[i].added.codeLines + rows[i].removed.codeLines
+		cj := rows[j].added.codeLines + rows[j].removed.codeLines
+		if ci != cj {
+			return ci > cj
+		}
+		return rows[i].dir < rows[j].dir
+	})
+
+	signed := func(n int) string {
+		if n > 0 {
+			return fmt.Sprintf("+%d", n)
+		}
+		return fmt.Sprint(n)
+	}
+	countFiles := func(n int) string {
+		if n == 1 {
+			return "1 file"
+		}
+		return fmt.Sprintf("%d files", n)
+	}
+	cell := strings.NewReplacer("|", `\|`, "`", "'").Replace
+
+	var b strings.Builder
+	b.WriteString(prCommentMarker + "\n")
+	if len(files) == 0 {
+		b.WriteString("### Lines of code\n\nNo source files changed.\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	fmt.Fprintf(&b, "### Lines of code: %s net\n\n", signed(total.added.codeLines-total.removed.codeLines))
+	fmt.Fprintf(&b, "**+%d / -%d** code lines, +%d / -%d comment lines across %s.\n\n",
+		total.added.codeLines, total.removed.codeLines, total.added.commentLines, total.removed.commentLines, countFiles(len(files)))
+
+	b.WriteString("| Package | + Code | - Code | Net code | + Comment | - Comment |\n")
+	b.WriteString("|:--|--:|--:|--:|--:|--:|\n")
+	for _, p := range rows {
+		fmt.Fprintf(&b, "| `%s` | %d | %d | %s | %d | %d |\n", cell(p.dir),
+			p.added.codeLines, p.removed.codeLines, signed(p.added.codeLines-p.removed.codeLines),
+			p.added.commentLines, p.removed.commentLines)
+	}
+	fmt.Fprintf(&b, "| **Total** | **%d** | **%d** | **%s** | **%d** | **%d** |\n",
+		total.added.codeLines, total.removed.codeLines, signed(total.added.codeLines-total.removed.codeLines),
+		total.added.commentLines, total.removed.commentLines)
+
+	if len(newFiles) > 0 {
+		fmt.Fprintf(&b, "\n<details>\n<summary>%s added</summary>\n\n", countFiles(len(newFiles)))
+		for _, f := range newFiles {
+			fmt.Fprintf(&b, "- `%s` (%s, %d code lines)\n", cell(f.name), f.added.language, f.added.codeLines)
+		}
+		b.WriteString("\n</details>\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+)
+
+// startProfiles starts a CPU profile into cpuPath and returns a function
+// that stops it and writes a heap profile into memPath, either path may be
+// left empty
+func startProfiles(cpuPath, memPath string) (func(), error) {
+	var cpu *os.File
+	if cpuPath != "" {
+		var err error
+		if cpu, err = os.Create(cpuPath); err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, err
+		}
+	}
+	return func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			cpu.Close()
+		}
+		if memPath == "" {
+			return
+		}
+		mem, err := os.Create(memPath)
+		if err != nil {
+			log.Errorf("writing the memory profile: %v", err)
+			return
+		}
+		defer mem.Close()
+		// up to date statistics of what's still live
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(mem); err != nil {
+			log.Errorf("writing the memory profile: %v", err)
+		}
+	}, nil
+}
+
+// timings are what --timings reports of a run. Reading and counting are
+// summed over the workers, so they can add up to more than the walk they
+// happen alongside.
+type timings struct {
+	start        time.Time
+	walk, output time.Duration
+	read, count  atomic.Int64 // nanoseconds
+}
+
+// runTimings is set by --timings
+var runTimings *timings
+
+// timedReader adds the time spent waiting on reads to the run's read time
+type timedReader struct {
+	r    io.Reader
+	read *atomic.Int64
+}
+
+func (this timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := this.r.Read(p)
+	this.read.Add(int64(time.Since(start)))
+	return n, err
+}
+
+// timeCount counts r through count, taking the time it didn't spend
+// reading as counting
+func (this *timings) timeCount(r io.Reader, count func(r io.Reader) (fileLines, error)) (fileLines, error) {
+	var read atomic.Int64
+	start := time.Now()
+	res, err := count(timedReader{r: r, read: &read})
+	this.read.Add(read.Load())
+	this.count.Add(int64(time.Since(start)) - read.Load())
+	return res, err
+}
+
+func (this *timings) write(w io.Writer) {
+	round := func(d time.Duration) time.Duration { return d.Round(time.Millisecond) }
+	fmt.Fprintf(w, "\ntimings: walk %v, read %v, count %v (summed over -j %d), output %v, total %v\n",
+		round(this.walk), round(time.Duration(this.read.Load())), round(time.Duration(this.count.Load())),
+		max(jobs, 1), round(this.output), round(time.Since(this.start)))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// showProgress is cleared by --no-progress
+var showProgress = true
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progress keeps a status line on stderr up to date while a scan runs, so
+// a long one doesn't look hung. Scans that finish quickly never show it.
+type progress struct {
+	files, lines atomic.Int64
+	done         chan struct{}
+	wg           sync.WaitGroup
+}
+
+// startProgress returns nil, which is safe to use, when stderr isn't a
+// terminal or the progress line is turned off
+func startProgress() *progress {
+	if !showProgress || !isTerminal(os.Stderr) {
+		return nil
+	}
+
+	this := &progress{done: make(chan struct{})}
+	this.wg.Add(1)
+	go func() {
+		defer this.wg.Done()
+		delay := time.NewTimer(time.Second)
+		defer delay.Stop()
+		select {
+		case <-delay.C:
+		case <-this.done:
+			return
+		}
+
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			fmt.Fprintf(os.Stderr, "\r\033[K%d files processed, %d lines counted", this.files.Load(), this.lines.Load())
+			select {
+			case <-ticker.C:
+			case <-this.done:
+				fmt.Fprint(os.Stderr, "\r\033[K")
+				return
+			}
+		}
+	}()
+	return this
+}
+
+func (this *progress) add(f fileLines) {
+	if this == nil {
+		return
+	}
+	this.files.Add(1)
+	this.lines.Add(int64(f.codeLines + f.commentLines + f.whitespaceLines + f.mixedLines + f.licenseLines + f.directiveLines))
+}
+
+// stop clears the status line before the report is written
+func (this *progress) stop() {
+	if this == nil {
+		return
+	}
+	close(this.done)
+	this.wg.Wait()
+}
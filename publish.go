@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+const defaultPublishSubject = "sloc.results"
+
+type publishMessage struct {
+	Type   string     `json:"type"`
+	Target string     `json:"target"`
+	Time   time.Time  `json:"time"`
+	Files  int        `json:"files,omitempty"`
+	Lines  *jsonLines `json:"lines"`
+}
+
+func newPublishSink(target string, perFile bool) (reportSink, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := strings.TrimPrefix(u.Path, "/")
+	if subject == "" {
+		subject = defaultPublishSubject
+	}
+
+	var send func(msgs [][]byte) error
+	switch u.Scheme {
+	case "nats":
+		server := (&url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host}).String()
+		send = func(msgs [][]byte) error { return publishNATS(server, subject, msgs) }
+	case "kafka":
+		brokers := strings.Split(u.Host, ",")
+		send = func(msgs [][]byte) error { return publishKafka(brokers, subject, msgs) }
+	default:
+		return nil, fmt.Errorf("unsupported publish target %q", target)
+	}
+
+	return func(name string, files []fileLines, total fileLines) error {
+		now := time.Now().UTC()
+		totalJSON := total.toJSON()
+		run, err := json.Marshal(publishMessage{Type: "run", Target: name, Time: now, Files: len(files), Lines: &totalJSON})
+		if err != nil {
+			return err
+		}
+
+		msgs := [][]byte{run}
+		if perFile {
+			for _, f := range files {
+				fileJSON := f.toJSON()
+				msg, err := json.Marshal(publishMessage{Type: "file", Target: name, Time: now, Lines: &fileJSON})
+				if err != nil {
+					return err
+				}
+				msgs = append(msgs, msg)
+			}
+		}
+
+		log.Debugf("publishing %d messages to %s", len(msgs), target)
+		return send(msgs)
+	}, nil
+}
+
+func publishNATS(server, subject string, msgs [][]byte) error {
+	nc, err := nats.Connect(server)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	for _, msg := range msgs {
+		if err := nc.Publish(subject, msg); err != nil {
+			return err
+		}
+	}
+	return nc.Flush()
+}
+
+func publishKafka(brokers []string, topic string, msgs [][]byte) error {
+	w := &kafka.Writer{
+		Addr:     kafka.TCP(brokers...),
+		Topic:    topic,
+		Balancer: &kafka.LeastBytes{},
+	}
+	defer w.Close()
+
+	kmsgs := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		kmsgs[i] = kafka.Message{Value: msg}
+	}
+	return w.WriteMessages(context.Background(), kmsgs...)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// countRequest is the body of a POST /count for a path on the server
+type countRequest struct {
+	Path string `json:"path"`
+}
+
+type countReport struct {
+	Target string      `json:"target"`
+	Time   time.Time   `json:"time"`
+	Files  []jsonLines `json:"files"`
+	Total  jsonLines   `json:"total"`
+}
+
+type server struct {
+	root      string // paths are only counted below it
+	maxUpload int64
+
+	mu     sync.Mutex
+	last   *countReport
+	counts map[string]*servedCount // by resolved path
+}
+
+// servedCount is a path's count, reused while its treeKey holds so repeated
+// report and badge requests neither recount nor change their ETag
+type servedCount struct {
+	key   string
+	time  time.Time
+	files []fileLines
+	total fileLines
+}
+
+// errUploadTooLarge is an archive over --max-upload
+var errUploadTooLarge = errors.New("archive too large")
+
+// archiveTypes map request content types to the archive extensions they
+// are counted as
+var archiveTypes = map[string]string{
+	"application/zip":    ".zip",
+	"application/x-tar":  ".tar",
+	"application/gzip":   ".tar.gz",
+	"application/x-gzip": ".tar.gz",
+}
+
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listen := flags.String("listen", ":8080", "address to serve the API on")
+	root := flags.String("root", ".", "only count paths below this directory")
+	maxUpload := byteSize(100 << 20)
+	flags.Var(&maxUpload, "max-upload", "the largest archive that may be uploaded")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	abs, err := filepath.Abs(*root)
+	if err != nil {
+		return err
+	}
+	// requested paths are compared once their symlinks are resolved, so the
+	// root's must be too or one under a symlink, such as /tmp on macOS,
+	// would have every path outside it
+	abs, err = filepath.EvalSymlinks(abs)
+	if err != nil {
+		return err
+	}
+	s := &server{root: abs, maxUpload: int64(maxUpload), counts: map[string]*servedCount{}}
+
+	mux := http.NewServeMux()
+	registerRoutes(mux, "sloc serve", []apiRoute{
+		{method: "GET", path: "/healthz", summary: "liveness check", handler: s.handleHealth},
+		{method: "POST", path: "/count", summary: `count {"path": PATH} below the root, or an uploaded zip, tar or tar.gz archive`,
+			request: countRequest{}, uploads: slices.Sorted(maps.Keys(archiveTypes)), response: countReport{},
+			errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusRequestEntityTooLarge}, handler: s.handleCount},
+		{method: "GET", path: "/reports", summary: "per-file report for ?path=PATH below the root, the root itself by default, recounted only when it changed", query: []string{"path"},
+			response: countReport{}, errors: []int{http.StatusForbidden, http.StatusNotFound}, handler: s.handleReports},
+		{method: "GET", path: "/badge", summary: "SVG badge of the code total of ?path=PATH, in ?color=NAME", query: []string{"path", "color"},
+			produces: "image/svg+xml", errors: []int{http.StatusForbidden, http.StatusNotFound}, handler: s.handleBadge},
+		{method: "GET", path: "/last", summary: "the most recent count", response: countReport{}, errors: []int{http.StatusNotFound}, handler: s.handleLast},
+	})
+
+	log.Noticef("serving counts of %s on %s", abs, *listen)
+	return http.ListenAndServe(*listen, mux)
+}
+
+func (this *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+func (this *server) handleLast(w http.ResponseWriter, r *http.Request) {
+	this.mu.Lock()
+	last := this.last
+	this.mu.Unlock()
+	if last == nil {
+		http.Error(w, "nothing counted yet", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, last)
+}
+
+func (this *server) handleCount(w http.ResponseWriter, r *http.Request) {
+	var target string
+	var at time.Time
+	var files []fileLines
+	var total fileLines
+
+	contentType := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0])
+	if ext, ok := archiveTypes[contentType]; ok {
+		target, at = "upload"+ext, time.Now()
+		var err error
+		if files, total, err = this.countUpload(r.Context(), r.Body, ext); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errUploadTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if !includeGenerated {
+			files, total = withoutGenerated(files)
+		}
+	} else {
+		var req countRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+			http.Error(w, `want {"path": PATH} or an archive with its content type`, http.StatusBadRequest)
+			return
+		}
+		count, ok := this.countPath(w, req.Path)
+		if !ok {
+			return
+		}
+		target, at, files, total = req.Path, count.time, count.files, count.total
+	}
+
+	report := newCountReport(target, at, files, total)
+	this.mu.Lock()
+	this.last = report
+	this.mu.Unlock()
+	writeJSON(w, r, report)
+}
+
+func (this *server) handleReports(w http.ResponseWriter, r *http.Request) {
+	target := requestedPath(r)
+	if count, ok := this.countPath(w, target); ok {
+		writeJSON(w, r, newCountReport(target, count.time, count.files, count.total))
+	}
+}
+
+func (this *server) handleBadge(w http.ResponseWriter, r *http.Request) {
+	color := r.URL.Query().Get("color")
+	if color == "" {
+		color = "blue"
+	}
+	if count, ok := this.countPath(w, requestedPath(r)); ok {
+		writeTagged(w, r, "image/svg+xml", []byte(codeBadge(count.files, count.total, color)))
+	}
+}
+
+// requestedPath is the ?path= of a request, the root if it has none
+func requestedPath(r *http.Request) string {
+	if path := r.URL.Query().Get("path"); path != "" {
+		return path
+	}
+	return "."
+}
+
+// countPath counts a requested path below the root, or answers with why it
+// can't be. The last count is reused while the path's treeKey is unchanged.
+func (this *server) countPath(w http.ResponseWriter, requested string) (*servedCount, bool) {
+	path, err := this.resolve(requested)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return nil, false
+	}
+	if _, err := os.Stat(path); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+
+	key := treeKey(path)
+	this.mu.Lock()
+	cached := this.counts[path]
+	this.mu.Unlock()
+	if cached != nil && cached.key == key {
+		log.Debugf("%s unchanged at %s, using cached count", path, key)
+		return cached, true
+	}
+
+	count := &servedCount{key: key, time: time.Now()}
+	count.files, count.total = countTreeSkipping(path, this.leaves)
+	if !includeGenerated {
+		count.files, count.total = withoutGenerated(count.files)
+	}
+	this.mu.Lock()
+	this.counts[path] = count
+	this.mu.Unlock()
+	return count, true
+}
+
+func newCountReport(target string, at time.Time, files []fileLines, total fileLines) *countReport {
+	report := &countReport{Target: target, Time: at.UTC(), Files: []jsonLines{}, Total: total.toJSON()}
+	for _, f := range files {
+		report.Files = append(report.Files, f.toJSON())
+	}
+	return report
+}
+
+// resolve places a requested path below the root, refusing any that would
+// leave it
+func (this *server) resolve(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(this.root, path)
+	}
+	path = filepath.Clean(path)
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	if !this.contains(path) {
+		return "", fmt.Errorf("%s is outside %s", path, this.root)
+	}
+	return path, nil
+}
+
+// contains reports whether a resolved path is the root or below it
+func (this *server) contains(path string) bool {
+	return path == this.root || strings.HasPrefix(path, this.root+string(filepath.Separator))
+}
+
+// leaves reports whether a path the walk of a requested one reaches leads
+// out of the root: a link to a file outside it, or a directory that's
+// outside it once resolved, as one --follow-symlinks enters would be
+func (this *server) leaves(path string, info os.FileInfo) bool {
+	if !info.IsDir() && info.Mode()&os.ModeSymlink == 0 {
+		return false
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return true
+	}
+	if !this.contains(real) {
+		log.Debugf("skipping %s, it leads outside %s", path, this.root)
+		return true
+	}
+	return false
+}
+
+// countUpload counts an uploaded archive, kept in a temporary file as zips
+// need random access
+func (this *server) countUpload(ctx context.Context, body io.Reader, ext string) ([]fileLines, fileLines, error) {
+	total := fileLines{filename: "TOTAL"}
+	tmp, err := os.CreateTemp("", "sloc-upload-*"+ext)
+	if err != nil {
+		return nil, total, err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, io.LimitReader(body, this.maxUpload+1))
+	tmp.Close()
+	if err != nil {
+		return nil, total, err
+	}
+	if n > this.maxUpload {
+		return nil, total, fmt.Errorf("%w, over %s", errUploadTooLarge, formatSize(this.maxUpload))
+	}
+
+	out := newCollector()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- processArchive(ctx, tmp.Name(), out)
+		out.close()
+	}()
+	var files []fileLines
+	out.drain(func(res fileLines) {
+		res.filename = "upload" + ext + strings.TrimPrefix(res.filename, tmp.Name())
+		files = append(files, res)
+		total.join(res)
+	}, func(err error) {
+		log.Warningf("skipping %v", err)
+	})
+	return files, total, <-errc
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestServeSymlinksOutOfRoot counts a root holding links to a directory
+// and a file outside it, which are left out with or without
+// --follow-symlinks
+func TestServeSymlinksOutOfRoot(t *testing.T) {
+	tmp, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, outside := filepath.Join(tmp, "root"), filepath.Join(tmp, "outside")
+	for _, dir := range []string{root, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{filepath.Join(root, "a.go"), filepath.Join(outside, "b.go")} {
+		if err := os.WriteFile(path, []byte("package x\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "out")); err != nil {
+		t.Skipf("can't create symlinks: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "b.go"), filepath.Join(root, "b.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	following := followSymlinks
+	t.Cleanup(func() { followSymlinks = following })
+	for _, follow := range []bool{false, true} {
+		followSymlinks = follow
+		s := &server{root: root, counts: map[string]*servedCount{}}
+		count, ok := s.countPath(httptest.NewRecorder(), ".")
+		if !ok {
+			t.Fatalf("follow=%v: %s not counted", follow, root)
+		}
+		var names []string
+		for _, f := range count.files {
+			names = append(names, filepath.Base(f.filename))
+		}
+		if len(names) != 1 || names[0] != "a.go" {
+			t.Errorf("follow=%v: counted %v, want only a.go", follow, names)
+		}
+	}
+}
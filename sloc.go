@@ -1,34 +1,289 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"go/parser"
+	"go/token"
+	"hash"
+	"io"
+	"io/fs"
+	"maps"
+	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strconv"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode/utf8"
 
-	"github.com/olekukonko/tablewriter"
+	"github.com/chriskirkland/go-utils/sloc"
 	"github.com/op/go-logging"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-var log = logging.MustGetLogger("example")
-var format = logging.MustStringFormatter(
-	`%{color}%{time:15:04:05.000} %{shortfunc} ▶ %{level:.4s} %{id:03x}%{color:reset} %{message}`,
+var log = logging.MustGetLogger("sloc")
+
+// diagnostics go to stderr as "WARN message", the level colored on a
+// terminal, so stdout only ever has the report
+var (
+	logFormat      = logging.MustStringFormatter(`%{level:.4s} %{message}`)
+	logColorFormat = logging.MustStringFormatter(`%{color}%{level:.4s}%{color:reset} %{message}`)
 )
 
+// with --quiet, only errors are logged and the notes on skipped files after
+// the report are left out
+var quiet bool
+
+func setupLogging(level logging.Level) {
+	backend := logging.NewLogBackend(os.Stderr, "", 0)
+	format := logFormat
+	if useColor(os.Stderr) {
+		format = logColorFormat
+	}
+	leveled := logging.AddModuleLevel(logging.NewBackendFormatter(backend, format))
+	leveled.SetLevel(level, "")
+	logging.SetBackend(leveled)
+}
+
+// lines longer than this are only classified by their first maxLineBytes
+var maxLineBytes = 1 << 20
+
 type fileLines struct {
 	filename        string
+	language        string
+	files           int // files summed into a grouped row
 	codeLines       int
 	commentLines    int
 	whitespaceLines int
+	mixedLines      int              // code lines with a comment, with --mixed separate
+	licenseLines    int              // comment lines in the license header, with --license-headers
+	directiveLines  int              // tool directives such as //go:build, with --directives separate
+	longLines       int              // lines truncated to maxLineBytes
+	cgoLines        int              // C code lines in a cgo preamble, counted as code
+	bom             bool             // the file started with a byte order mark
+	encoding        string           // the file was transcoded from this encoding to UTF-8
+	lineEnding      string           // the dominant line terminator
+	generated       bool             // the file is marked or named as generated code
+	embeds          []string         // //go:embed patterns, with --embeds
+	tags            map[string]int   // technical debt markers in comments, with --todo
+	tagSites        []tagSite        // where they are, with --todo-list
+	funcs           []funcComplexity // Go functions, with --complexity
+	decls           goDecls          // Go declarations, with --decls
+	lengths         lineLengths      // non-blank lines by length, with --line-lengths
+	overLong        []longLine       // lines over --max-line-length
+	hash            string           // of the contents, with --duplicates
+	unique          uniqueLines      // distinct non-blank lines, with --uloc
+	logicalLines    int              // Go statements and declarations, with --logical
+	style           styleStats       // indentation and trailing whitespace, with --style-report
 }
 
+// mixedMode decides what a line holding both code and a comment counts as:
+// "code", "comment", "both", or "separate" to count it in its own column
+var mixedMode = "code"
+
+// directiveMode decides what comments such as //go:build and //nolint count
+// as: "comment", "code", or "separate" to count them in their own column
+var directiveMode = "comment"
+
+// blankCommentMode decides what a blank line inside a block comment counts
+// as: "blank" or "comment"
+var blankCommentMode = "blank"
+
+// goMode picks how Go files are classified: "scanner" uses the Go tokenizer
+// and falls back to the heuristic classifier for files it can't scan
+var goMode = "scanner"
+
+// generated files are left out of the report unless this is set
+var includeGenerated bool
+
 func (this *fileLines) join(f fileLines) {
 	this.codeLines += f.codeLines
 	this.commentLines += f.commentLines
 	this.whitespaceLines += f.whitespaceLines
+	this.mixedLines += f.mixedLines
+	this.licenseLines += f.licenseLines
+	this.directiveLines += f.directiveLines
+	this.cgoLines += f.cgoLines
+	this.funcs = append(this.funcs, f.funcs...)
+	this.decls.join(f.decls)
+	this.lengths.join(f.lengths)
+	this.unique.join(f.unique)
+	this.logicalLines += f.logicalLines
+	this.style.join(f.style)
+	for tag, n := range f.tags {
+		if this.tags == nil {
+			this.tags = map[string]int{}
+		}
+		this.tags[tag] += n
+	}
+}
+
+// jsonLines is the machine-readable form of fileLines
+type jsonLines struct {
+	Filename   string         `json:"filename"`
+	Language   string         `json:"language,omitempty"`
+	Files      int            `json:"files,omitempty"`
+	Whitespace int            `json:"whitespace"`
+	Comment    int            `json:"comment"`
+	Code       int            `json:"code"`
+	Mixed      int            `json:"mixed,omitempty"`
+	License    int            `json:"license,omitempty"`
+	Directive  int            `json:"directive,omitempty"`
+	Cgo        int            `json:"cgo,omitempty"`
+	Tags       map[string]int `json:"tags,omitempty"`
+	// MaxComplexity and AverageComplexity are of the Go functions, with
+	// --complexity
+	MaxComplexity     int              `json:"max_complexity,omitempty"`
+	AverageComplexity float64          `json:"average_complexity,omitempty"`
+	Declarations      *jsonDecls       `json:"declarations,omitempty"`
+	LineLength        *jsonLineLengths `json:"line_length,omitempty"`
+	Unique            int              `json:"uloc,omitempty"`
+	Logical           int              `json:"logical,omitempty"`
+
+	// FilenameBytes carries the raw (base64) path when it isn't valid UTF-8,
+	// since Filename then has the invalid bytes replaced
+	FilenameBytes []byte `json:"filename_bytes,omitempty"`
+}
+
+// jsonDecls is the machine-readable form of goDecls
+type jsonDecls struct {
+	Funcs    int `json:"funcs"`
+	Methods  int `json:"methods"`
+	Types    int `json:"types"`
+	Exported int `json:"exported"`
+	// functions and methods with a doc comment, and their share of them
+	Documented  int     `json:"documented"`
+	DocCoverage float64 `json:"doc_coverage"`
+}
+
+func (this fileLines) toJSON() jsonLines {
+	res := jsonLines{
+		Filename:   this.filename,
+		Language:   this.language,
+		Files:      this.files,
+		Whitespace: this.whitespaceLines,
+		Comment:    this.commentLines,
+		Code:       this.codeLines,
+		Mixed:      this.mixedLines,
+		License:    this.licenseLines,
+		Directive:  this.directiveLines,
+		Cgo:        this.cgoLines,
+		Tags:       this.tags,
+	}
+	if len(this.funcs) > 0 {
+		res.MaxComplexity = maxComplexity(this)
+		res.AverageComplexity = math.Round(averageComplexity(this)*10) / 10
+	}
+	if measureLineLengths {
+		res.LineLength = this.lengths.toJSON()
+	}
+	res.Unique, res.Logical = len(this.unique), this.logicalLines
+	if countDecls {
+		d := this.decls
+		res.Declarations = &jsonDecls{Funcs: d.Funcs, Methods: d.Methods, Types: d.Types, Exported: d.Exported,
+			Documented: d.Documented, DocCoverage: math.Round(1000*d.docCoverage()) / 10}
+	}
+	if !utf8.ValidString(this.filename) {
+		res.FilenameBytes = []byte(this.filename)
+	}
+	return res
+}
+
+// fromJSON reads back a file's counts from a saved JSON report
+func (this jsonLines) fromJSON() fileLines {
+	res := fileLines{
+		filename:        this.Filename,
+		language:        this.Language,
+		files:           this.Files,
+		whitespaceLines: this.Whitespace,
+		commentLines:    this.Comment,
+		codeLines:       this.Code,
+		mixedLines:      this.Mixed,
+		licenseLines:    this.License,
+		directiveLines:  this.Directive,
+		cgoLines:        this.Cgo,
+		tags:            this.Tags,
+	}
+	if this.FilenameBytes != nil {
+		res.filename = string(this.FilenameBytes)
+	}
+	return res
+}
+
+// testNames are the naming conventions for test files, matched against the
+// base name
+var testNames = []string{"*_test.go", "test_*.py", "*_test.py", "*_test.rb", "*_spec.rb", "*.test.js", "*.spec.js", "*.test.ts", "*.spec.ts", "*.test.tsx", "*.spec.tsx", "*Test.java", "*Test.kt"}
+
+// isTestFile reports whether path is named like a test
+func isTestFile(path string) bool {
+	for _, pattern := range testNames {
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isSourceFile reports whether path is in a language that can be counted
+func isSourceFile(path string) bool {
+	return sloc.LanguageFor(path) != nil
+}
+
+// isScript reports whether an extensionless regular file has a shebang for
+// a language that can be counted
+func isScript(path string, info os.FileInfo) bool {
+	if filepath.Ext(path) != "" || !isRegularFile(path, info) {
+		return false
+	}
+	fileThrottle.Acquire()
+	defer fileThrottle.Release()
+	return sloc.SniffLanguage(path) != nil
+}
+
+// validateTargets checks up front that every path argument exists, so a typo
+// doesn't surface halfway through a run
+func validateTargets(targets []string) error {
+	for _, target := range targets {
+		if isBucketURL(target) || target == stdinTarget {
+			continue
+		}
+		info, err := os.Stat(target)
+		if err != nil {
+			if pe, ok := err.(*os.PathError); ok {
+				err = pe.Err
+			}
+			return fmt.Errorf("invalid path argument %q: %v", target, err)
+		}
+		if info.Mode().IsRegular() && !isSourceFile(target) && !isScript(target, info) && !isArchive(target) {
+			log.Warningf("%s is not a supported source file and will be ignored", target)
+		}
+	}
+	return nil
+}
+
+// isRegularFile reports whether a walked path is a regular file, looking
+// through symlinks. FIFOs, devices and sockets would hang or fail on read.
+func isRegularFile(path string, info os.FileInfo) bool {
+	mode := info.Mode()
+	if mode&os.ModeSymlink != 0 {
+		target, err := os.Stat(path)
+		if err != nil {
+			return false
+		}
+		mode = target.Mode()
+	}
+	return mode.IsRegular()
 }
 
 func isDirectory(path string) (bool, error) {
@@ -39,100 +294,563 @@ func isDirectory(path string) (bool, error) {
 	return fileInfo.IsDir(), err
 }
 
-func getFileStats(filename string) fileLines {
+// errFileChanged means a file was modified while it was being counted
+var errFileChanged = errors.New("file changed while it was being counted")
+
+// files larger than this aren't counted (0 for no limit)
+var maxFileSize = byteSize(10 << 20)
+
+// errFileTooLarge means a file is over --max-file-size
+var errFileTooLarge = errors.New("larger than --max-file-size")
+
+// isTreeChange reports whether a failure is down to the tree changing under
+// the scan rather than the file being unreadable
+func isTreeChange(err error) bool {
+	return errors.Is(err, errFileChanged) || errors.Is(err, fs.ErrNotExist)
+}
+
+// getFileStats counts a file, retrying once if it's modified or removed
+// mid-count as happens when scanning a live checkout
+func getFileStats(filename string) (fileLines, error) {
+	res, err := countFile(filename)
+	if isTreeChange(err) {
+		log.Debugf("%s: %v, retrying", filename, err)
+		res, err = countFile(filename)
+	}
+	return res, err
+}
+
+func countFile(filename string) (fileLines, error) {
+	fileThrottle.Acquire()
+	defer fileThrottle.Release()
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatal(err)
+		return fileLines{filename: filename}, err
 	}
 	defer file.Close()
 
+	before, err := file.Stat()
+	if err != nil {
+		return fileLines{filename: filename}, err
+	}
+	if maxFileSize > 0 && before.Size() > int64(maxFileSize) {
+		return fileLines{filename: filename}, fmt.Errorf("%w (%s)", errFileTooLarge, formatSize(before.Size()))
+	}
+	var res fileLines
+	src := fileThrottle.Reader(file)
+	if runTimings != nil {
+		res, err = runTimings.timeCount(src, func(r io.Reader) (fileLines, error) { return countLines(filename, r) })
+	} else {
+		res, err = countLines(filename, src)
+	}
+	if err != nil {
+		return res, err
+	}
+
+	// compare against the path, not the open file, to catch replacement too
+	after, err := os.Stat(filename)
+	if err != nil {
+		return res, err
+	}
+	if after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) || !os.SameFile(before, after) {
+		return res, errFileChanged
+	}
+	return res, nil
+}
+
+func countLines(filename string, r io.Reader) (fileLines, error) {
+	return countLinesAs(filename, nil, r)
+}
+
+// countOptions are the library options the flags set
+func countOptions() sloc.Options {
+	return sloc.Options{
+		Mixed:         mixedMode,
+		Directives:    directiveMode,
+		BlankComments: blankCommentMode,
+		GoMode:        goMode,
+		MaxLineBytes:  maxLineBytes,
+		Throttle:      fileThrottle,
+	}
+}
+
+// countLinesAs counts r as lang, or as the language its name or shebang
+// suggests if lang is nil
+func countLinesAs(filename string, lang *sloc.Language, r io.Reader) (fileLines, error) {
+	start := time.Now()
+	var hash hash.Hash
+	if findDuplicates {
+		hash = sha256.New()
+		r = io.TeeReader(r, hash)
+	}
 	res := fileLines{filename: filename}
+	opts := countOptions()
+	if measureComplexity || countDecls || countLogical {
+		opts.Source = func(src []byte) {
+			mode := parser.SkipObjectResolution
+			if countDecls {
+				// for the doc comments
+				mode |= parser.ParseComments
+			}
+			fset := token.NewFileSet()
+			file, err := parser.ParseFile(fset, filename, src, mode)
+			if err != nil {
+				log.Debugf("%s: doesn't parse as Go, declarations not measured: %v", filename, err)
+				return
+			}
+			if measureComplexity {
+				res.funcs = goComplexity(fset, file)
+			}
+			if countDecls {
+				res.decls = countGoDecls(file)
+			}
+			if countLogical {
+				res.logicalLines = goLogicalLines(file)
+			}
+		}
+	}
 
-	// read file line by line
-	inComment := false
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if err = scanner.Err(); err != nil {
-			log.Fatal(err)
-		}
-
-		line = strings.TrimSpace(line)
-		if len(line) == 0 {
-			res.whitespaceLines++
-		} else if strings.HasPrefix(line, "//") {
-			res.commentLines++
-		} else if strings.HasPrefix(line, "/*") {
-			if !strings.HasSuffix(line, "*/") {
-				inComment = true
-			}
-			res.commentLines++
-		} else if inComment {
-			if strings.HasSuffix(line, "*/") {
-				inComment = false
-			}
-			res.commentLines++
-		} else {
-			res.codeLines++
+	var license licenseHeader
+	opts.Line = func(lang *sloc.Language, n int, kind sloc.LineKind, raw []byte) {
+		if styleReport {
+			res.style.add(raw)
+		}
+		if countUnique && kind != sloc.BlankLine {
+			res.unique.add(raw)
+		}
+		if measureLineLengths && kind != sloc.BlankLine {
+			length := utf8.RuneCount(bytes.TrimRight(raw, " \t"))
+			res.lengths.join(lineLengths{length: 1})
+			if maxLineLength > 0 && length > maxLineLength {
+				res.overLong = append(res.overLong, longLine{Line: n, Length: length})
+			}
+		}
+		if tagPattern != nil && kind&sloc.CommentLine != 0 {
+			addTags(&res, lang, kind, n, raw)
+		}
+		directive := kind == sloc.CommentLine && lang.IsDirective(raw)
+		if directive && showEmbeds && lang == sloc.Go && bytes.HasPrefix(bytes.TrimSpace(raw), []byte("//go:embed ")) {
+			patterns, err := embedPatterns(string(raw))
+			if err != nil {
+				log.Warningf("%s: %v", displayPath(filename), err)
+			}
+			res.embeds = append(res.embeds, patterns...)
+		}
+		if countLicenses && !directive {
+			license.add(kind, raw)
 		}
 	}
 
-	return res
+	stats, err := sloc.Count(filename, lang, r, &opts)
+	if err != nil {
+		return fileLines{filename: filename}, err
+	}
+	fromStats(&res, stats)
+	if res.encoding != "" {
+		log.Debugf("%s: transcoded from %s", filename, res.encoding)
+	}
+	if hash != nil {
+		res.hash = hex.EncodeToString(hash.Sum(nil))
+	}
+	if stats.UnterminatedIgnore {
+		log.Warningf("%s: sloc:ignore-begin without sloc:ignore-end, the rest of the file wasn't counted", displayPath(filename))
+	}
+	license.end()
+	res.commentLines -= license.total
+	res.licenseLines = license.total
+
+	if res.longLines > 0 {
+		log.Warningf("%s: %d lines longer than %d bytes were truncated for classification", filename, res.longLines, maxLineBytes)
+	}
+	recordFileMetrics(res, time.Since(start))
+	return res, nil
+}
+
+// fromStats copies the library's counts into f
+func fromStats(f *fileLines, stats sloc.FileStats) {
+	f.filename, f.language = stats.Filename, stats.Language
+	f.codeLines, f.commentLines, f.whitespaceLines = stats.Code, stats.Comment, stats.Blank
+	f.mixedLines, f.directiveLines, f.longLines = stats.Mixed, stats.Directives, stats.LongLines
+	f.bom, f.encoding, f.lineEnding = stats.BOM, stats.Encoding, stats.LineEnding
+	f.generated, f.cgoLines = stats.Generated, stats.Cgo
+	if stats.NoFinalNewline {
+		f.style.NoFinalNewline = 1
+	}
 }
 
-func genFileProcessor(out chan<- fileLines) func(string, os.FileInfo, error) error {
+// with --include-testdata, testdata directories are counted like any other
+var includeTestdata bool
+
+// with --all, no directories are skipped
+var includeAll bool
+
+// with --follow-symlinks, symlinked directories are walked too
+var followSymlinks bool
+
+// with --list-only, the files that would be counted are printed instead
+var listOnly bool
+
+// with --count-hardlinks, each hard link to a file is counted as a file
+var countHardlinks bool
+
+// duplicatePaths counts the files and directories a run skipped as already
+// counted, reached again by a hard link or an overlapping argument
+var duplicatePaths atomic.Int64
+
+// with --module-only, directories holding their own go.mod are left out,
+// so only the target's module is counted
+var moduleOnly bool
+
+// with --skip-hidden, dot-prefixed files and directories aren't walked
+var skipHidden bool
+
+// with --max-depth, files nested deeper than this below a target aren't
+// counted: 1 counts only the files directly in it
+var maxDepth int
+
+// excludeDir reports whether a directory found below a target is left out
+func excludeDir(name string) bool {
+	if includeAll {
+		return false
+	}
+	return name == "testdata" && !includeTestdata || slices.Contains(sloc.DependencyDirs, name)
+}
+
+// walkTree walks root like filepath.Walk, skipping excluded directories
+// and ignored paths below it; root itself is always walked, since it was
+// asked for
+func walkTree(root string, fn filepath.WalkFunc) error {
+	if moduleOnly && moduleFor(root).path == "" {
+		log.Warningf("%s is not in a Go module, counting all of it", root)
+	}
+	return newTreeFilter(root).walk(root, fn)
+}
+
+// treeFilter is what a walk from root leaves out, kept so paths that turn up
+// after the walk, as watch hears of them, can be judged the same way
+type treeFilter struct {
+	root    string
+	ignores *ignoreRules
+}
+
+func newTreeFilter(root string) *treeFilter {
+	return &treeFilter{root: root, ignores: newIgnoreRules(root)}
+}
+
+// contains reports whether path is the filter's root or below it
+func (this *treeFilter) contains(path string) bool {
+	rel, err := filepath.Rel(this.root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// skips reports whether a walk from the root leaves out path: a directory
+// it doesn't enter or a file it doesn't count. The ignore files of the
+// directories above path must have been loaded, as walking to it does.
+func (this *treeFilter) skips(path string, info os.FileInfo) bool {
+	if path == this.root {
+		return false
+	}
+	if info.IsDir() && excludeDir(info.Name()) {
+		log.Debug("skipping excluded directory", path)
+		return true
+	}
+	if skipHidden && strings.HasPrefix(info.Name(), ".") {
+		log.Debug("skipping hidden", path)
+		return true
+	}
+	rel, _ := filepath.Rel(this.root, path)
+	if depth := strings.Count(rel, string(filepath.Separator)) + 1; maxDepth > 0 && depth >= maxDepth && info.IsDir() {
+		log.Debug("skipping below --max-depth", path)
+		return true
+	}
+	if moduleOnly && info.IsDir() {
+		if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+			log.Debug("skipping nested module", path)
+			return true
+		}
+	}
+	excluded, _ := excludeFilter.match(filepath.ToSlash(rel), info.IsDir())
+	if excluded || this.ignores.ignored(path, info.IsDir()) {
+		log.Debug("skipping ignored", path)
+		return true
+	}
+	if included, _ := includeFilter.match(filepath.ToSlash(rel), false); !info.IsDir() && len(includeFilter) > 0 && !included {
+		log.Debug("skipping not included", path)
+		return true
+	}
+	return false
+}
+
+// walk walks dir, the root or a directory below it, skipping what a walk
+// from the root would
+func (this *treeFilter) walk(dir string, fn filepath.WalkFunc) error {
+	// with --follow-symlinks, everything reached so far, so links back up
+	// the tree or to files already counted are skipped
+	visited := map[fileID]bool{}
+
+	var walk filepath.WalkFunc
+	walk = func(path string, info os.FileInfo, err error) error {
+		if err == nil && followSymlinks && info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(path); err == nil && target.IsDir() {
+				// walk the target under the link's name; the trailing
+				// separator makes Walk follow it
+				return filepath.Walk(path+string(filepath.Separator), walk)
+			} else if err == nil {
+				info = target
+			}
+		}
+
+		if err == nil && this.skips(path, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if err == nil && followSymlinks {
+			if id, ok := getFileID(info); ok {
+				if visited[id] {
+					log.Debug("skipping already visited", path)
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				visited[id] = true
+			}
+		}
+		if err == nil && info.IsDir() {
+			this.ignores.load(path)
+		}
+		return fn(path, info, err)
+	}
+	return filepath.Walk(dir, walk)
+}
+
+// files are counted by this many workers while the walk carries on
+var jobs = runtime.NumCPU()
+
+// fileThrottle is set by --max-open-files and --max-read-rate
+var fileThrottle *sloc.Throttle
+
+// genFileProcessor returns a walk function that hands the source files it
+// finds to a pool of counting workers, and a function that waits for them
+// once the walk is done
+func genFileProcessor(ctx context.Context, out collector) (filepath.WalkFunc, func()) {
+	// bind mounts, junctions and overlapping arguments can reach the same
+	// directory more than once
+	visited := map[fileID]bool{}
+	countedPaths := map[string]bool{}
+
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < max(jobs, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				// once cancelled, drop what's queued rather than fail it
+				if ctx.Err() != nil {
+					continue
+				}
+				count := getFileStats
+				if statsCache != nil {
+					count = statsCache.stats
+				}
+				if runCheckpoint != nil {
+					out.send(runCheckpoint.stats(path, count))
+				} else {
+					out.send(count(path))
+				}
+			}
+		}()
+	}
+	// the count span runs from the first file handed to the workers until
+	// they're done with the last
+	counting, endCount := false, func() {}
+	wait := func() {
+		close(paths)
+		wg.Wait()
+		endCount()
+	}
+
 	return func(path string, info os.FileInfo, err error) error {
-		// ignore non-Golang files
-		if !strings.HasSuffix(path, ".go") {
+		// stop discovery once the run is cancelled
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// unreadable directories and files are reported and skipped; the
+		// rest of the walk carries on
+		if err != nil {
+			if info != nil && !info.IsDir() && !isSourceFile(path) {
+				return nil
+			}
+			out.send(fileLines{filename: path}, err)
+			return nil
+		}
+
+		if info.IsDir() {
+			if id, ok := getFileID(info); ok {
+				if visited[id] {
+					log.Debug("skipping already visited directory", path)
+					duplicatePaths.Add(1)
+					return filepath.SkipDir
+				}
+				visited[id] = true
+			}
+		}
+
+		// ignore files in languages that can't be counted
+		if info.IsDir() || !isSourceFile(path) && !isScript(path, info) {
 			log.Debug("ignoring", path)
 			return nil
 		}
 
-		if err != nil {
-			log.Error(err)
+		if !isRegularFile(path, info) {
+			log.Debugf("skipping non-regular file %s (%v)", path, info.Mode().Type())
+			return nil
+		}
+
+		// hard links, and arguments naming a file twice or a file and its
+		// directory, reach the same file again; with --count-hardlinks only
+		// the same path is
+		seen := false
+		if id, ok := getFileID(info); ok && !countHardlinks {
+			seen, visited[id] = visited[id], true
+		} else if abs, err := filepath.Abs(path); err == nil {
+			seen, countedPaths[abs] = countedPaths[abs], true
+		}
+		if seen {
+			log.Debug("skipping already counted", path)
+			duplicatePaths.Add(1)
 			return nil
 		}
 
+		if listOnly {
+			fmt.Fprintln(reportOutput, displayPath(path))
+			return nil
+		}
 		log.Debug("fileProcessor", path)
-		out <- getFileStats(path)
+		if !counting {
+			_, span := tracer.Start(ctx, "count")
+			counting, endCount = true, func() { span.End() }
+		}
+		paths <- path
 		return nil
-	}
+	}, wait
+}
+
+func main() {
+	os.Exit(exitCode(run()))
+}
+
+// exit codes, so scripts can tell the outcomes apart
+const (
+	exitOK          = 0
+	exitFailedCheck = 1   // a --fail-if, budget, baseline or density check failed
+	exitPartial     = 2   // paths couldn't be counted, or an error stopped the run
+	exitUsage       = 3   // the flags, arguments or configuration are invalid
+	exitInterrupted = 130 // Ctrl-C, 128 plus SIGINT's 2 as shells report it
+	exitTerminated  = 143 // SIGTERM, 128 plus its 15
+)
+
+// usageError is a mistake in how sloc was run rather than a failure to count
+type usageError struct {
+	error
 }
 
-func processResults(results <-chan fileLines, done chan<- bool) {
-	total := fileLines{filename: "TOTAL"}
-	var data [][]string
+func usageErrorf(format string, args ...any) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+// exitStatus ends the run with an exit code for an outcome that's been
+// reported already, such as a failed check
+type exitStatus int
 
-	for res := range results {
-		log.Infof("%+v\n", res)
+func (this exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(this))
+}
 
-		total.join(res)
-		data = append(data, []string{
-			res.filename,
-			strconv.Itoa(res.whitespaceLines),
-			strconv.Itoa(res.commentLines),
-			strconv.Itoa(res.codeLines),
-		})
+// exitCode is the exit code for what stopped the run, logging any error
+// that hasn't been reported yet: exitUsage for a usageError and
+// exitPartial for the rest
+func exitCode(err error) int {
+	var status exitStatus
+	var sig interrupted
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &status):
+		return int(status)
+	case errors.As(err, &sig):
+		return sig.exitCode()
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
 	}
+	log.Critical(err)
+	if errors.As(err, new(usageError)) {
+		return exitUsage
+	}
+	return exitPartial
+}
 
-	// print table
-	fmt.Println()
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"FILENAME", "White Space", "Comment", "Code"})
-	table.SetFooter([]string{
-		total.filename,
-		strconv.Itoa(total.whitespaceLines),
-		strconv.Itoa(total.commentLines),
-		strconv.Itoa(total.codeLines),
-	})
-	table.SetBorder(false)
-	table.AppendBulk(data)
-	table.Render()
+// parseFlags parses args into flags, stopping the run with exitUsage if
+// they're invalid, as the flag package has already said why, or exitOK
+// once it's printed the help asked for
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitStatus(exitOK)
+	}
+	if err != nil {
+		return exitStatus(exitUsage)
+	}
+	return nil
+}
 
-	done <- true
+// checkTableReport turns on what the report flags imply and refuses those
+// that only follow a table when the report isn't one
+func checkTableReport(table bool) error {
+	showTags = tagPattern != nil && table
+	if listTags && !showTags {
+		return usageErrorf("--todo-list is only supported with --format table")
+	}
+	if maxLineLength > 0 {
+		measureLineLengths = true
+	}
+	if complexityOver > 0 {
+		measureComplexity = true
+	}
+	if languageBar {
+		languageSummary = true
+	}
+	for _, check := range []struct {
+		on   bool
+		name string
+	}{
+		{findDuplicates, "--duplicates"},
+		{detectDuplication, "--duplication"},
+		{showCocomo, "--cocomo"},
+		{showHistogram, "--histogram"},
+		{maxLineLength > 0, "--max-line-length"},
+		{complexityOver > 0, "--complexity-over"},
+		{showEmbeds, "--embeds"},
+		{languageSummary, "--language-summary"},
+		{styleReport, "--style-report"},
+	} {
+		if check.on && !table {
+			return usageErrorf("%s is only supported with --format table", check.name)
+		}
+	}
+	return nil
 }
 
-func main() {
+// run parses the global flags and sets up what every subcommand shares,
+// the logging, output, languages and sinks, then runs the subcommand
+func run() error {
+	// until the flags say otherwise
+	setupLogging(logging.WARNING)
+
 	loggingLevels := map[string]logging.Level{
 		"CRITICAL": logging.CRITICAL,
 		"DEBUG":    logging.DEBUG,
@@ -142,45 +860,674 @@ func main() {
 		"WARNING":  logging.WARNING,
 	}
 
+	var levelNames, formatNames []string
+	for name := range loggingLevels {
+		levelNames = append(levelNames, name)
+	}
+	for name := range renderers {
+		formatNames = append(formatNames, name)
+	}
+
 	// parse flags
-	loggingFlag := flag.String("loglevel", "INFO", "log level")
-	flag.Parse()
-	files := flag.Args()
-	loggingLevel, ok := loggingLevels[*loggingFlag]
-	if !ok {
-		log.Fatalf("Invalid log level: found %v", loggingLevel)
+	loggingFlag := newEnumFlag("WARNING", levelNames...)
+	flag.Var(loggingFlag, "loglevel", "the least severe diagnostics logged to stderr ("+loggingFlag.choices()+")")
+	verbose := flag.Bool("v", false, "log what's being done to stderr too, the same as --loglevel INFO")
+	veryVerbose := flag.Bool("vv", false, "log debugging detail to stderr too, the same as --loglevel DEBUG")
+	flag.BoolVar(&quiet, "quiet", false, "only log errors to stderr, without the progress line or the notes on skipped files")
+	languagesFile := flag.String("languages", "", "YAML file of extra language definitions (default: sloc/languages.yaml in the user config directory, if present)")
+	var forceLang stringList
+	flag.Var(&forceLang, "force-lang", "count extensions as another language, e.g. inc:cpp,tmpl:go (repeatable)")
+	cacheDir := flag.String("cache", "", "keep counts in this directory between runs, so unchanged files aren't read again, e.g. ~/.cache/sloc")
+	checkpointPath := flag.String("checkpoint", "", "save the counts so far to this file as the run goes, so an interrupted run can carry on with --resume; removed once a run completes")
+	checkpointEvery := flag.Duration("checkpoint-every", 30*time.Second, "how often --checkpoint is saved")
+	resume := flag.Bool("resume", false, "carry on from the counts an interrupted run left in --checkpoint")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile of the run to this file, for go tool pprof")
+	memProfile := flag.String("memprofile", "", "write a heap profile to this file once the run is done, for go tool pprof")
+	showTimings := flag.Bool("timings", false, "print how long walking, reading, counting and writing the report took to stderr")
+	noProgress := flag.Bool("no-progress", false, "don't show a progress line on stderr during long scans")
+	flag.IntVar(&jobs, "j", jobs, "count this many files at once")
+	maxOpenFiles := flag.Int("max-open-files", 0, "hold at most this many files open at once, however many -j workers are counting (0 for no limit)")
+	var maxReadRate byteSize
+	flag.Var(&maxReadRate, "max-read-rate", "read files at most this fast, per second, e.g. 20MB, to spare a network filesystem (0 for no limit)")
+	flag.Var(&maxFileSize, "max-file-size", "skip files larger than this, e.g. 512K or 50MB (0 for no limit)")
+	flag.IntVar(&maxLineBytes, "max-line-bytes", maxLineBytes, "classify at most this many bytes of each line (0 for no limit)")
+	flag.BoolVar(&includeGenerated, "include-generated", false, "count generated files (protobuf, mocks, \"Code generated ... DO NOT EDIT.\") instead of summarizing them separately")
+	flag.BoolVar(&countLicenses, "license-headers", false, "count license headers at the top of files in a column of their own rather than as comments")
+	countTags := flag.Bool("todo", false, "count TODO, FIXME, HACK and XXX markers in comments, per file and in total")
+	var tagNames stringList
+	flag.Var(&tagNames, "todo-tags", "the markers --todo counts, comma-separated (default "+strings.Join(defaultTags, ",")+")")
+	flag.BoolVar(&listTags, "todo-list", false, "with --todo, list where each marker is after the table")
+	flag.BoolVar(&measureComplexity, "complexity", false, "add columns for the max and average cyclomatic complexity of Go functions")
+	flag.BoolVar(&measureLineLengths, "line-lengths", false, "add columns for the longest, average and 95th percentile length of non-blank lines")
+	flag.IntVar(&maxLineLength, "max-line-length", 0, "list the lines longer than this many characters after the table (implies --line-lengths)")
+	flag.BoolVar(&styleReport, "style-report", false, "list each file's indentation (tabs, spaces or mixed), lines with trailing whitespace and a missing final newline after the table")
+	flag.BoolVar(&findDuplicates, "duplicates", false, "list the groups of byte-identical files, and the lines their copies waste, after the table")
+	flag.BoolVar(&detectDuplication, "duplication", false, "find code blocks repeated across files and report the share of code they make up")
+	flag.IntVar(&duplicationMin, "duplication-min", duplicationMin, "the fewest tokens a block must have to count as duplicated")
+	flag.BoolVar(&showCocomo, "cocomo", false, "follow the table with COCOMO estimates of the effort, schedule and cost of the code")
+	cocomoFlag := newEnumFlag(cocomoMode, "organic", "semi-detached", "embedded")
+	flag.Var(cocomoFlag, "cocomo-model", "the COCOMO project class, "+cocomoFlag.choices())
+	flag.Float64Var(&cocomoSalary, "cocomo-salary", cocomoSalary, "the yearly salary --cocomo costs developers at")
+	flag.Float64Var(&cocomoOverhead, "cocomo-overhead", cocomoOverhead, "what --cocomo multiplies salaries by for overhead")
+	flag.BoolVar(&showHistogram, "histogram", false, "show the distribution of files by code lines, per language, after the table")
+	flag.BoolVar(&languageSummary, "language-summary", false, "follow the table with each language's share of the code on one line, e.g. Go 82.4% · Shell 10.1%")
+	flag.BoolVar(&languageBar, "language-bar", false, "with --language-summary, draw the shares as a bar above it (implies --language-summary)")
+	flag.BoolVar(&countUnique, "uloc", false, "add a column of distinct non-blank lines, per file and, in the total, across all files")
+	flag.BoolVar(&countLogical, "logical", false, "add a column of logical lines, the statements and declarations of Go files")
+	flag.BoolVar(&countDecls, "decls", false, "add columns counting Go functions, methods, types and exported names, and the functions and methods with doc comments")
+	flag.IntVar(&complexityOver, "complexity-over", 0, "list the Go functions more complex than this after the table (implies --complexity)")
+	flag.BoolVar(&showEmbeds, "embeds", false, "list the files embedded with //go:embed, with their sizes and lines, after the table")
+	var include, exclude stringList
+	flag.Var(&include, "include", "only count files matching this gitignore-style pattern relative to the target, e.g. 'cmd/**' or '*.go' (repeatable)")
+	flag.Var(&exclude, "exclude", "skip files and directories matching this gitignore-style pattern relative to the target (repeatable)")
+	flag.BoolVar(&respectGitignore, "respect-gitignore", true, "skip files and directories ignored by .gitignore files in a git work tree")
+	flag.IntVar(&maxDepth, "max-depth", 0, "only count files at most this many levels below each target, 1 for the files directly in it (0 for no limit)")
+	flag.BoolVar(&gitTracked, "git-tracked", false, "count the files git tracks, from git ls-files, instead of walking the targets")
+	flag.BoolVar(&byAuthor, "by-author", false, "report a row per author, attributing each line to whoever git blame says last changed it")
+	flag.BoolVar(&byAge, "by-age", false, "report how much code per directory git blame says last changed under 3 months, 3-12 months, 1-3 years and over 3 years ago")
+	flag.BoolVar(&gitChanged, "changed", false, "only count files changed in the working tree or index since HEAD, and new untracked ones")
+	flag.BoolVar(&gitStaged, "staged", false, "only count files with changes staged in the index")
+	fileList := flag.String("filelist", "", "count exactly the files listed in this manifest, one per line, without walking (- for stdin)")
+	flag.BoolVar(&stdinContent, "stdin-content", false, "with - as a target, count stdin itself rather than read paths from it")
+	flag.StringVar(&stdinLang, "lang", "", "the language of --stdin-content (default: from its shebang, else Go)")
+	flag.BoolVar(&moduleOnly, "module-only", false, "only count the Go module each target is in, leaving out nested modules")
+	flag.BoolVar(&listOnly, "list-only", false, "print the files that would be counted, after the include, exclude and ignore rules, without reading them")
+	flag.BoolVar(&countHardlinks, "count-hardlinks", false, "count each hard link to a file, rather than the file once")
+	flag.BoolVar(&followSymlinks, "follow-symlinks", false, "walk symlinked directories, counting each file once however many links reach it")
+	flag.BoolVar(&skipHidden, "skip-hidden", false, "skip dot-prefixed files and directories such as .idea and .cache")
+	flag.BoolVar(&includeAll, "all", false, "count every directory, including vendor, node_modules, .git and testdata")
+	flag.BoolVar(&includeTestdata, "include-testdata", false, "count files in testdata directories, which are skipped by default")
+	flag.BoolVar(&showLineEndings, "line-endings", false, "add a column with each file's dominant line ending to the table")
+	formatFlag := newEnumFlag("table", formatNames...)
+	flag.Var(formatFlag, "format", "output format ("+formatFlag.choices()+")")
+	oneline := flag.Bool("oneline", false, "print a single line of totals, the same as --format oneline")
+	copyPaths := map[string]*string{}
+	for _, name := range formatNames {
+		// --oneline is taken, and only fits a terminal anyway
+		if name != "oneline" {
+			copyPaths[name] = flag.String(name, "", "also write the report as "+name+" to this file, alongside the --format one")
+		}
+	}
+	lineTemplate := flag.String("template", "", "render each file and the total through this Go template, e.g. '{{.Filename}} {{.Code}}'")
+	reportTemplate := flag.String("template-file", "", "render the whole report through this Go template file, given .Files and .Total")
+	blankCommentsFlag := newEnumFlag(blankCommentMode, "blank", "comment")
+	flag.Var(blankCommentsFlag, "blank-in-comments", "count blank lines inside block comments as blank or as comments ("+blankCommentsFlag.choices()+")")
+	directivesFlag := newEnumFlag(directiveMode, "comment", "code", "separate")
+	flag.Var(directivesFlag, "directives", "count tool directives such as //go:build and //nolint as comments, as code, or in a separate column ("+directivesFlag.choices()+")")
+	mixedFlag := newEnumFlag(mixedMode, "code", "comment", "both", "separate")
+	flag.Var(mixedFlag, "mixed", "count lines with both code and a comment as code, comment, both, or in a separate mixed column ("+mixedFlag.choices()+")")
+	sortFlag := newEnumFlag(sortBy, slices.Collect(maps.Keys(sortKeys))...)
+	flag.Var(sortFlag, "sort", "order the report's rows by "+sortFlag.choices())
+	flag.BoolVar(&sortDesc, "desc", false, "sort the report's rows in descending order, largest first")
+	flag.BoolVar(&summaryOnly, "summary", false, "only report the totals, in any format")
+	flag.BoolVar(&summaryOnly, "total-only", false, "the same as --summary")
+	columnsFlag := flag.String("columns", "", "comma-separated columns for the table, from "+strings.Join(tableColumnKeys(), ", ")+" (default: the line counts plus those other options add)")
+	colorFlag := newEnumFlag(colorMode, "auto", "always", "never")
+	flag.Var(colorFlag, "color", "color the table's totals and the cells over a file budget, and the log levels; auto colors a terminal unless NO_COLOR is set ("+colorFlag.choices()+")")
+	flag.IntVar(&streamTableAfter, "stream-table-after", streamTableAfter, "once a table of files passes this many rows, write the rest as they're counted, unsorted, to bound memory (0 to always sort)")
+	flag.Var(&humanNumbers, "human", "write the table's counts with thousands separators, or with --human=compact abbreviated as in 12.4k")
+	flag.BoolVar(&showDensity, "density", false, "add columns for comment density, comments/(code+comments), and each row's share of the code")
+	flag.IntVar(&topN, "top", 0, "only report the N files with the most code lines (or by --sort), plus the grand total")
+	goModeFlag := newEnumFlag(goMode, "scanner", "heuristic")
+	flag.Var(goModeFlag, "go-mode", "classify Go with the Go tokenizer, falling back to the heuristic for files it can't scan, or always with the faster heuristic ("+goModeFlag.choices()+")")
+	groupFlag := &groupByFlag{enumFlag: newEnumFlag("file", append(slices.Collect(maps.Keys(groupKeys)), "file")...)}
+	flag.Var(groupFlag, "group-by", "roll the report up by "+groupFlag.choices()+", with dir:N cutting directories to their first N levels")
+	var outputPath string
+	flag.StringVar(&outputPath, "output", "", "write the report to this file instead of stdout")
+	flag.StringVar(&outputPath, "o", "", "shorthand for --output")
+	flag.StringVar(&backstageEntity, "backstage-entity", "", "entity ref for --format backstage (default: component:default/<working directory>)")
+	strict := flag.Bool("strict", false, "stop at the first path that can't be counted, rather than skip it and report it at the end")
+	flag.Float64Var(&minDensity, "min-comment-density", 0, "warn of files, or packages with --density-by package, whose comments are under this percentage of code+comments")
+	densityFlag := newEnumFlag("file", "file", "package")
+	flag.Var(densityFlag, "density-by", "check --min-comment-density per "+densityFlag.choices())
+	failDensity := flag.Bool("fail-density", false, "exit non-zero if --min-comment-density warns")
+	var thresholds thresholdList
+	flag.Var(&thresholds, "fail-if", "exit non-zero if a file or the total breaks this budget, e.g. 'file.code>800' or 'total.files>=2000' (repeatable)")
+	maxTotalCode := flag.Int("max-total-code", 0, "exit non-zero if the total code lines exceed this, the same as --fail-if 'total.code>N'")
+	maxFileCode := flag.Int("max-file-code", 0, "exit non-zero if a file's code lines exceed this, the same as --fail-if 'file.code>N'")
+	junitPath := flag.String("junit", "", "also write the results to this file as JUnit XML")
+	githubCheck := flag.Bool("github-check", false, "create a GitHub check run with the results (configured via GITHUB_* environment variables)")
+	var publishTargets stringList
+	flag.Var(&publishTargets, "publish", "publish results to nats://host/subject or kafka://brokers/topic (repeatable)")
+	publishFiles := flag.Bool("publish-files", false, "also publish one message per file")
+	influxURL := flag.String("influx-url", "", "write per-run metrics to this InfluxDB write endpoint (token from INFLUX_TOKEN)")
+	pushgateway := flag.String("pushgateway", "", "push per-directory metrics to this Prometheus Pushgateway")
+	badgePath := flag.String("badge", "", "also write an SVG badge of the code total to this file")
+	badgeColor := flag.String("badge-color", "blue", "color of the --badge value, a shields.io name or #rgb")
+	postURL := flag.String("post-url", "", "post the JSON report to this URL after each run")
+	postAuth := flag.String("post-auth", "", "Authorization header for --post-url, e.g. \"Bearer <token>\" (default: $SLOC_POST_AUTH)")
+	postRetries := flag.Int("post-retries", 3, "times to retry a failed --post-url request")
+	sqlitePath := flag.String("db", "", "append each run and its per-file counts to this SQLite database")
+	postgresDSN := flag.String("postgres-dsn", "", "append per-run metrics to a Postgres/Timescale database")
+	postgresTable := flag.String("postgres-table", "sloc_runs", "table for --postgres-dsn")
+	otelEndpoint := flag.String("otel-endpoint", "", "export traces and metrics to this OTLP/HTTP endpoint")
+	bucketWorkers := flag.Int("bucket-workers", 8, "concurrent downloads when counting s3:// or gs:// targets")
+	configPath := flag.String("config", "", "read flag defaults from this file (default: "+projectConfigFile+" at the root of the work tree)")
+	noConfig := flag.Bool("no-config", false, "don't read "+projectConfigFile)
+	profile := flag.String("profile", "", "use this named profile's settings from the config file")
+	flag.Usage = usage
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := parseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		return err
+	}
+	cmd, files, err := parseSubcommand(flag.Args())
+	if err != nil {
+		return err
+	}
+	if cmd.name == "completion" {
+		return runCompletion(files)
+	}
+	if *configPath == "" && !*noConfig {
+		*configPath = findProjectConfig()
+	}
+	if *configPath != "" {
+		if err := loadProjectConfig(*configPath, *profile); err != nil {
+			return usageError{err}
+		}
+	} else if *profile != "" {
+		return usageErrorf("--profile %s needs a %s or --config", *profile, projectConfigFile)
+	}
+	colorMode = colorFlag.value
+	loggingLevel := loggingLevels[loggingFlag.value]
+	switch {
+	case quiet:
+		loggingLevel = logging.ERROR
+	case *veryVerbose:
+		loggingLevel = logging.DEBUG
+	case *verbose:
+		loggingLevel = logging.INFO
+	}
+	setupLogging(loggingLevel)
+	if *maxOpenFiles > 0 || maxReadRate > 0 {
+		fileThrottle = sloc.NewThrottle(*maxOpenFiles, int64(maxReadRate))
+	}
+	if *cpuProfile != "" || *memProfile != "" {
+		stopProfiles, err := startProfiles(*cpuProfile, *memProfile)
+		if err != nil {
+			return err
+		}
+		defer stopProfiles()
+	}
+	if *showTimings {
+		runTimings = &timings{start: time.Now()}
+	}
+	if *oneline {
+		formatFlag.value = "oneline"
+	}
+	groupBy = groupFlag.value
+	densityBy = densityFlag.value
+	groupDepth = groupFlag.depth
+	if byAuthor || byAge {
+		// rows are made from the files' lines by the renderer
+		groupBy = "author"
+		if byAge {
+			groupBy = "age"
+		}
+	}
+	for _, target := range files {
+		groupRoots = append(groupRoots, filepath.Clean(target))
 	}
-	fmt.Printf("loggingLevel %v\n", loggingLevel)
+	sortBy = sortFlag.value
+	if *columnsFlag != "" {
+		for _, key := range strings.Split(*columnsFlag, ",") {
+			key = strings.ToLower(strings.TrimSpace(key))
+			if !slices.Contains(tableColumnKeys(), key) {
+				msg := fmt.Sprintf("invalid --columns %q, must be from %s", key, strings.Join(tableColumnKeys(), ", "))
+				if suggestion := closestMatch(key, tableColumnKeys()); suggestion != "" {
+					msg += fmt.Sprintf("; did you mean %q?", suggestion)
+				}
+				return usageErrorf("%s", msg)
+			}
+			columns = append(columns, key)
+		}
+	}
+	if topN > 0 {
+		if sortBy == "name" {
+			sortBy = "code"
+		}
+		sortDesc = true
+	}
+	showProgress = !*noProgress && !quiet
+	includeFilter = parseIgnore([]byte(strings.Join(include, "\n")))
+	excludeFilter = parseIgnore([]byte(strings.Join(exclude, "\n")))
+	goMode = goModeFlag.value
+	mixedMode = mixedFlag.value
+	directiveMode = directivesFlag.value
+	blankCommentMode = blankCommentsFlag.value
+	render := renderers[formatFlag.value]
+	streamResult = streamers[formatFlag.value]
+	if groupBy != "file" || sortBy != "name" || sortDesc || topN > 0 || summaryOnly {
+		// groups and order are only known once everything is counted
+		streamResult = nil
+	}
+	if *lineTemplate != "" || *reportTemplate != "" {
+		var err error
+		if *reportTemplate != "" {
+			render, err = newReportTemplate(*reportTemplate)
+		} else {
+			render, err = newLineTemplate(*lineTemplate)
+		}
+		if err != nil {
+			return usageError{err}
+		}
+		streamResult = nil
+	}
+	if byAuthor {
+		render = blameRenderer(render)
+	}
+	if byAge {
+		var err error
+		if render, err = ageRenderer(formatFlag.value); err != nil {
+			return usageError{err}
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(copyPaths)) {
+		path := *copyPaths[name]
+		if path == "" {
+			continue
+		}
+		c := reportCopy{path: path, render: renderers[name]}
+		if byAuthor {
+			c.render = blameRenderer(c.render)
+		}
+		if byAge {
+			var err error
+			if c.render, err = ageRenderer(name); err != nil {
+				return usageError{err}
+			}
+		}
+		reportCopies = append(reportCopies, c)
+		// the copies need every file, none can be written as it's counted
+		streamResult = nil
+	}
+	if len(tagNames) > 0 || listTags {
+		*countTags = true
+	}
+	if *countTags {
+		tags := defaultTags
+		if len(tagNames) > 0 {
+			tags = nil
+			for _, name := range tagNames {
+				tags = append(tags, strings.Split(name, ",")...)
+			}
+		}
+		if err := setTags(tags); err != nil {
+			return usageError{err}
+		}
+	}
+	if duplicationMin < 1 {
+		return usageErrorf("--duplication-min must be at least 1")
+	}
+	if *bucketWorkers < 1 {
+		return usageErrorf("--bucket-workers must be at least 1")
+	}
+	cocomoMode = cocomoFlag.value
+	if err := checkTableReport(formatFlag.value == "table" && *lineTemplate == "" && *reportTemplate == ""); err != nil {
+		return err
+	}
+	streamTable = formatFlag.value == "table" && *lineTemplate == "" && *reportTemplate == "" &&
+		groupBy == "file" && sortBy == "name" && !sortDesc && topN == 0 && !summaryOnly && !byAuthor && !byAge &&
+		!showTags && !findDuplicates && !detectDuplication && !showHistogram && maxLineLength == 0 &&
+		complexityOver == 0 && !showEmbeds && !styleReport && !languageSummary && len(reportCopies) == 0 &&
+		!slices.ContainsFunc(tableColumnsFor(false), func(c tableColumn) bool { return c.key == "share" })
 
-	// setup logging
-	backend := logging.NewLogBackend(os.Stderr, "", 0)
-	formatter := logging.NewBackendFormatter(backend, format)
-	leveledBackend := logging.AddModuleLevel(backend)
-	leveledBackend.SetLevel(loggingLevel, "")
-	logging.SetBackend(leveledBackend, formatter)
-
-	log.Notice("notice")
-	log.Warning("warning")
-	log.Error("err")
-	log.Critical("crit")
-
-	results := make(chan fileLines)
-	done := make(chan bool)
-
-	// start results goroutine
-	go processResults(results, done)
-
-	// walk files
-	fileProcessor := genFileProcessor(results)
-	for _, file := range files {
-		log.Debug("processing", file)
-		err := filepath.Walk(file, fileProcessor)
+	if outputPath != "" {
+		out, err := os.Create(outputPath)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		reportOutput = out
+	}
+	if f, ok := reportOutput.(*os.File); ok {
+		colorTables = useColor(f)
+	}
+
+	if *languagesFile != "" {
+		if err := loadLanguages(*languagesFile); err != nil {
+			return usageError{err}
+		}
+	} else if path := defaultLanguagesFile(); path != "" {
+		if err := loadLanguages(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return usageError{err}
+		}
+	}
+
+	if configLanguages != nil {
+		if err := registerLanguages(configLanguagesPath, configLanguages); err != nil {
+			return usageError{err}
+		}
+	}
+
+	for _, spec := range forceLang {
+		if err := forceLanguages(spec); err != nil {
+			return usageError{err}
+		}
+	}
+
+	if stdinLang != "" && sloc.FindLanguage(stdinLang) == nil {
+		return usageErrorf("unknown --lang %q", stdinLang)
+	}
+
+	// after the languages, which the cache's validity depends on
+	if *cacheDir != "" {
+		var err error
+		if statsCache, err = openCache(*cacheDir); err != nil {
+			return err
+		}
+	}
+
+	// serve editor requests over stdio until the client exits
+	if cmd.name == "lsp" {
+		return serveLSP(os.Stdin, os.Stdout)
+	}
+
+	ctx := context.Background()
+	if *otelEndpoint != "" {
+		shutdown, err := setupTelemetry(ctx, *otelEndpoint)
+		if err != nil {
+			return err
+		}
+		defer shutdown(ctx)
+	}
+	ctx, span := tracer.Start(ctx, "sloc")
+	defer span.End()
+
+	var sinks []reportSink
+	if *githubCheck {
+		sinks = append(sinks, createCheckRun)
+	}
+	if *junitPath != "" {
+		sinks = append(sinks, newJUnitSink(*junitPath))
+	}
+	if *influxURL != "" {
+		sinks = append(sinks, newInfluxSink(influxConfig{URL: *influxURL, Token: os.Getenv("INFLUX_TOKEN")}))
+	}
+	if *pushgateway != "" {
+		sinks = append(sinks, newPushgatewaySink(*pushgateway))
+	}
+	if *badgePath != "" {
+		sinks = append(sinks, newBadgeSink(*badgePath, *badgeColor))
+	}
+	if *postURL != "" {
+		auth := *postAuth
+		if auth == "" {
+			auth = os.Getenv("SLOC_POST_AUTH")
+		}
+		sinks = append(sinks, newWebhookSink(*postURL, auth, *postRetries))
+	}
+	if *sqlitePath != "" {
+		sink, err := newSQLiteSink(*sqlitePath)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, sink)
+	}
+	if *postgresDSN != "" {
+		sink, err := newPostgresSink(postgresConfig{DSN: *postgresDSN, Table: *postgresTable})
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, sink)
+	}
+	for _, target := range publishTargets {
+		sink, err := newPublishSink(target, *publishFiles)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
+		sinks = append(sinks, sink)
 	}
-	close(results)
 
-	// wait for results to be processed
-	<-done
+	switch cmd.name {
+	case "daemon":
+		// scan configured repos on a schedule until killed
+		return runDaemon(files, sinks, *sqlitePath)
+	case "serve":
+		// count on request over HTTP until killed
+		return runServe(files)
+	case "watch":
+		// recount on save and redraw until interrupted
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return runWatch(ctx, files, sinks)
+	case "tui":
+		return runTUI(files)
+	case "diff":
+		return runDiff(files, render)
+	case "compare":
+		return runCompare(files, formatFlag.value)
+	case "pr-comment":
+		return runPRComment(files)
+	case "baseline":
+		return runBaseline(files)
+	case "history":
+		return runHistory(files, formatFlag.value)
+	case "patch":
+		return runPatch(files, formatFlag.value)
+	}
+	return runCount(ctx, cmd.name, files, render, sinks, countFlags{
+		fileList:        *fileList,
+		checkpointPath:  *checkpointPath,
+		checkpointEvery: *checkpointEvery,
+		resume:          *resume,
+		strict:          *strict,
+		thresholds:      thresholds,
+		maxTotalCode:    *maxTotalCode,
+		maxFileCode:     *maxFileCode,
+		failDensity:     *failDensity,
+		bucketWorkers:   *bucketWorkers,
+	})
+}
+
+// countFlags are the global flags only counting uses
+type countFlags struct {
+	fileList        string
+	checkpointPath  string
+	checkpointEvery time.Duration
+	resume, strict  bool
+	thresholds      thresholdList
+	maxTotalCode    int
+	maxFileCode     int
+	failDensity     bool
+	bucketWorkers   int
+}
+
+// runCount counts the targets, or the files in container images for image
+// and the rows of saved reports for merge, and reports them, failing with
+// exitPartial if any couldn't be counted and exitFailedCheck if a check
+// failed
+func runCount(ctx context.Context, name string, files []string, render renderer, sinks []reportSink, opts countFlags) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// combine saved reports, sent through the pipeline as if counted
+	var merged []fileLines
+	if name == "merge" {
+		if len(files) == 0 {
+			return usageErrorf("usage: sloc merge <report.json>...")
+		}
+		var err error
+		if merged, err = mergeReports(files); err != nil {
+			return err
+		}
+	}
+
+	if name == "count" && slices.Contains(files, stdinTarget) && !stdinContent {
+		var err error
+		if files, err = readTargetList(os.Stdin, files); err != nil {
+			return err
+		}
+	}
+	var listed []string
+	if opts.fileList != "" {
+		var err error
+		if listed, err = readFileList(opts.fileList); err != nil {
+			return err
+		}
+	}
+	// keyed by the targets as given, before remote ones are cloned
+	if opts.resume && opts.checkpointPath == "" {
+		return usageErrorf("--resume needs a --checkpoint to carry on from")
+	}
+	if opts.checkpointPath != "" {
+		var err error
+		if runCheckpoint, err = openCheckpoint(opts.checkpointPath, append(files, listed...), opts.resume); err != nil {
+			return err
+		}
+	}
+	for i, target := range files {
+		if name == "count" && isGitURL(target) {
+			dir, err := cloneRemote(target)
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+			files[i] = dir
+		}
+	}
+	// images and reports are read by their own producers
+	if name != "image" && name != "merge" {
+		if err := validateTargets(append(files, listed...)); err != nil {
+			return usageError{err}
+		}
+	}
+
+	thresholds := opts.thresholds
+	if opts.maxTotalCode > 0 {
+		thresholds = append(thresholds, threshold{scope: "total", metric: "code", op: ">", limit: opts.maxTotalCode})
+	}
+	if opts.maxFileCode > 0 {
+		thresholds = append(thresholds, threshold{scope: "file", metric: "code", op: ">", limit: opts.maxFileCode})
+	}
+	budgets = thresholds
+	if len(thresholds) > 0 {
+		sinks = append(sinks, newThresholdSink(thresholds))
+	}
+	for _, t := range thresholds {
+		if t.scope == "file" {
+			colorThresholds = append(colorThresholds, t)
+		}
+	}
+	if minDensity > 0 {
+		sinks = append(sinks, newDensitySink(opts.failDensity))
+	}
+
+	handleInterrupts(cancel)
+	if opts.strict {
+		ctx, failFast = context.WithCancelCause(ctx)
+		defer failFast(nil)
+	}
+
+	var stopCheckpoints func()
+	if runCheckpoint != nil {
+		stopCheckpoints = runCheckpoint.every(opts.checkpointEvery)
+	}
+
+	// discovery and counting happen together as targets are walked while
+	// the results are aggregated alongside
+	failed, err := runPipeline(ctx, strings.Join(files, " "), render, sinks, func(ctx context.Context, out collector) error {
+		_, span := tracer.Start(ctx, "discover")
+		span.SetAttributes(attribute.StringSlice("targets", files))
+		defer span.End()
+
+		if name == "merge" {
+			for _, f := range merged {
+				out.send(f, nil)
+			}
+			return nil
+		}
+
+		if name == "image" {
+			// count source files inside container images
+			for _, ref := range files {
+				log.Debug("processing image", ref)
+				if err := processImage(ctx, ref, out); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		// walk files
+		fileProcessor, wait := genFileProcessor(ctx, out)
+		defer wait()
+		if runTimings != nil {
+			// until the last path is found, the workers may count on
+			defer func(start time.Time) { runTimings.walk = time.Since(start) }(time.Now())
+		}
+		// the manifest's files are counted as they are, without a walk
+		for _, path := range listed {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			info, err := os.Stat(path)
+			if err := fileProcessor(path, info, err); err != nil {
+				return err
+			}
+		}
+		for _, file := range files {
+			log.Debug("processing", file)
+			// what's inside isn't walked, only read
+			if listOnly && (file == stdinTarget || isArchive(file) || isBucketURL(file)) {
+				fmt.Fprintln(reportOutput, displayPath(file))
+				continue
+			}
+			if file == stdinTarget {
+				out.send(countStdin(os.Stdin))
+				continue
+			}
+			if isArchive(file) {
+				if err := processArchive(ctx, file, out); err != nil {
+					return err
+				}
+				continue
+			}
+			if isBucketURL(file) {
+				if err := processBucket(ctx, file, opts.bucketWorkers, out); err != nil {
+					return err
+				}
+				continue
+			}
+			if gitSelecting() {
+				paths, err := gitSelectedFiles(file)
+				if err != nil {
+					return err
+				}
+				countGitFiles(file, paths, fileProcessor)
+				continue
+			}
+			if err := walkTree(file, fileProcessor); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if statsCache != nil {
+		if err := statsCache.save(files); err != nil {
+			log.Errorf("saving the cache: %v", err)
+		}
+	}
+	if runCheckpoint != nil {
+		stopCheckpoints()
+		// once cancelled, the workers drop what's queued without an error
+		runCheckpoint.finish(err == nil && ctx.Err() == nil)
+	}
+	if runTimings != nil {
+		runTimings.write(os.Stderr)
+	}
+	// an interrupted run exits for its signal, whatever it failed to count
+	if errors.Is(err, context.Canceled) {
+		// the signal that interrupted the run, for its exit code
+		return context.Cause(ctx)
+	}
+	if failed > 0 {
+		return exitStatus(exitPartial)
+	}
+	if err != nil {
+		return err
+	}
+	if failedChecks > 0 {
+		return exitStatus(exitFailedCheck)
+	}
+	return nil
 }
@@ -0,0 +1,74 @@
+package sloc
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// C is the language of cgo preambles
+var C = FindLanguage("C")
+
+// CgoLines finds the C code in a cgo file's preamble, the comment right
+// above its import "C", which is a comment to Go but code to the C compiler.
+// It returns the kind C sees of each preamble line with code on it, by
+// 0-based line, or nil if the source doesn't import "C".
+func CgoLines(src []byte) map[int]LineKind {
+	if !bytes.Contains(src, []byte(`"C"`)) {
+		return nil
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return nil
+	}
+
+	var res map[int]LineKind
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			imp := spec.(*ast.ImportSpec)
+			if imp.Path.Value != `"C"` {
+				continue
+			}
+			// as cgo takes it, the import's own doc comment or that of a
+			// declaration importing only "C"
+			doc := imp.Doc
+			if doc == nil && len(gen.Specs) == 1 {
+				doc = gen.Doc
+			}
+			if doc == nil {
+				continue
+			}
+			if res == nil {
+				res = map[int]LineKind{}
+			}
+			classifyPreamble(fset, doc, res)
+		}
+	}
+	return res
+}
+
+// classifyPreamble classifies the C inside a preamble's comments, keeping
+// the lines it finds code on
+func classifyPreamble(fset *token.FileSet, doc *ast.CommentGroup, res map[int]LineKind) {
+	c := NewClassifier(C)
+	for _, comment := range doc.List {
+		// ignore //line directives, lines are counted as they are in the file
+		first := fset.PositionFor(comment.Pos(), false).Line - 1
+		text := comment.Text[2:]
+		if strings.HasPrefix(comment.Text, "/*") {
+			text = strings.TrimSuffix(text, "*/")
+		}
+		for i, line := range strings.Split(text, "\n") {
+			if kind := c.Classify(line); kind&CodeLine != 0 {
+				res[first+i] = kind
+			}
+		}
+	}
+}
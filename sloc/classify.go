@@ -0,0 +1,531 @@
+package sloc
+
+import (
+	"bytes"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Quote is a string literal delimiter
+type Quote struct {
+	Open, Close string
+	Escapes     bool // backslash escapes the next character
+	Multiline   bool // the literal may span lines (e.g. Go raw strings)
+
+	// only a character literal: taken as one when it closes after a rune or
+	// two, or an escape, so Rust lifetimes such as 'a aren't
+	Char bool
+}
+
+// Language describes the lexical syntax the line classifier needs
+type Language struct {
+	Name          string
+	Extensions    []string
+	Interpreters  []string // shebang interpreters for extensionless scripts
+	LineComments  []string
+	BlockComments [][2]string
+	Quotes        []Quote  // longer delimiters first, the first match wins
+	Directives    []string // prefixes of comments that instruct a tool, e.g. //go:build
+
+	NestedComments bool // block comments nest, as in Rust and Swift
+	WordComments   bool // line comments only start a word, so ${#x} and a#b aren't any
+	Heredocs       bool // <<WORD opens a here-document, as in shell
+}
+
+var (
+	cComments    = [][2]string{{"/*", "*/"}}
+	cQuotes      = []Quote{{Open: `"`, Close: `"`, Escapes: true}, {Open: `'`, Close: `'`, Escapes: true}}
+	scriptQuotes = []Quote{{Open: `"`, Close: `"`, Escapes: true}, {Open: `'`, Close: `'`}}
+)
+
+var Go = &Language{
+	Name:          "Go",
+	Extensions:    []string{".go"},
+	LineComments:  []string{"//"},
+	BlockComments: cComments,
+	Directives:    []string{"//go:", "//line ", "//export ", "//extern ", "// +build ", "//nolint", "//lint:"},
+	Quotes: []Quote{
+		{Open: `"`, Close: `"`, Escapes: true},
+		{Open: `'`, Close: `'`, Escapes: true},
+		{Open: "`", Close: "`", Multiline: true},
+	},
+}
+
+var javascriptDirectives = []string{"// eslint-", "/* eslint", "/* global ", "// @ts-", "/// <reference "}
+
+var javascriptQuotes = []Quote{
+	{Open: `"`, Close: `"`, Escapes: true},
+	{Open: `'`, Close: `'`, Escapes: true},
+	{Open: "`", Close: "`", Escapes: true, Multiline: true},
+}
+
+// languages are the languages that can be counted, chosen by extension
+var languages = []*Language{
+	Go,
+	// Go's assembler and preprocessed GNU assembly (.S) share C's comments
+	{Name: "Assembly", Extensions: []string{".s"}, LineComments: []string{"//"}, BlockComments: cComments, Quotes: cQuotes},
+	{Name: "C", Extensions: []string{".c", ".h"}, LineComments: []string{"//"}, BlockComments: cComments, Quotes: cQuotes},
+	{Name: "C++", Extensions: []string{".cc", ".cpp", ".cxx", ".hh", ".hpp", ".hxx"}, LineComments: []string{"//"}, BlockComments: cComments, Quotes: cQuotes},
+	{Name: "CSS", Extensions: []string{".css"}, BlockComments: cComments, Quotes: cQuotes},
+	{Name: "HTML", Extensions: []string{".html", ".htm"}, BlockComments: [][2]string{{"<!--", "-->"}}},
+	{Name: "Java", Extensions: []string{".java"}, LineComments: []string{"//"}, BlockComments: cComments, Quotes: cQuotes},
+	{Name: "JavaScript", Extensions: []string{".js", ".mjs", ".cjs", ".jsx"}, Interpreters: []string{"node"}, LineComments: []string{"//"}, BlockComments: cComments, Quotes: javascriptQuotes, Directives: javascriptDirectives},
+	{Name: "Kotlin", Extensions: []string{".kt", ".kts"}, LineComments: []string{"//"}, BlockComments: cComments, Quotes: append([]Quote{{Open: `"""`, Close: `"""`, Multiline: true}}, cQuotes...)},
+	{Name: "Protocol Buffers", Extensions: []string{".proto"}, LineComments: []string{"//"}, BlockComments: cComments, Quotes: cQuotes},
+	{Name: "Python", Extensions: []string{".py", ".pyi"}, Interpreters: []string{"python"}, LineComments: []string{"#"}, Directives: []string{"# type:", "# noqa", "# pylint:", "# mypy:", "# fmt:"}, Quotes: []Quote{
+		{Open: `"""`, Close: `"""`, Escapes: true, Multiline: true},
+		{Open: `'''`, Close: `'''`, Escapes: true, Multiline: true},
+		{Open: `"`, Close: `"`, Escapes: true},
+		{Open: `'`, Close: `'`, Escapes: true},
+	}},
+	{Name: "Ruby", Extensions: []string{".rb"}, Interpreters: []string{"ruby"}, LineComments: []string{"#"}, BlockComments: [][2]string{{"=begin", "=end"}}, Quotes: cQuotes},
+	{Name: "Rust", Extensions: []string{".rs"}, LineComments: []string{"//"}, BlockComments: cComments, NestedComments: true, Quotes: []Quote{
+		{Open: `r##"`, Close: `"##`, Multiline: true},
+		{Open: `r#"`, Close: `"#`, Multiline: true},
+		{Open: `r"`, Close: `"`, Multiline: true},
+		{Open: `"`, Close: `"`, Escapes: true, Multiline: true},
+		{Open: `'`, Close: `'`, Escapes: true, Char: true},
+	}},
+	{Name: "Shell", Extensions: []string{".sh", ".bash", ".zsh"}, Interpreters: []string{"sh", "bash", "zsh", "dash", "ksh"}, LineComments: []string{"#"}, WordComments: true, Heredocs: true, Quotes: scriptQuotes},
+	{Name: "Swift", Extensions: []string{".swift"}, LineComments: []string{"//"}, BlockComments: cComments, NestedComments: true, Quotes: []Quote{
+		{Open: `"""`, Close: `"""`, Escapes: true, Multiline: true},
+		{Open: `#"`, Close: `"#`},
+		{Open: `"`, Close: `"`, Escapes: true},
+	}},
+	{Name: "SQL", Extensions: []string{".sql"}, LineComments: []string{"--"}, BlockComments: cComments, Quotes: []Quote{{Open: `'`, Close: `'`}}},
+	{Name: "TOML", Extensions: []string{".toml"}, LineComments: []string{"#"}, Quotes: cQuotes},
+	{Name: "TypeScript", Extensions: []string{".ts", ".tsx", ".mts", ".cts"}, LineComments: []string{"//"}, BlockComments: cComments, Quotes: javascriptQuotes, Directives: javascriptDirectives},
+	{Name: "YAML", Extensions: []string{".yaml", ".yml"}, LineComments: []string{"#"}, WordComments: true, Quotes: scriptQuotes},
+}
+
+// indexes of languages by extension and by shebang interpreter
+var (
+	byExt         = map[string]*Language{}
+	byInterpreter = map[string]*Language{}
+)
+
+func init() {
+	for _, lang := range languages {
+		indexLanguage(lang)
+	}
+}
+
+func indexLanguage(lang *Language) {
+	for _, ext := range lang.Extensions {
+		byExt[ext] = lang
+	}
+	for _, interp := range lang.Interpreters {
+		byInterpreter[interp] = lang
+	}
+}
+
+// RegisterLanguage adds a language, or replaces the one using its extensions
+func RegisterLanguage(lang *Language) {
+	languages = append(languages, lang)
+	indexLanguage(lang)
+}
+
+// FindLanguage looks a language up by name or by one of its extensions,
+// so "cpp", "c++" and "C++" all find C++
+func FindLanguage(name string) *Language {
+	for _, lang := range languages {
+		if strings.EqualFold(lang.Name, name) {
+			return lang
+		}
+	}
+	return byExt["."+strings.ToLower(strings.TrimPrefix(name, "."))]
+}
+
+// SetExtension counts files with ext, such as ".inc", as lang
+func SetExtension(ext string, lang *Language) {
+	byExt["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = lang
+}
+
+// Extensions maps each extension that is counted to its language
+func Extensions() map[string]*Language {
+	return maps.Clone(byExt)
+}
+
+// LanguageFor picks the language to count path as, or nil if it isn't one
+func LanguageFor(path string) *Language {
+	return byExt[strings.ToLower(filepath.Ext(path))]
+}
+
+// ShebangLanguage picks a script's language from its #! line, looking
+// through env and version suffixes: "#!/usr/bin/env python3" is Python
+func ShebangLanguage(line string) *Language {
+	if !strings.HasPrefix(line, "#!") {
+		return nil
+	}
+	args := strings.Fields(line[2:])
+	if len(args) == 0 {
+		return nil
+	}
+
+	interp := filepath.Base(args[0])
+	if interp == "env" {
+		interp = ""
+		for _, arg := range args[1:] {
+			// skip env's own options and variable assignments
+			if !strings.HasPrefix(arg, "-") && !strings.Contains(arg, "=") {
+				interp = filepath.Base(arg)
+				break
+			}
+		}
+	}
+	return byInterpreter[strings.TrimRight(interp, "0123456789.")]
+}
+
+// SniffLanguage reads an extensionless file's first line for a shebang
+func SniffLanguage(path string) *Language {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	return sniffShebang(file)
+}
+
+// sniffShebang reads the first line of a script for a shebang
+func sniffShebang(r io.Reader) *Language {
+	buf := make([]byte, 256)
+	n, _ := io.ReadFull(r, buf)
+	return ShebangLanguage(firstLine(buf[:n]))
+}
+
+// firstLine is the text up to the first line ending, of any kind
+func firstLine(b []byte) string {
+	if i := bytes.IndexAny(b, "\r\n"); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// LineKind is the set of token kinds found on a line
+type LineKind int
+
+const (
+	BlankLine   LineKind = 0
+	CommentLine LineKind = 1 << iota
+	CodeLine
+	MixedLine = CommentLine | CodeLine
+)
+
+// Classifier is a minimal tokenizer that only knows about comments and
+// string literals, so comment markers inside strings are ignored. It keeps
+// the state that carries over from one line to the next.
+type Classifier struct {
+	lang     *Language
+	block    *[2]string // open block comment delimiters
+	depth    int        // of the open block comment, when they nest
+	quote    *Quote     // open multi-line string literal
+	heredocs []heredoc  // opened by the lines so far, read in order
+}
+
+// heredoc is a here-document still to be read: the lines up to the one
+// holding just its word are code, whatever they hold
+type heredoc struct {
+	word   string
+	indent bool // <<- strips leading tabs from the lines, the last one too
+}
+
+func NewClassifier(lang *Language) *Classifier {
+	return &Classifier{lang: lang}
+}
+
+// classify labels a line: code and comments on the same line, such as
+// `foo() /* note` or `*/ bar()`, make it a mixed line; a line with only one of
+// them is a code or a comment line. Comment state is tracked through the whole
+// line either way, so the lines that follow are classified correctly.
+func (this *Classifier) Classify(line string) LineKind {
+	kind := BlankLine
+	mark := func(k LineKind) {
+		kind |= k
+	}
+
+	if len(this.heredocs) > 0 {
+		doc := this.heredocs[0]
+		text := strings.TrimRight(line, "\r")
+		if doc.indent {
+			text = strings.TrimLeft(text, "\t")
+		}
+		if text == doc.word {
+			this.heredocs = this.heredocs[1:]
+		}
+		if strings.TrimSpace(line) == "" {
+			return BlankLine
+		}
+		return CodeLine
+	}
+
+	// where the open block comment's delimiters next appear in the line,
+	// searched for again only once i has passed them, so a line with many
+	// of them is still read once
+	var next [2]int
+	var nextOf *[2]string
+	find := func(i, k int) int {
+		if nextOf != this.block {
+			next, nextOf = [2]int{-2, -2}, this.block
+		}
+		if next[k] == -2 || next[k] >= 0 && next[k] < i {
+			next[k] = strings.Index(line[i:], this.block[k])
+			if next[k] >= 0 {
+				next[k] += i
+			}
+		}
+		return next[k]
+	}
+
+	for i := 0; i < len(line); {
+		switch {
+		case this.block != nil:
+			mark(CommentLine)
+			end := find(i, 1)
+			if this.lang.NestedComments {
+				// an opening before the close goes a level deeper
+				if open := find(i, 0); open >= 0 && (end < 0 || open < end) {
+					this.depth++
+					i = open + len(this.block[0])
+					continue
+				}
+			}
+			if end < 0 {
+				return kind
+			}
+			i = end + len(this.block[1])
+			if this.depth--; this.depth <= 0 {
+				this.block = nil
+			}
+		case this.quote != nil:
+			mark(CodeLine)
+			i = this.skipQuote(line, i)
+		case line[i] == ' ' || line[i] == '\t' || line[i] == '\r' || line[i] == '\f' || line[i] == '\v':
+			i++
+		default:
+			rest := line[i:]
+			if hasAnyPrefix(rest, this.lang.LineComments) && this.lang.commentAt(line, i) {
+				mark(CommentLine)
+				return kind
+			}
+			if block := this.blockAt(rest); block != nil {
+				mark(CommentLine)
+				this.block, this.depth = block, 1
+				i += len(block[0])
+				continue
+			}
+			mark(CodeLine)
+			if q := this.quoteAt(rest); q != nil {
+				this.quote = q
+				i += len(q.Open)
+				continue
+			}
+			if this.lang.Heredocs {
+				if doc, n := heredocAt(rest); n > 0 {
+					this.heredocs = append(this.heredocs, doc)
+					i += n
+					continue
+				}
+			}
+			i++
+		}
+	}
+
+	// only multi-line literals stay open past the end of the line
+	if this.quote != nil && !this.quote.Multiline {
+		this.quote = nil
+	}
+	return kind
+}
+
+// InBlockComment reports whether a block comment is open at the end of the
+// last line classified
+func (this *Classifier) InBlockComment() bool {
+	return this.block != nil
+}
+
+// BlankInComment settles what a line with nothing but whitespace, classified
+// as kind, counts as: blank, or with mode "comment" a comment if it's inside
+// a block comment. The Go scanner takes such lines as comments and the
+// heuristic as blank or comments, depending on their whitespace; inBlock is
+// the heuristic's InBlockComment after the line.
+func BlankInComment(raw []byte, kind LineKind, inBlock bool, mode string) LineKind {
+	if len(bytes.TrimSpace(raw)) > 0 || kind&CodeLine != 0 {
+		return kind
+	}
+	if mode == "comment" && (kind == CommentLine || inBlock) {
+		return CommentLine
+	}
+	return BlankLine
+}
+
+// skipQuote advances past the open literal's closing delimiter, or to the end
+// of the line if it isn't closed there
+func (this *Classifier) skipQuote(line string, i int) int {
+	for i < len(line) {
+		if this.quote.Escapes && line[i] == '\\' {
+			i += 2
+			continue
+		}
+		if strings.HasPrefix(line[i:], this.quote.Close) {
+			i += len(this.quote.Close)
+			this.quote = nil
+			return i
+		}
+		i++
+	}
+	return len(line)
+}
+
+// commentAt reports whether a line comment marker at line[i] starts one:
+// always, unless the language's comments only start a word
+func (this *Language) commentAt(line string, i int) bool {
+	return !this.WordComments || i == 0 || strings.IndexByte(" \t;&|()", line[i-1]) >= 0
+}
+
+// heredocAt reads a here-document's opening, <<WORD, <<-WORD, <<'WORD' or
+// <<"WORD", returning it and its length, or 0 if s doesn't start with one.
+// <<< is a here-string, and <<2 a shift.
+func heredocAt(s string) (heredoc, int) {
+	if !strings.HasPrefix(s, "<<") || strings.HasPrefix(s, "<<<") {
+		return heredoc{}, 0
+	}
+	n := 2
+	var doc heredoc
+	if strings.HasPrefix(s[n:], "-") {
+		doc.indent = true
+		n++
+	}
+	for n < len(s) && (s[n] == ' ' || s[n] == '\t') {
+		n++
+	}
+	quote := byte(0)
+	if n < len(s) && (s[n] == '\'' || s[n] == '"' || s[n] == '\\') {
+		quote = s[n]
+		n++
+	}
+	start := n
+	for n < len(s) && (s[n] == '_' || 'a' <= s[n]|0x20 && s[n]|0x20 <= 'z' || n > start && '0' <= s[n] && s[n] <= '9') {
+		n++
+	}
+	if n == start {
+		return heredoc{}, 0
+	}
+	doc.word = s[start:n]
+	if quote == '\'' || quote == '"' {
+		if n == len(s) || s[n] != quote {
+			return heredoc{}, 0
+		}
+		n++
+	}
+	return doc, n
+}
+
+func (this *Classifier) blockAt(s string) *[2]string {
+	for i := range this.lang.BlockComments {
+		if strings.HasPrefix(s, this.lang.BlockComments[i][0]) {
+			return &this.lang.BlockComments[i]
+		}
+	}
+	return nil
+}
+
+func (this *Classifier) quoteAt(s string) *Quote {
+	for i := range this.lang.Quotes {
+		q := &this.lang.Quotes[i]
+		if strings.HasPrefix(s, q.Open) && (!q.Char || charLiteralAt(s[len(q.Open):], q.Close)) {
+			return q
+		}
+	}
+	return nil
+}
+
+// charLiteralAt reports whether s, following a character literal's opening,
+// closes it: after an escape such as \' or \u{1F600}, or after one or two
+// runes
+func charLiteralAt(s, close string) bool {
+	if strings.HasPrefix(s, `\`) {
+		if len(s) < 2 {
+			return false
+		}
+		end := strings.Index(s[2:], close)
+		return end >= 0 && end <= 8
+	}
+	for n := 0; n < 2 && s != ""; n++ {
+		_, size := utf8.DecodeRuneInString(s)
+		if s = s[size:]; strings.HasPrefix(s, close) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDirective reports whether a comment line instructs a tool rather than
+// documenting the code
+func (this *Language) IsDirective(line []byte) bool {
+	return hasAnyPrefix(strings.TrimLeft(string(line), " \t"), this.Directives)
+}
+
+// CommentStart is where a comment line's comment begins: the first comment
+// delimiter on a line with code too, otherwise the start of the line
+func (this *Language) CommentStart(kind LineKind, line []byte) int {
+	if kind != MixedLine {
+		return 0
+	}
+	start := len(line)
+	for _, delim := range this.commentDelimiters() {
+		for i := 0; i < start; {
+			j := bytes.Index(line[i:], []byte(delim))
+			if j < 0 {
+				break
+			}
+			if i += j; this.commentAt(string(line), i) || !slices.Contains(this.LineComments, delim) {
+				start = i
+				break
+			}
+			i += len(delim)
+		}
+	}
+	return start
+}
+
+func (this *Language) commentDelimiters() []string {
+	res := slices.Clone(this.LineComments)
+	for _, block := range this.BlockComments {
+		res = append(res, block[0])
+	}
+	return res
+}
+
+// SlocDirective is the instruction to sloc a comment opens with, such as
+// "ignore-begin" for // sloc:ignore-begin, or ""
+func (this *Language) SlocDirective(kind LineKind, line []byte) string {
+	text := strings.TrimSpace(string(line[this.CommentStart(kind, line):]))
+	for _, delim := range this.commentDelimiters() {
+		if rest, ok := strings.CutPrefix(text, delim); ok {
+			text = rest
+			break
+		}
+	}
+	// the middle of a block comment may be starred
+	text = strings.TrimLeft(text, " \t*")
+	directive, ok := strings.CutPrefix(text, "sloc:")
+	if !ok {
+		return ""
+	}
+	directive, _, _ = strings.Cut(directive, " ")
+	return strings.TrimSpace(directive)
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
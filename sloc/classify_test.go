@@ -0,0 +1,129 @@
+package sloc
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/golden.txt from the current counts")
+
+// goldenOptions count the corpus with mixed lines apart and a line limit
+// the long file passes, so both show up in the golden counts
+var goldenOptions = Options{Mixed: "separate", MaxLineBytes: 4096}
+
+func goldenCounts(t *testing.T) string {
+	paths, err := filepath.Glob(filepath.Join("testdata", "golden", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	for _, path := range paths {
+		stats, err := CountFile(path, &goldenOptions)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		fmt.Fprintf(&b, "%s %s blank=%d comment=%d code=%d mixed=%d long=%d\n", filepath.Base(path), stats.Language,
+			stats.Blank, stats.Comment, stats.Code, stats.Mixed, stats.LongLines)
+	}
+	return b.String()
+}
+
+// TestGolden counts a corpus of tricky files, nested comments, markers in
+// strings, a BOM and a long line, against the counts they're known to have;
+// go test -run TestGolden -update takes the current counts after a change
+// that's meant to alter them
+func TestGolden(t *testing.T) {
+	golden := filepath.Join("testdata", "golden.txt")
+	got := goldenCounts(t)
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("counts changed, -update if that's intended\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// lineCount is how many lines src has: one per terminator, LF, CRLF or a
+// lone CR, and one more for anything after the last, a BOM aside
+func lineCount(src []byte) int {
+	src = bytes.TrimPrefix(src, []byte("\xef\xbb\xbf"))
+	n := 0
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' || src[i] == '\r' && (i+1 == len(src) || src[i+1] != '\n') {
+			n++
+		}
+	}
+	if len(src) > 0 && src[len(src)-1] != '\n' && src[len(src)-1] != '\r' {
+		n++
+	}
+	return n
+}
+
+// FuzzClassify counts arbitrary input as every language, with either Go
+// classifier, checking nothing panics and each line is counted exactly once
+func FuzzClassify(f *testing.F) {
+	paths, _ := filepath.Glob(filepath.Join("testdata", "golden", "*"))
+	for _, path := range paths {
+		if data, err := os.ReadFile(path); err == nil {
+			f.Add(data)
+		}
+	}
+	for _, seed := range []string{"", "\n", "x", "/* a /* b */", "`\n", "'\"'\r\n//", "<<EOF\n#\nEOF", "\"\"\"\r# x\r\"\"\""} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		for _, lang := range languages {
+			for _, mode := range []string{"scanner", "heuristic"} {
+				opts := Options{Mixed: "separate", Directives: "separate", GoMode: mode}
+				stats, err := Count("", lang, bytes.NewReader(src), &opts)
+				if errors.Is(err, ErrBinaryFile) || errors.Is(err, ErrIgnoredFile) {
+					continue
+				}
+				if err != nil {
+					t.Fatalf("%s: %v", lang.Name, err)
+				}
+				// transcoded files have other line breaks, and sloc:ignore
+				// leaves lines out on purpose
+				if stats.Encoding != "" || bytes.Contains(src, []byte("sloc:")) {
+					continue
+				}
+				counted := stats.Blank + stats.Comment + stats.Code + stats.Mixed + stats.Directives
+				if want := lineCount(src); counted != want {
+					t.Fatalf("%s, %s: %d lines counted of %d: %+v", lang.Name, mode, counted, want, stats)
+				}
+			}
+		}
+	})
+}
+
+// TestClassifyManyOpeners counts a long line of nested comment openers
+// that never close, which must take time in proportion to the line
+func TestClassifyManyOpeners(t *testing.T) {
+	src := strings.Repeat("/* ", 300000)
+	start := time.Now()
+	stats, err := Count("", LanguageFor("x.rs"), strings.NewReader(src), &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Comment != 1 {
+		t.Errorf("got %+v", stats)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %v", elapsed)
+	}
+}
@@ -0,0 +1,97 @@
+package sloc
+
+import (
+	"bufio"
+	"bytes"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const utf8BOM = "\xef\xbb\xbf"
+
+// how much of a file is sniffed to guess its encoding
+const SniffLen = 4096
+
+// DecodeSource detects the encoding of a source file and returns a reader
+// producing UTF-8, the name of the encoding ("" for UTF-8) and whether a byte
+// order mark was stripped.
+func DecodeSource(r *bufio.Reader) (out *bufio.Reader, name string, bom bool) {
+	sample, _ := r.Peek(SniffLen)
+
+	switch {
+	case bytes.HasPrefix(sample, []byte(utf8BOM)):
+		r.Discard(len(utf8BOM))
+		return r, "", true
+	case bytes.HasPrefix(sample, []byte{0xff, 0xfe}):
+		return transcode(r, unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)), "utf-16le", true
+	case bytes.HasPrefix(sample, []byte{0xfe, 0xff}):
+		return transcode(r, unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)), "utf-16be", true
+	}
+
+	// BOM-less UTF-16 shows up as a NUL in every other byte of ASCII text
+	if even, odd := nulBytes(sample); len(sample) >= 2 {
+		pairs := len(sample) / 2
+		if odd*10 >= pairs*4 && even*20 < pairs {
+			return transcode(r, unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)), "utf-16le", false
+		}
+		if even*10 >= pairs*4 && odd*20 < pairs {
+			return transcode(r, unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)), "utf-16be", false
+		}
+	}
+
+	if !validUTF8Prefix(sample, len(sample) == SniffLen) {
+		return transcode(r, charmap.ISO8859_1), "latin-1", false
+	}
+	return r, "", false
+}
+
+// LooksBinary reports whether decoded text is really binary data: text has
+// no NULs and few control characters besides whitespace
+func LooksBinary(sample []byte) bool {
+	control := 0
+	for _, c := range sample {
+		switch {
+		case c == 0:
+			return true
+		case c < 0x20 && c != '\t' && c != '\n' && c != '\v' && c != '\f' && c != '\r' && c != 0x1b, c == 0x7f:
+			control++
+		}
+	}
+	return control*10 > len(sample)
+}
+
+func transcode(r *bufio.Reader, enc encoding.Encoding) *bufio.Reader {
+	return bufio.NewReader(transform.NewReader(r, enc.NewDecoder()))
+}
+
+func nulBytes(b []byte) (even, odd int) {
+	for i, c := range b {
+		if c != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			even++
+		} else {
+			odd++
+		}
+	}
+	return even, odd
+}
+
+// validUTF8Prefix reports whether b is valid UTF-8, allowing a rune cut off
+// at the end when b is only the start of a longer input
+func validUTF8Prefix(b []byte, truncated bool) bool {
+	if utf8.Valid(b) {
+		return true
+	}
+	for i := 1; truncated && i < utf8.UTFMax && i < len(b); i++ {
+		if utf8.Valid(b[:len(b)-i]) && !utf8.FullRune(b[len(b)-i:]) {
+			return true
+		}
+	}
+	return false
+}
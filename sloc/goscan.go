@@ -0,0 +1,53 @@
+package sloc
+
+import (
+	"bytes"
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// ScanGoLines classifies each line of Go source from its real tokens. It
+// reports false if the source doesn't scan cleanly, as the heuristic copes
+// better with broken or templated files.
+func ScanGoLines(src []byte) ([]LineKind, bool) {
+	// go/scanner only counts LF as a line break, so lone CRs, as old Mac
+	// files end their lines, are made LFs; the offsets stay the same
+	if bytes.Count(src, []byte("\r")) != bytes.Count(src, []byte("\r\n")) {
+		src = bytes.Clone(src)
+		for i, c := range src {
+			if c == '\r' && (i+1 == len(src) || src[i+1] != '\n') {
+				src[i] = '\n'
+			}
+		}
+	}
+
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	failed := false
+	var s scanner.Scanner
+	s.Init(file, src, func(token.Position, string) { failed = true }, scanner.ScanComments)
+
+	kinds := make([]LineKind, bytes.Count(src, []byte("\n"))+1)
+	mark := func(pos token.Pos, lit string, kind LineKind) {
+		// ignore //line directives, lines are counted as they are in the file
+		first := file.PositionFor(pos, false).Line
+		for line := first; line <= first+strings.Count(lit, "\n"); line++ {
+			kinds[line-1] |= kind
+		}
+	}
+
+	for {
+		pos, tok, lit := s.Scan()
+		switch {
+		case tok == token.EOF:
+			return kinds, !failed
+		case tok == token.COMMENT:
+			mark(pos, lit, CommentLine)
+		case tok == token.SEMICOLON && lit == "\n":
+			// inserted at the end of the line, not in the source
+		default:
+			mark(pos, lit, CodeLine)
+		}
+	}
+}
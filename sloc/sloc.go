@@ -0,0 +1,536 @@
+// Package sloc counts the code, comment and blank lines of source files, as
+// the sloc command does:
+//
+//	files, total, err := sloc.CountTree(".", &sloc.Options{Mixed: "separate"})
+//	if err != nil {
+//		return err
+//	}
+//	fmt.Println(total.Files, "files,", total.Code, "lines of code")
+package sloc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+)
+
+// FileStats are the lines counted in one file
+type FileStats struct {
+	Filename   string
+	Language   string
+	Code       int
+	Comment    int
+	Blank      int
+	Mixed      int    // code lines with a comment, with Mixed "separate"
+	Directives int    // tool directives such as //go:build, with Directives "separate"
+	LongLines  int    // lines classified by only their first MaxLineBytes
+	Cgo        int    // lines of C in a cgo preamble, counted as code rather than comment
+	BOM        bool   // the file started with a byte order mark
+	Encoding   string // the file was transcoded from this encoding to UTF-8
+	LineEnding string // the dominant line terminator
+	Generated  bool   // the file is marked or named as generated code
+
+	NoFinalNewline bool // the last line has no line ending
+
+	// the file has a sloc:ignore-begin without a sloc:ignore-end, so the
+	// rest of it wasn't counted
+	UnterminatedIgnore bool
+}
+
+// Summary totals the files counted, overall and by language
+type Summary struct {
+	Files      int
+	Code       int
+	Comment    int
+	Blank      int
+	Mixed      int
+	Directives int
+	Languages  map[string]Summary
+}
+
+// Add counts f into the summary
+func (this *Summary) Add(f FileStats) {
+	this.add(f)
+	if this.Languages == nil {
+		this.Languages = map[string]Summary{}
+	}
+	lang := this.Languages[f.Language]
+	lang.add(f)
+	this.Languages[f.Language] = lang
+}
+
+func (this *Summary) add(f FileStats) {
+	this.Files++
+	this.Code += f.Code
+	this.Comment += f.Comment
+	this.Blank += f.Blank
+	this.Mixed += f.Mixed
+	this.Directives += f.Directives
+}
+
+// Options change how files are counted. The zero value, like a nil
+// *Options, counts as the sloc command does by default.
+type Options struct {
+	// Mixed is what a line holding both code and a comment counts as:
+	// "code" (the default), "comment", "both", or "separate" to count it
+	// in FileStats.Mixed
+	Mixed string
+	// Directives is what comments such as //go:build and //nolint count
+	// as: "comment" (the default), "code", or "separate" to count them in
+	// FileStats.Directives
+	Directives string
+	// GoMode is "scanner" (the default) to classify Go from its tokens,
+	// falling back to the heuristic for files that don't scan, or
+	// "heuristic" to always use the faster heuristic
+	GoMode string
+	// MaxLineBytes is how much of each line is classified, 0 for all of it
+	MaxLineBytes int
+	// MaxFileSize is the largest file CountFile counts, 0 for no limit
+	MaxFileSize int64
+	// IncludeGenerated keeps generated files in CountTree's results
+	IncludeGenerated bool
+	// SkipDirs are the directory names CountTree doesn't descend into,
+	// DependencyDirs if nil
+	SkipDirs []string
+	// BlankComments is what a blank line inside a block comment counts as:
+	// "blank" (the default) or "comment"
+	BlankComments string
+	// Throttle, if set, limits the files open and the bytes read, shared
+	// with every other count given it
+	Throttle *Throttle
+
+	// Source, if set, is called with a Go file's decoded source before its
+	// lines are counted
+	Source func(src []byte)
+	// Line, if set, is called with each line counted, numbered from 1, and
+	// the language it's counted as
+	Line func(lang *Language, n int, kind LineKind, raw []byte)
+}
+
+var defaultOptions Options
+
+// Option sets one of the Options CountFS counts with
+type Option func(*Options)
+
+// WithOptions counts with all of opts
+func WithOptions(opts Options) Option {
+	return func(o *Options) { *o = opts }
+}
+
+// WithMixed sets Options.Mixed
+func WithMixed(mode string) Option {
+	return func(o *Options) { o.Mixed = mode }
+}
+
+// WithDirectives sets Options.Directives
+func WithDirectives(mode string) Option {
+	return func(o *Options) { o.Directives = mode }
+}
+
+// WithBlankComments sets Options.BlankComments
+func WithBlankComments(mode string) Option {
+	return func(o *Options) { o.BlankComments = mode }
+}
+
+// WithGoMode sets Options.GoMode
+func WithGoMode(mode string) Option {
+	return func(o *Options) { o.GoMode = mode }
+}
+
+// WithGenerated keeps generated files in the results
+func WithGenerated() Option {
+	return func(o *Options) { o.IncludeGenerated = true }
+}
+
+// WithSkipDirs sets the directory names that aren't descended into
+func WithSkipDirs(names ...string) Option {
+	return func(o *Options) { o.SkipDirs = append([]string{}, names...) }
+}
+
+// WithThrottle sets Options.Throttle
+func WithThrottle(t *Throttle) Option {
+	return func(o *Options) { o.Throttle = t }
+}
+
+var (
+	// ErrBinaryFile means a file with a source extension holds binary data
+	ErrBinaryFile = errors.New("binary file")
+	// ErrIgnoredFile means a file asks not to be counted with a
+	// comment, sloc:ignore-file
+	ErrIgnoredFile = errors.New("marked sloc:ignore-file")
+	// ErrFileTooLarge means a file is over Options.MaxFileSize
+	ErrFileTooLarge = errors.New("file too large")
+)
+
+// DependencyDirs hold version control metadata, vendored or installed
+// third-party code and virtualenvs, none of it the project's own
+var DependencyDirs = []string{".git", ".hg", ".svn", "vendor", "node_modules", "bower_components", ".venv", "venv", "__pycache__"}
+
+// generatedMarkers flag generated files from the comments before their first
+// line of code: Go's "Code generated ... DO NOT EDIT." convention (which
+// protoc-gen-go, mockgen and mockery follow), older tools' variations on it,
+// protoc's own header for other languages and the @generated annotation
+var generatedMarkers = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(code|automatically|auto-?) ?generated\b.*\bdo not (edit|modify)\b`),
+	regexp.MustCompile(`(?i)\bgenerated by the protocol buffer compiler\b`),
+	regexp.MustCompile(`@generated\b`),
+}
+
+// generatedNames are the output names of generators that don't always leave
+// a marker, matched against the base name
+var generatedNames = []string{"*.pb.go", "*.pb.gw.go", "*.pb.cc", "*.pb.h", "*_pb2.py", "*_pb2_grpc.py", "zz_generated*.go", "mock_*.go", "*_mock.go"}
+
+// IsGeneratedName reports whether path is named like a generator's output
+func IsGeneratedName(path string) bool {
+	for _, pattern := range generatedNames {
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isGeneratedComment(line []byte) bool {
+	for _, marker := range generatedMarkers {
+		if marker.Match(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// CountTree counts the source files below root, in the order they're walked.
+// Binary, ignored and oversized files are skipped, and generated ones too
+// unless opts.IncludeGenerated is set.
+func CountTree(root string, opts *Options) ([]FileStats, Summary, error) {
+	if opts == nil {
+		opts = &defaultOptions
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, Summary{}, err
+	}
+	if !info.IsDir() {
+		var total Summary
+		f, err := CountFile(root, opts)
+		if err != nil {
+			return nil, total, err
+		}
+		total.Add(f)
+		return []FileStats{f}, total, nil
+	}
+	return countFS(os.DirFS(root), root, opts)
+}
+
+// CountFS counts the source files in fsys, such as an embed.FS, a zip.Reader
+// or an fstest.MapFS, as CountTree does. Files are named by their paths in
+// fsys.
+func CountFS(fsys fs.FS, opts ...Option) ([]FileStats, Summary, error) {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return countFS(fsys, "", &o)
+}
+
+// countFS walks fsys, naming its files below root
+func countFS(fsys fs.FS, root string, opts *Options) ([]FileStats, Summary, error) {
+	skip := opts.SkipDirs
+	if skip == nil {
+		skip = DependencyDirs
+	}
+
+	var files []FileStats
+	var total Summary
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name != "." && slices.Contains(skip, d.Name()) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		lang := LanguageFor(name)
+		if lang == nil && path.Ext(name) == "" {
+			lang = sniffFS(fsys, name, opts.Throttle)
+		}
+		if lang == nil {
+			return nil
+		}
+
+		f, err := countFSFile(fsys, name, filepath.Join(root, filepath.FromSlash(name)), lang, opts)
+		switch {
+		case errors.Is(err, ErrBinaryFile), errors.Is(err, ErrIgnoredFile), errors.Is(err, ErrFileTooLarge):
+			return nil
+		case err != nil:
+			return err
+		case f.Generated && !opts.IncludeGenerated:
+			return nil
+		}
+		files = append(files, f)
+		total.Add(f)
+		return nil
+	})
+	return files, total, err
+}
+
+func sniffFS(fsys fs.FS, name string, throttle *Throttle) *Language {
+	throttle.Acquire()
+	defer throttle.Release()
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	return sniffShebang(throttle.Reader(file))
+}
+
+// countFSFile counts the file name in fsys as lang, reporting it as filename
+func countFSFile(fsys fs.FS, name, filename string, lang *Language, opts *Options) (FileStats, error) {
+	opts.Throttle.Acquire()
+	defer opts.Throttle.Release()
+	file, err := fsys.Open(name)
+	if err != nil {
+		return FileStats{Filename: filename}, err
+	}
+	defer file.Close()
+
+	if opts.MaxFileSize > 0 {
+		info, err := file.Stat()
+		if err != nil {
+			return FileStats{Filename: filename}, err
+		}
+		if info.Size() > opts.MaxFileSize {
+			return FileStats{Filename: filename}, fmt.Errorf("%s: %w (%d bytes)", filename, ErrFileTooLarge, info.Size())
+		}
+	}
+	return Count(filename, lang, opts.Throttle.Reader(file), opts)
+}
+
+// CountFile counts the file at path, as the language its name or shebang
+// suggests
+func CountFile(path string, opts *Options) (FileStats, error) {
+	if opts == nil {
+		opts = &defaultOptions
+	}
+	return countFSFile(os.DirFS(filepath.Dir(path)), filepath.Base(path), path, nil, opts)
+}
+
+// CountReader counts r as lang. The zero Language has no comments or
+// strings, so every line that isn't blank is code.
+func CountReader(r io.Reader, lang Language) (FileStats, error) {
+	return Count("", &lang, r, nil)
+}
+
+// Count counts r as lang, or as the language its name or shebang suggests if
+// lang is nil. Go is the fallback for files that suggest neither.
+func Count(name string, lang *Language, r io.Reader, opts *Options) (FileStats, error) {
+	if opts == nil {
+		opts = &defaultOptions
+	}
+	// classify UTF-8 without a byte order mark hiding the first line's
+	// leading token
+	reader, enc, bom := DecodeSource(bufio.NewReader(r))
+	if sample, _ := reader.Peek(SniffLen); LooksBinary(sample) {
+		return FileStats{Filename: name}, ErrBinaryFile
+	}
+
+	if lang == nil {
+		lang = LanguageFor(name)
+	}
+	if lang == nil {
+		first, _ := reader.Peek(256)
+		lang = ShebangLanguage(firstLine(first))
+	}
+	if lang == nil {
+		lang = Go
+	}
+	res := FileStats{Filename: name, Language: lang.Name, Generated: IsGeneratedName(name)}
+	res.Encoding, res.BOM = enc, bom
+
+	// Go is classified from real tokens when it scans cleanly, the lines are
+	// still read below for everything else
+	var kinds []LineKind
+	var cgo map[int]LineKind
+	if lang.Name == Go.Name {
+		src, err := io.ReadAll(reader)
+		if err != nil {
+			return res, err
+		}
+		cgo = CgoLines(src)
+		if opts.GoMode != "heuristic" {
+			var ok bool
+			if kinds, ok = ScanGoLines(src); !ok {
+				kinds = nil
+			}
+		}
+		if opts.Source != nil {
+			opts.Source(src)
+		}
+		reader = bufio.NewReader(bytes.NewReader(src))
+	}
+
+	// read file line by line
+	c := NewClassifier(lang)
+	endings := map[string]int{}
+	header := true
+	// lines between sloc:ignore-begin and sloc:ignore-end aren't counted
+	ignoring := false
+	for line := 0; ; line++ {
+		raw, ending, truncated, err := ReadLine(reader, opts.MaxLineBytes)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+		if truncated && kinds == nil {
+			res.LongLines++
+		}
+		if ending != "" {
+			endings[ending]++
+		}
+		res.NoFinalNewline = ending == ""
+
+		var kind LineKind
+		if kinds != nil {
+			kind = kinds[line]
+		} else {
+			kind = c.Classify(string(raw))
+		}
+		kind = BlankInComment(raw, kind, kinds == nil && c.InBlockComment(), opts.BlankComments)
+		if cKind, ok := cgo[line]; ok {
+			kind = cKind
+			res.Cgo++
+		}
+		if kind&CommentLine != 0 {
+			switch lang.SlocDirective(kind, raw) {
+			case "ignore-file":
+				return FileStats{Filename: name}, ErrIgnoredFile
+			case "ignore-begin":
+				ignoring = true
+				continue
+			case "ignore-end":
+				ignoring = false
+				continue
+			}
+		}
+		if ignoring {
+			continue
+		}
+		if opts.Line != nil {
+			opts.Line(lang, line+1, kind, raw)
+		}
+
+		directive := kind == CommentLine && lang.IsDirective(raw)
+		switch {
+		case directive && opts.Directives == "code":
+			res.Code++
+		case directive && opts.Directives == "separate":
+			res.Directives++
+		}
+		if directive && opts.Directives != "" && opts.Directives != "comment" {
+			continue
+		}
+
+		switch kind {
+		case BlankLine:
+			res.Blank++
+		case CommentLine:
+			res.Comment++
+			if header && !res.Generated {
+				res.Generated = isGeneratedComment(raw)
+			}
+		case MixedLine:
+			switch opts.Mixed {
+			case "comment":
+				res.Comment++
+			case "both":
+				res.Code++
+				res.Comment++
+			case "separate":
+				res.Mixed++
+			default:
+				res.Code++
+			}
+			header = false
+		default:
+			res.Code++
+			header = false
+		}
+	}
+	res.UnterminatedIgnore = ignoring
+
+	// order breaks ties in favor of the most common style
+	for _, ending := range []string{LineEndingLF, LineEndingCRLF, LineEndingCR} {
+		if endings[ending] > endings[res.LineEnding] {
+			res.LineEnding = ending
+		}
+	}
+	return res, nil
+}
+
+// line terminators, as reported per file
+const (
+	LineEndingLF   = "LF"
+	LineEndingCRLF = "CRLF"
+	LineEndingCR   = "CR"
+)
+
+// ReadLine returns the next line without its terminator, which may be LF,
+// CRLF or a lone CR. At most max bytes of the line are kept (max <= 0 keeps
+// everything) so huge lines can't exhaust memory. A terminator only ends a
+// line: an empty file yields no lines, and a final line without a trailing
+// newline is returned once with no ending.
+func ReadLine(r *bufio.Reader, max int) (line []byte, ending string, truncated bool, err error) {
+	keep := func(chunk []byte) {
+		if room := max - len(line); max > 0 && len(chunk) > room {
+			chunk = chunk[:room]
+			truncated = true
+		}
+		line = append(line, chunk...)
+	}
+
+	read := false
+	for {
+		if _, err := r.Peek(1); err != nil {
+			if err == io.EOF && read {
+				return line, "", truncated, nil
+			}
+			return line, "", truncated, err
+		}
+		read = true
+
+		buf, _ := r.Peek(r.Buffered())
+		i := bytes.IndexAny(buf, "\r\n")
+		if i < 0 {
+			keep(buf)
+			r.Discard(len(buf))
+			continue
+		}
+
+		keep(buf[:i])
+		r.Discard(i + 1)
+		if buf[i] == '\n' {
+			return line, LineEndingLF, truncated, nil
+		}
+		if next, err := r.Peek(1); err == nil && next[0] == '\n' {
+			r.Discard(1)
+			return line, LineEndingCRLF, truncated, nil
+		}
+		return line, LineEndingCR, truncated, nil
+	}
+}
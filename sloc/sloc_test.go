@@ -0,0 +1,99 @@
+package sloc
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCountFinalLine counts files that end oddly, empty, only line endings,
+// or without a final one, in every way a language is classified
+func TestCountFinalLine(t *testing.T) {
+	cases := []struct {
+		name, src            string
+		blank, comment, code int
+		noFinalNewline       bool
+	}{
+		{name: "empty", src: ""},
+		{name: "newline", src: "\n", blank: 1},
+		{name: "newlines", src: "\n\n\n", blank: 3},
+		{name: "crlf", src: "\r\n", blank: 1},
+		{name: "unterminated code", src: "x", code: 1, noFinalNewline: true},
+		{name: "terminated code", src: "x\n", code: 1},
+		{name: "unterminated after a line", src: "x\ny", code: 2, noFinalNewline: true},
+		{name: "unterminated blank", src: "x\n  ", blank: 1, code: 1, noFinalNewline: true},
+	}
+	for _, lang := range []*Language{Go, FindLanguage("Python"), FindLanguage("C")} {
+		for _, mode := range []string{"scanner", "heuristic"} {
+			for _, tc := range cases {
+				opts := Options{GoMode: mode}
+				stats, err := Count("", lang, strings.NewReader(tc.src), &opts)
+				if err != nil {
+					t.Fatalf("%s, %s, %s: %v", lang.Name, mode, tc.name, err)
+				}
+				if stats.Blank != tc.blank || stats.Comment != tc.comment || stats.Code != tc.code ||
+					stats.NoFinalNewline != tc.noFinalNewline {
+					t.Errorf("%s, %s, %s: got %+v", lang.Name, mode, tc.name, stats)
+				}
+			}
+		}
+	}
+}
+
+// TestCountUnterminatedComment counts a last line that's a comment without a
+// line ending, a line comment and a block comment left open
+func TestCountUnterminatedComment(t *testing.T) {
+	for _, mode := range []string{"scanner", "heuristic"} {
+		for _, src := range []string{"x\n// c", "x\n/* c", "x\n/* c\nd"} {
+			opts := Options{GoMode: mode}
+			stats, err := Count("", Go, strings.NewReader(src), &opts)
+			if err != nil {
+				t.Fatalf("%s, %q: %v", mode, src, err)
+			}
+			if want := strings.Count(src, "\n"); stats.Code != 1 || stats.Comment != want || !stats.NoFinalNewline {
+				t.Errorf("%s, %q: got %+v", mode, src, stats)
+			}
+		}
+	}
+}
+
+// TestCountLineEndings counts the same source with LF, CRLF and lone CR
+// line endings, which only the reported ending tells apart
+func TestCountLineEndings(t *testing.T) {
+	sources := map[*Language]string{
+		Go:                     "package x\n\n// c\nfunc f() {} // m\n/* a\nb */\nvar s = `\n// not a comment\n`\n",
+		FindLanguage("Python"): "x = 1\n\n# c\ny = 2  # m\n\"\"\"\ndoc\n\"\"\"\n",
+	}
+	endings := map[string]string{LineEndingLF: "\n", LineEndingCRLF: "\r\n", LineEndingCR: "\r"}
+	for lang, src := range sources {
+		for _, mode := range []string{"scanner", "heuristic"} {
+			opts := Options{Mixed: "separate", GoMode: mode}
+			want, err := Count("", lang, strings.NewReader(src), &opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for ending, eol := range endings {
+				got, err := Count("", lang, strings.NewReader(strings.ReplaceAll(src, "\n", eol)), &opts)
+				if err != nil {
+					t.Fatalf("%s, %s, %s: %v", lang.Name, mode, ending, err)
+				}
+				if got.LineEnding != ending {
+					t.Errorf("%s, %s: line ending %q, want %q", lang.Name, mode, got.LineEnding, ending)
+				}
+				got.LineEnding = want.LineEnding
+				if got != want {
+					t.Errorf("%s, %s, %s: got %+v, want %+v", lang.Name, mode, ending, got, want)
+				}
+			}
+		}
+	}
+}
+
+// TestLanguageForCase finds a language whatever the case of the extension,
+// as on case-insensitive filesystems
+func TestLanguageForCase(t *testing.T) {
+	for _, path := range []string{"main.go", "MAIN.GO", `C:\src\Main.Go`} {
+		if lang := LanguageFor(path); lang != Go {
+			t.Errorf("%s: got %v, want Go", path, lang)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+﻿# after the BOM
+
+print("x")  # note
@@ -0,0 +1,9 @@
+#!/bin/sh
+echo "${#}" ${#args} a#b # count the arguments
+cat <<EOF2
+# not a comment
+EOF2
+cat <<-'END'
+	# nor this
+	END
+x=$((1 << 2))
@@ -0,0 +1,9 @@
+// header comment
+const url = "http://example.com"; // after code
+const re = '/* not a comment */';
+const tpl = `
+// inside a template
+`;
+/*
+ * block
+ */
@@ -0,0 +1,11 @@
+#!/usr/bin/env python3
+"""Module docstring with a # hash
+and a // slash."""
+
+url = "http://example.com/#top"  # trailing comment
+s = '# not a comment'
+t = '''
+# still inside a string
+'''
+
+# a real comment
@@ -0,0 +1,9 @@
+/* outer /* inner */ still a comment */
+fn first<'a>(s: &'a str) -> char {
+    let quote = '"'; // a quote in a char
+    let escaped = '\'';
+    let raw = r#"not /* a comment
+// nor this "#;
+    /* a /* b */ c */ let n = 1;
+    s.chars().next().unwrap_or(quote)
+}
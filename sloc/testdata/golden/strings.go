@@ -0,0 +1,9 @@
+package main
+
+// a doc comment
+var raw = `
+/* not a comment */
+// nor this
+`
+
+var s = "/* nor this */" // but this is
@@ -0,0 +1,78 @@
+package sloc
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Throttle bounds how many files the counts sharing it hold open at once
+// and how fast they read, so a tree on NFS or another network filesystem
+// doesn't exhaust descriptors or saturate the mount. A nil *Throttle
+// doesn't limit anything.
+type Throttle struct {
+	open chan struct{}
+	rate int64 // bytes a second
+
+	mu   sync.Mutex
+	next time.Time // when the bytes read so far are paid for
+}
+
+// NewThrottle allows maxOpen files open at once and bytesPerSecond read,
+// either 0 for no limit
+func NewThrottle(maxOpen int, bytesPerSecond int64) *Throttle {
+	this := &Throttle{rate: bytesPerSecond}
+	if maxOpen > 0 {
+		this.open = make(chan struct{}, maxOpen)
+	}
+	return this
+}
+
+// Acquire waits for a file to be allowed open; Release gives it back
+func (this *Throttle) Acquire() {
+	if this != nil && this.open != nil {
+		this.open <- struct{}{}
+	}
+}
+
+func (this *Throttle) Release() {
+	if this != nil && this.open != nil {
+		<-this.open
+	}
+}
+
+// Reader paces reads from r to the throttle's rate, shared with every other
+// reader of it
+func (this *Throttle) Reader(r io.Reader) io.Reader {
+	if this == nil || this.rate <= 0 {
+		return r
+	}
+	return throttledReader{r: r, throttle: this}
+}
+
+type throttledReader struct {
+	r        io.Reader
+	throttle *Throttle
+}
+
+func (this throttledReader) Read(p []byte) (int, error) {
+	n, err := this.r.Read(p)
+	this.throttle.wait(n)
+	return n, err
+}
+
+// wait sleeps off n bytes, after any read before them
+func (this *Throttle) wait(n int) {
+	if n <= 0 {
+		return
+	}
+	this.mu.Lock()
+	now := time.Now()
+	if this.next.Before(now) {
+		this.next = now
+	}
+	this.next = this.next.Add(time.Duration(int64(n) * int64(time.Second) / this.rate))
+	delay := this.next.Sub(now)
+	this.mu.Unlock()
+	time.Sleep(delay)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/chriskirkland/go-utils/sloc"
+)
+
+// stdinTarget as a target reads stdin, as a newline-separated list of
+// paths or, with --stdin-content, as the source to count
+const stdinTarget = "-"
+
+var (
+	stdinContent bool
+	stdinLang    string
+)
+
+// readTargetList replaces - in targets with the paths read from r, so the
+// output of find or fd can be piped in
+func readTargetList(r io.Reader, targets []string) ([]string, error) {
+	var res []string
+	for _, target := range targets {
+		if target != stdinTarget {
+			res = append(res, target)
+		}
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if path := strings.TrimSuffix(scanner.Text(), "\r"); path != "" {
+			res = append(res, path)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading paths from stdin: %v", err)
+	}
+	return res, nil
+}
+
+// readFileList reads a --filelist manifest: a path per line, with blank
+// lines and # comments ignored
+func readFileList(path string) ([]string, error) {
+	r := io.Reader(os.Stdin)
+	if path != stdinTarget {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	var res []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		res = append(res, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return res, nil
+}
+
+// countStdin counts r as a single file in the --lang language
+func countStdin(r io.Reader) (fileLines, error) {
+	var lang *sloc.Language
+	if stdinLang != "" {
+		lang = sloc.FindLanguage(stdinLang)
+	}
+	return countLinesAs("(stdin)", lang, r)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// with --style-report, each file's indentation and trailing whitespace are
+// tallied and listed after the table
+var styleReport bool
+
+// styleStats are a file's whitespace habits
+type styleStats struct {
+	TabIndent, SpaceIndent, MixedIndent int // lines indented with each
+	Trailing                            int // lines ending in spaces or tabs
+	NoFinalNewline                      int // files whose last line has no line ending
+}
+
+func (this *styleStats) join(s styleStats) {
+	this.TabIndent += s.TabIndent
+	this.SpaceIndent += s.SpaceIndent
+	this.MixedIndent += s.MixedIndent
+	this.Trailing += s.Trailing
+	this.NoFinalNewline += s.NoFinalNewline
+}
+
+// add tallies a line, without its line ending; lines of nothing but
+// whitespace only count as trailing it
+func (this *styleStats) add(raw []byte) {
+	if n := len(raw); n > 0 && (raw[n-1] == ' ' || raw[n-1] == '\t') {
+		this.Trailing++
+	}
+	tabs, spaces := false, false
+	for _, c := range raw {
+		if c == '\t' {
+			tabs = true
+		} else if c == ' ' {
+			spaces = true
+		} else {
+			switch {
+			case tabs && spaces:
+				this.MixedIndent++
+			case tabs:
+				this.TabIndent++
+			case spaces:
+				this.SpaceIndent++
+			}
+			return
+		}
+	}
+}
+
+// indent names how a file is mostly indented
+func (this styleStats) indent() string {
+	switch {
+	case this.TabIndent == 0 && this.SpaceIndent == 0 && this.MixedIndent == 0:
+		return ""
+	case this.TabIndent > 0 && this.SpaceIndent > 0, this.MixedIndent > 0:
+		return "mixed"
+	case this.TabIndent > 0:
+		return "tabs"
+	}
+	return "spaces"
+}
+
+// writeStyle lists each file's indentation and trailing whitespace after the
+// table, and totals the files to clean up
+func writeStyle(w io.Writer, files []fileLines) {
+	if len(files) == 0 {
+		return
+	}
+	total := styleStats{}
+	styles := map[string]int{}
+	fmt.Fprintln(w)
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Filename", "Indent", "Tab Lines", "Space Lines", "Mixed Lines", "Trailing", "Final Newline"})
+	table.SetBorder(false)
+	for _, f := range files {
+		s := f.style
+		total.join(s)
+		styles[s.indent()]++
+		final := "yes"
+		if s.NoFinalNewline > 0 {
+			final = "missing"
+		}
+		table.Append([]string{displayPath(f.filename), s.indent(), tableCount(s.TabIndent), tableCount(s.SpaceIndent),
+			tableCount(s.MixedIndent), tableCount(s.Trailing), final})
+	}
+	table.Render()
+	fmt.Fprintf(w, "indented with tabs: %d files, spaces: %d, both: %d; %d lines with trailing whitespace; %d files without a final newline\n",
+		styles["tabs"], styles["spaces"], styles["mixed"], total.Trailing, total.NoFinalNewline)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// thresholdMetrics are the counts a --fail-if expression can test; files
+// only makes sense of the total
+var thresholdMetrics = []string{"blank", "code", "comment", "files", "lines"}
+
+// threshold is a --fail-if budget such as file.code>800
+type threshold struct {
+	scope, metric, op string
+	limit             int
+}
+
+var thresholdPattern = regexp.MustCompile(`^\s*(file|total)\.(\w+)\s*(>=|<=|==|!=|>|<)\s*(\d+)\s*$`)
+
+func (this threshold) String() string {
+	return fmt.Sprintf("%s.%s%s%d", this.scope, this.metric, this.op, this.limit)
+}
+
+func (this threshold) value(f fileLines) int {
+	switch this.metric {
+	case "code":
+		return f.codeLines
+	case "comment":
+		return f.commentLines
+	case "blank":
+		return f.whitespaceLines
+	case "files":
+		return f.files
+	}
+	return f.codeLines + f.commentLines + f.whitespaceLines
+}
+
+// fails reports whether f trips the threshold
+func (this threshold) fails(f fileLines) bool {
+	n := this.value(f)
+	switch this.op {
+	case ">":
+		return n > this.limit
+	case ">=":
+		return n >= this.limit
+	case "<":
+		return n < this.limit
+	case "<=":
+		return n <= this.limit
+	case "==":
+		return n == this.limit
+	}
+	return n != this.limit
+}
+
+// thresholdList is a repeatable --fail-if flag
+type thresholdList []threshold
+
+func (this *thresholdList) String() string {
+	var res []string
+	for _, t := range *this {
+		res = append(res, t.String())
+	}
+	return strings.Join(res, ",")
+}
+
+func (this *thresholdList) Set(value string) error {
+	m := thresholdPattern.FindStringSubmatch(value)
+	if m == nil {
+		return fmt.Errorf("want file.<metric> or total.<metric>, a comparison and a number, e.g. 'file.code>800'")
+	}
+	if !slices.Contains(thresholdMetrics, m[2]) {
+		return fmt.Errorf("unknown metric %q, must be one of %s", m[2], strings.Join(thresholdMetrics, ", "))
+	}
+	if m[1] == "file" && m[2] == "files" {
+		return fmt.Errorf("files is only a total, as in total.files")
+	}
+	limit, err := strconv.Atoi(m[4])
+	if err != nil {
+		return fmt.Errorf("limit %q is not a number", m[4])
+	}
+	*this = append(*this, threshold{scope: m[1], metric: m[2], op: m[3], limit: limit})
+	return nil
+}
+
+// budgets are the run's --fail-if, --max-file-code and --max-total-code
+// thresholds, for the outputs that report their violations too
+var budgets []threshold
+
+// budgetFindings lists each file over a file budget, once per budget
+func budgetFindings(files []fileLines) []finding {
+	var res []finding
+	for _, t := range budgets {
+		if t.scope != "file" {
+			continue
+		}
+		for _, f := range files {
+			if t.fails(f) {
+				res = append(res, finding{
+					rule:    "over-budget",
+					path:    f.filename,
+					message: fmt.Sprintf("%s: the file has %d", t, t.value(f)),
+				})
+			}
+		}
+	}
+	return res
+}
+
+// failedChecks counts the budgets and lint checks the run broke, for the
+// exit code
+var failedChecks int
+
+// newThresholdSink checks each file and the total against the thresholds,
+// listing the violations on stderr after the report
+func newThresholdSink(thresholds []threshold) reportSink {
+	return func(target string, files []fileLines, total fileLines) error {
+		total.files = len(files)
+		var lines []string
+		for _, t := range thresholds {
+			if t.scope == "total" {
+				if t.fails(total) {
+					lines = append(lines, fmt.Sprintf("  %s: the total has %d", t, t.value(total)))
+				}
+				continue
+			}
+			for _, f := range files {
+				if t.fails(f) {
+					lines = append(lines, fmt.Sprintf("  %s: %s has %d", t, displayPath(f.filename), t.value(f)))
+				}
+			}
+		}
+		failedChecks += len(lines)
+		if len(lines) > 0 {
+			fmt.Fprintf(os.Stderr, "\nover budget: %d violations\n%s\n", len(lines), strings.Join(lines, "\n"))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// escapes for tag values in the InfluxDB line protocol
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// escapes for label values in the Prometheus text format
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type influxConfig struct {
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+}
+
+type postgresConfig struct {
+	DSN   string `yaml:"dsn"`
+	Table string `yaml:"table"`
+}
+
+// newInfluxSink writes one point per run to an InfluxDB write endpoint,
+// e.g. http://influx:8086/api/v2/write?org=eng&bucket=sloc
+func newInfluxSink(cfg influxConfig) reportSink {
+	return func(target string, files []fileLines, total fileLines) error {
+		line := fmt.Sprintf("sloc,target=%s files=%di,code=%di,comment=%di,whitespace=%di %d\n",
+			influxTagEscaper.Replace(target), len(files), total.codeLines, total.commentLines, total.whitespaceLines, time.Now().UnixNano())
+
+		req, err := http.NewRequest("POST", cfg.URL, strings.NewReader(line))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		if cfg.Token != "" {
+			req.Header.Set("Authorization", "Token "+cfg.Token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("influx write %s: %s", cfg.URL, resp.Status)
+		}
+		return nil
+	}
+}
+
+// prometheusMetrics renders the counts per directory and language in the
+// Prometheus text exposition format
+func prometheusMetrics(files []fileLines) string {
+	groups := map[[2]string]*fileLines{}
+	counts := map[[2]string]int{}
+	var keys [][2]string
+	for _, f := range files {
+		key := [2]string{filepath.Dir(f.filename), strings.ToLower(f.language)}
+		if groups[key] == nil {
+			groups[key] = &fileLines{}
+			keys = append(keys, key)
+		}
+		groups[key].join(f)
+		counts[key]++
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	labels := func(key [2]string) string {
+		return fmt.Sprintf(`directory="%s",language="%s"`, prometheusLabelEscaper.Replace(key[0]), prometheusLabelEscaper.Replace(key[1]))
+	}
+
+	var b strings.Builder
+	b.WriteString("# HELP sloc_lines Lines of source by directory, language and kind.\n# TYPE sloc_lines gauge\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "sloc_lines{%s,kind=\"code\"} %d\n", labels(key), groups[key].codeLines)
+		fmt.Fprintf(&b, "sloc_lines{%s,kind=\"comment\"} %d\n", labels(key), groups[key].commentLines)
+		fmt.Fprintf(&b, "sloc_lines{%s,kind=\"blank\"} %d\n", labels(key), groups[key].whitespaceLines)
+	}
+	b.WriteString("# HELP sloc_files Source files by directory and language.\n# TYPE sloc_files gauge\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "sloc_files{%s} %d\n", labels(key), counts[key])
+	}
+	return b.String()
+}
+
+// newPushgatewaySink replaces a target's metrics on a Prometheus Pushgateway
+// after each run, e.g. http://pushgateway:9091. Each target is its own group
+// so daemon repos don't overwrite each other.
+func newPushgatewaySink(gateway string) reportSink {
+	return func(target string, files []fileLines, total fileLines) error {
+		endpoint := strings.TrimSuffix(gateway, "/") + "/metrics/job/sloc/target@base64/" + base64.RawURLEncoding.EncodeToString([]byte(target))
+		req, err := http.NewRequest("PUT", endpoint, strings.NewReader(prometheusMetrics(files)))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("pushgateway %s: %s", endpoint, resp.Status)
+		}
+		return nil
+	}
+}
+
+// newPostgresSink appends one row per run to a Postgres (or Timescale) table,
+// creating it if needed
+func newPostgresSink(cfg postgresConfig) (reportSink, error) {
+	if cfg.Table == "" {
+		cfg.Table = "sloc_runs"
+	}
+	if !sqlIdentifier.MatchString(cfg.Table) {
+		return nil, fmt.Errorf("invalid postgres table name %q", cfg.Table)
+	}
+
+	db, err := sql.Open("postgres", cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS ` + cfg.Table + ` (
+		time       TIMESTAMPTZ NOT NULL,
+		target     TEXT NOT NULL,
+		files      INTEGER NOT NULL,
+		code       INTEGER NOT NULL,
+		comment    INTEGER NOT NULL,
+		whitespace INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	insert := `INSERT INTO ` + cfg.Table + ` (time, target, files, code, comment, whitespace) VALUES ($1, $2, $3, $4, $5, $6)`
+	return func(target string, files []fileLines, total fileLines) error {
+		_, err := db.Exec(insert, time.Now().UTC(), target, len(files), total.codeLines, total.commentLines, total.whitespaceLines)
+		return err
+	}, nil
+}
+
+// newSQLiteSink appends each run, with a row per file, to a local SQLite
+// database so trends can be queried with SQL
+func newSQLiteSink(path string) (reportSink, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS runs (
+			id         INTEGER PRIMARY KEY,
+			time       TIMESTAMP NOT NULL,
+			target     TEXT NOT NULL,
+			files      INTEGER NOT NULL,
+			code       INTEGER NOT NULL,
+			comment    INTEGER NOT NULL,
+			whitespace INTEGER NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS files (
+			run        INTEGER NOT NULL REFERENCES runs(id),
+			filename   TEXT NOT NULL,
+			code       INTEGER NOT NULL,
+			comment    INTEGER NOT NULL,
+			whitespace INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS files_filename ON files (filename)`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return func(target string, files []fileLines, total fileLines) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		res, err := tx.Exec(`INSERT INTO runs (time, target, files, code, comment, whitespace) VALUES (?, ?, ?, ?, ?, ?)`,
+			time.Now().UTC(), target, len(files), total.codeLines, total.commentLines, total.whitespaceLines)
+		if err != nil {
+			return err
+		}
+		run, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		insert, err := tx.Prepare(`INSERT INTO files (run, filename, code, comment, whitespace) VALUES (?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer insert.Close()
+		for _, f := range files {
+			if _, err := insert.Exec(run, f.filename, f.codeLines, f.commentLines, f.whitespaceLines); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/chriskirkland/go-utils/sloc"
+	"github.com/olekukonko/tablewriter"
+)
+
+// defaultTags are the technical debt markers --todo counts
+var defaultTags = []string{"TODO", "FIXME", "HACK", "XXX"}
+
+// tagPattern matches the --todo markers as words in comments, nil when
+// they aren't counted
+var tagPattern *regexp.Regexp
+
+// showTags follows the table report with the markers found
+var showTags bool
+
+// with --todo-list, where each marker is goes after the report
+var listTags bool
+
+// tagSite is a marker found in a comment
+type tagSite struct {
+	Line      int
+	Tag, Text string
+}
+
+// setTags counts the given markers in comments from now on
+func setTags(tags []string) error {
+	var quoted []string
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			quoted = append(quoted, regexp.QuoteMeta(tag))
+		}
+	}
+	if len(quoted) == 0 {
+		return fmt.Errorf("no tags to count")
+	}
+	pattern, err := regexp.Compile(`\b(` + strings.Join(quoted, "|") + `)\b`)
+	if err != nil {
+		return err
+	}
+	tagPattern = pattern
+	return nil
+}
+
+// addTags counts the markers in a comment line into f. On a line with code
+// too, only the text from the first comment delimiter on is searched, so
+// strings before it aren't taken for comments.
+func addTags(f *fileLines, lang *sloc.Language, kind sloc.LineKind, line int, raw []byte) {
+	start := lang.CommentStart(kind, raw)
+	for _, m := range tagPattern.FindAllSubmatchIndex(raw[start:], -1) {
+		m[0], m[2], m[3] = m[0]+start, m[2]+start, m[3]+start
+		tag := string(raw[m[2]:m[3]])
+		if f.tags == nil {
+			f.tags = map[string]int{}
+		}
+		f.tags[tag]++
+		if listTags {
+			f.tagSites = append(f.tagSites, tagSite{Line: line, Tag: tag, Text: strings.TrimSpace(string(raw[m[0]:]))})
+		}
+	}
+}
+
+// tagCount is the number of markers in f
+func tagCount(f fileLines) int {
+	n := 0
+	for _, count := range f.tags {
+		n += count
+	}
+	return n
+}
+
+// writeTags adds the markers found, by tag and where they are, to the table
+// report
+func writeTags(w io.Writer, files []fileLines, total fileLines) {
+	var tags []string
+	for tag := range total.tags {
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 {
+		return
+	}
+	sort.Strings(tags)
+	var counts []string
+	for _, tag := range tags {
+		counts = append(counts, fmt.Sprintf("%s %d", tag, total.tags[tag]))
+	}
+	fmt.Fprintf(w, "tags: %s\n", strings.Join(counts, ", "))
+	if !listTags {
+		return
+	}
+
+	fmt.Fprintln(w)
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Location", "Tag", "Comment"})
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, f := range files {
+		for _, site := range f.tagSites {
+			table.Append([]string{fmt.Sprintf("%s:%d", displayPath(f.filename), site.Line), site.Tag, site.Text})
+		}
+	}
+	table.Render()
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"gopkg.in/yaml.v3"
+)
+
+// tuiNode is a directory or file in the explorable tree, with stats summed
+// over everything below it
+type tuiNode struct {
+	name     string
+	path     string
+	dir      bool
+	files    int
+	stats    fileLines
+	children map[string]*tuiNode
+}
+
+// tuiSortKeys are cycled through with 's'; names sort ascending, counts
+// largest first
+var tuiSortKeys = []string{"code", "comment", "blank", "files", "name"}
+
+// tuiColumns can each be hidden, toggled with their number key
+var tuiColumns = []string{"code", "comment", "blank"}
+
+// tuiConfig is the layout kept between sessions in the user config dir
+type tuiConfig struct {
+	Sort    string   `yaml:"sort"`
+	Reverse bool     `yaml:"reverse,omitempty"`
+	Hidden  []string `yaml:"hidden,omitempty"`
+}
+
+func tuiConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sloc", "tui.yaml"), nil
+}
+
+func loadTUIConfig() tuiConfig {
+	cfg := tuiConfig{Sort: tuiSortKeys[0]}
+	path, err := tuiConfigPath()
+	if err != nil {
+		return cfg
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		log.Warningf("%s: %v", path, err)
+	}
+	return cfg
+}
+
+func (this tuiConfig) save() error {
+	path, err := tuiConfigPath()
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(this)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+type tui struct {
+	dir      string
+	files    []fileLines
+	root     *tuiNode
+	sortKey  int
+	reverse  bool
+	hidden   map[string]bool // columns toggled off
+	expanded map[string]bool
+
+	// narrow what's shown without rescanning
+	filter        string
+	hideTests     bool
+	hideGenerated bool
+	languages     []string // those counted, cycled through with 'l'
+	language      int      // 0 for all, else 1 past its index in languages
+
+	app     *tview.Application
+	tree    *tview.TreeView
+	details *tview.TextView
+	search  *tview.InputField
+	status  *tview.TextView
+	message string // result of the last action, shown in the status line
+}
+
+func runTUI(args []string) error {
+	flags := flag.NewFlagSet("tui", flag.ContinueOnError)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	root := "."
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+	if err := validateTargets([]string{root}); err != nil {
+		return err
+	}
+
+	files, _ := countTree(root)
+	cfg := loadTUIConfig()
+	this := &tui{
+		dir:      root,
+		files:    files,
+		sortKey:  max(slices.Index(tuiSortKeys, cfg.Sort), 0),
+		reverse:  cfg.Reverse,
+		hidden:   map[string]bool{},
+		expanded: map[string]bool{root: true},
+		app:      tview.NewApplication(),
+		tree:     tview.NewTreeView(),
+		details:  tview.NewTextView(),
+		search:   tview.NewInputField().SetLabel(" / "),
+		status:   tview.NewTextView(),
+	}
+	this.hideGenerated = !includeGenerated
+	for _, f := range files {
+		if f.language != "" && !slices.Contains(this.languages, f.language) {
+			this.languages = append(this.languages, f.language)
+		}
+	}
+	sort.Strings(this.languages)
+	for _, column := range cfg.Hidden {
+		this.hidden[column] = true
+	}
+	this.search.SetChangedFunc(func(text string) {
+		this.filter = text
+		this.redraw()
+	})
+	this.search.SetDoneFunc(func(key tcell.Key) {
+		// escape drops the filter, enter keeps it
+		if key == tcell.KeyEscape {
+			this.search.SetText("")
+		}
+		this.app.SetFocus(this.tree)
+	})
+	this.details.SetBorder(true).SetTitle(" details ")
+	this.tree.SetBorder(true).SetTitle(" " + root + " ")
+	this.tree.SetChangedFunc(func(node *tview.TreeNode) {
+		this.showDetails(node.GetReference().(*tuiNode))
+	})
+	this.tree.SetSelectedFunc(func(node *tview.TreeNode) {
+		n := node.GetReference().(*tuiNode)
+		if n.dir {
+			node.SetExpanded(!node.IsExpanded())
+			this.expanded[n.path] = node.IsExpanded()
+		}
+	})
+	this.app.SetInputCapture(this.keys)
+	this.redraw()
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(tview.NewFlex().
+			AddItem(this.tree, 0, 2, true).
+			AddItem(this.details, 0, 1, false), 0, 1, true).
+		AddItem(this.search, 1, 0, false).
+		AddItem(this.status, 1, 0, false)
+	return this.app.SetRoot(layout, true).Run()
+}
+
+// buildTree arranges counted files under root by directory
+func buildTree(root string, files []fileLines) *tuiNode {
+	top := &tuiNode{name: root, path: root, dir: true, children: map[string]*tuiNode{}}
+	for _, f := range files {
+		rel, err := filepath.Rel(root, f.filename)
+		if err != nil {
+			rel = f.filename
+		}
+
+		node := top
+		parts := strings.Split(rel, string(filepath.Separator))
+		for i, part := range parts {
+			node.files++
+			node.stats.join(f)
+
+			child := node.children[part]
+			if child == nil {
+				child = &tuiNode{name: part, path: filepath.Join(node.path, part), children: map[string]*tuiNode{}}
+				child.dir = i < len(parts)-1
+				node.children[part] = child
+			}
+			node = child
+		}
+		node.files++
+		node.stats = f
+	}
+	return top
+}
+
+// visible reports whether a file passes the filter and the toggles; the
+// filter is a glob if it has any glob characters, otherwise a substring
+func (this *tui) visible(f fileLines) bool {
+	if this.hideTests && isTestFile(f.filename) {
+		return false
+	}
+	if this.hideGenerated && f.generated {
+		return false
+	}
+	if this.language > 0 && f.language != this.languages[this.language-1] {
+		return false
+	}
+	if this.filter == "" {
+		return true
+	}
+
+	rel, err := filepath.Rel(this.dir, f.filename)
+	if err != nil {
+		rel = f.filename
+	}
+	if strings.ContainsAny(this.filter, "*?[") {
+		if ok, _ := filepath.Match(this.filter, rel); ok {
+			return true
+		}
+		ok, _ := filepath.Match(this.filter, filepath.Base(rel))
+		return ok
+	}
+	return strings.Contains(rel, this.filter)
+}
+
+// sorted orders children by key, or the other way round if reverse
+func (this *tuiNode) sorted(key string, reverse bool) []*tuiNode {
+	var res []*tuiNode
+	for _, c := range this.children {
+		res = append(res, c)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		var x, y int
+		switch key {
+		case "code":
+			x, y = a.stats.codeLines, b.stats.codeLines
+		case "comment":
+			x, y = a.stats.commentLines, b.stats.commentLines
+		case "blank":
+			x, y = a.stats.whitespaceLines, b.stats.whitespaceLines
+		case "files":
+			x, y = a.files, b.files
+		}
+		if x != y {
+			return (x > y) != reverse
+		}
+		return (a.name < b.name) != reverse
+	})
+	return res
+}
+
+func (this *tui) label(n *tuiNode) string {
+	name := displayPath(n.name)
+	if n.dir {
+		name += string(filepath.Separator)
+	}
+
+	res := fmt.Sprintf("%-32s", name)
+	values := map[string]int{"code": n.stats.codeLines, "comment": n.stats.commentLines, "blank": n.stats.whitespaceLines}
+	for _, column := range tuiColumns {
+		if !this.hidden[column] {
+			res += fmt.Sprintf(" %8d %s", values[column], column)
+		}
+	}
+	return res
+}
+
+// saveConfig keeps the current layout for the next session
+func (this *tui) saveConfig() {
+	cfg := tuiConfig{Sort: tuiSortKeys[this.sortKey], Reverse: this.reverse}
+	for _, column := range tuiColumns {
+		if this.hidden[column] {
+			cfg.Hidden = append(cfg.Hidden, column)
+		}
+	}
+	if err := cfg.save(); err != nil {
+		this.message = "layout not saved: " + err.Error()
+	}
+}
+
+func (this *tui) keys(ev *tcell.EventKey) *tcell.EventKey {
+	// let the search box have its keys
+	if this.app.GetFocus() == this.search {
+		return ev
+	}
+
+	switch ev.Rune() {
+	case '/':
+		this.app.SetFocus(this.search)
+		return nil
+	case 't':
+		this.hideTests = !this.hideTests
+		this.redraw()
+		return nil
+	case 'g':
+		this.hideGenerated = !this.hideGenerated
+		this.redraw()
+		return nil
+	case 'l':
+		this.language = (this.language + 1) % (len(this.languages) + 1)
+		this.redraw()
+		return nil
+	case 'e', 'E':
+		name := "sloc-view.md"
+		if ev.Rune() == 'E' {
+			name = "sloc-view.html"
+		}
+		if err := this.export(name); err != nil {
+			this.message = err.Error()
+		} else {
+			this.message = "exported to " + name
+		}
+		this.redraw()
+		return nil
+	case 'q':
+		this.app.Stop()
+		return nil
+	case 's':
+		this.sortKey = (this.sortKey + 1) % len(tuiSortKeys)
+		this.saveConfig()
+		this.redraw()
+		return nil
+	case 'r':
+		this.reverse = !this.reverse
+		this.saveConfig()
+		this.redraw()
+		return nil
+	case '1', '2', '3':
+		column := tuiColumns[ev.Rune()-'1']
+		this.hidden[column] = !this.hidden[column]
+		this.saveConfig()
+		this.redraw()
+		return nil
+	}
+	return ev
+}
+
+// redraw rebuilds the tree from the visible files in the current order,
+// keeping what was expanded and selected
+func (this *tui) redraw() {
+	var files []fileLines
+	for _, f := range this.files {
+		if this.visible(f) {
+			files = append(files, f)
+		}
+	}
+	this.root = buildTree(this.dir, files)
+
+	var selected string
+	if cur := this.tree.GetCurrentNode(); cur != nil {
+		selected = cur.GetReference().(*tuiNode).path
+	}
+
+	var current *tview.TreeNode
+	var add func(n *tuiNode) *tview.TreeNode
+	add = func(n *tuiNode) *tview.TreeNode {
+		node := tview.NewTreeNode(this.label(n)).SetReference(n)
+		if n.dir {
+			node.SetColor(tcell.ColorTurquoise)
+			// show every match while filtering
+			node.SetExpanded(this.expanded[n.path] || this.filter != "")
+			for _, c := range n.sorted(tuiSortKeys[this.sortKey], this.reverse) {
+				node.AddChild(add(c))
+			}
+		}
+		if n.path == selected {
+			current = node
+		}
+		return node
+	}
+
+	root := add(this.root)
+	if current == nil {
+		current = root
+	}
+	this.tree.SetRoot(root).SetCurrentNode(current)
+	this.showDetails(current.GetReference().(*tuiNode))
+	toggle := func(hidden bool) string {
+		if hidden {
+			return "hidden"
+		}
+		return "shown"
+	}
+	order := ""
+	if this.reverse {
+		order = ", reversed"
+	}
+	language := "all"
+	if this.language > 0 {
+		language = this.languages[this.language-1]
+	}
+	status := fmt.Sprintf(" sort: %s%s (s/r)   columns: 1-3   language: %s (l)   tests: %s (t)   generated: %s (g)   /: filter   e/E: export md/html   q: quit",
+		tuiSortKeys[this.sortKey], order, language, toggle(this.hideTests), toggle(this.hideGenerated))
+	if this.message != "" {
+		status += "   | " + this.message
+		this.message = ""
+	}
+	this.status.SetText(status)
+}
+
+func (this *tui) showDetails(n *tuiNode) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", displayPath(n.path))
+	if n.dir {
+		fmt.Fprintf(&b, "files    %d\n", n.files)
+	}
+	fmt.Fprintf(&b, "code     %d\ncomment  %d\nblank    %d\n", n.stats.codeLines, n.stats.commentLines, n.stats.whitespaceLines)
+	if lines := n.stats.codeLines + n.stats.commentLines; lines > 0 {
+		fmt.Fprintf(&b, "density  %.1f%% comments\n", 100*ratio(n.stats.commentLines, lines))
+	}
+	if !n.dir {
+		encoding := n.stats.encoding
+		if encoding == "" {
+			encoding = "UTF-8"
+		}
+		fmt.Fprintf(&b, "\nlanguage %s\nencoding %s\n", n.stats.language, encoding)
+		if n.stats.lineEnding != "" {
+			fmt.Fprintf(&b, "endings  %s\n", n.stats.lineEnding)
+		}
+	}
+	this.details.SetText(b.String())
+}
+
+// export writes the rows currently on screen, in their order, as Markdown or
+// as HTML when name ends in .html
+func (this *tui) export(name string) error {
+	var rows []*tuiNode
+	this.tree.GetRoot().Walk(func(node, parent *tview.TreeNode) bool {
+		rows = append(rows, node.GetReference().(*tuiNode))
+		return node.IsExpanded()
+	})
+
+	var b strings.Builder
+	if strings.HasSuffix(name, ".html") {
+		b.WriteString("<table>\n<tr><th>Path</th><th>Files</th><th>Code</th><th>Comment</th><th>Blank</th></tr>\n")
+		for _, n := range rows {
+			fmt.Fprintf(&b, "<tr><td>%s</td><td>%d</td><td>%d</td><td>%d</td><td>%d</td></tr>\n",
+				html.EscapeString(displayPath(n.path)), n.files, n.stats.codeLines, n.stats.commentLines, n.stats.whitespaceLines)
+		}
+		b.WriteString("</table>\n")
+	} else {
+		b.WriteString("| Path | Files | Code | Comment | Blank |\n|---|---:|---:|---:|---:|\n")
+		for _, n := range rows {
+			fmt.Fprintf(&b, "| %s | %d | %d | %d | %d |\n",
+				strings.ReplaceAll(displayPath(n.path), "|", "\\|"), n.files, n.stats.codeLines, n.stats.commentLines, n.stats.whitespaceLines)
+		}
+	}
+	if this.filter != "" || this.hideTests || this.hideGenerated {
+		fmt.Fprintf(&b, "\nfilter %q, tests hidden: %v, generated hidden: %v\n", this.filter, this.hideTests, this.hideGenerated)
+	}
+	return os.WriteFile(name, []byte(b.String()), 0644)
+}
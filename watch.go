@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchHistory is how many redraws of totals the sparklines cover
+const watchHistory = 60
+
+// clearScreen moves the cursor home and clears the terminal so each redraw
+// replaces the last
+const clearScreen = "\033[H\033[2J"
+
+type watcher struct {
+	roots    []string
+	filters  []*treeFilter // one per root, judging what changes under it
+	byDir    bool
+	plain    bool
+	debounce time.Duration
+	budgets  budgetList
+
+	// snapshots feed the sinks a history of the session
+	sinks         []reportSink
+	snapshotEvery time.Duration
+	snapshotDelta int
+	snapshotLines int // total lines at the last snapshot
+	out           io.Writer
+
+	fs      *fsnotify.Watcher
+	files   map[string]fileLines
+	pending map[string]bool
+	updated time.Time
+	history []watchSample // totals at each redraw, oldest first
+	deltas  []string      // per-file changes found by the last recount
+
+	// with -listen, the counts as of the last recount, read by the server
+	listen string
+	mu     sync.Mutex
+	report *countReport
+}
+
+type watchSample struct {
+	files int
+	total fileLines
+}
+
+func (this watchSample) get(metric string) int {
+	switch metric {
+	case "files":
+		return this.files
+	case "code":
+		return this.total.codeLines
+	case "comment":
+		return this.total.commentLines
+	}
+	return this.total.whitespaceLines
+}
+
+var budgetMetrics = []string{"blank", "code", "comment", "files"}
+
+// budget caps a watched total; crossing it fires a desktop notification
+type budget struct {
+	metric string
+	limit  int
+}
+
+// budgetList is a repeatable metric=limit flag
+type budgetList []budget
+
+func (this *budgetList) String() string {
+	var res []string
+	for _, b := range *this {
+		res = append(res, fmt.Sprintf("%s=%d", b.metric, b.limit))
+	}
+	return strings.Join(res, ",")
+}
+
+func (this *budgetList) Set(value string) error {
+	metric, limit, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("want metric=limit, e.g. code=5000")
+	}
+	if !slices.Contains(budgetMetrics, metric) {
+		return fmt.Errorf("unknown metric %q, must be one of %s", metric, strings.Join(budgetMetrics, ", "))
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil {
+		return fmt.Errorf("limit %q is not a number", limit)
+	}
+	*this = append(*this, budget{metric: metric, limit: n})
+	return nil
+}
+
+func runWatch(ctx context.Context, args []string, sinks []reportSink) error {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	byDir := flags.Bool("by-dir", false, "show a per-directory table under the totals")
+	plain := flags.Bool("plain", false, "print changes and totals as lines instead of redrawing the screen")
+	debounce := flags.Duration("debounce", 200*time.Millisecond, "how long to wait for more changes before recounting")
+	snapshotEvery := flags.Duration("snapshot-every", 0, "send a snapshot to the configured sinks this often (0 to disable)")
+	snapshotDelta := flags.Int("snapshot-delta", 0, "send a snapshot to the configured sinks once the line count moves this far (0 to disable)")
+	listen := flags.String("listen", "", "also serve the counts as JSON on this address, e.g. :8080")
+	var budgets budgetList
+	flags.Var(&budgets, "budget", "notify when a total goes over metric=limit, for "+strings.Join(budgetMetrics, ", ")+" (repeatable)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	roots := flags.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	if err := validateTargets(roots); err != nil {
+		return err
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer fsw.Close()
+
+	this := &watcher{
+		roots:   roots,
+		byDir:   *byDir,
+		plain:   *plain,
+		budgets: budgets,
+
+		sinks:         sinks,
+		snapshotEvery: *snapshotEvery,
+		snapshotDelta: *snapshotDelta,
+		debounce:      *debounce,
+		listen:        *listen,
+		out:           os.Stdout,
+		fs:            fsw,
+		files:         map[string]fileLines{},
+		pending:       map[string]bool{},
+	}
+	for _, root := range roots {
+		filter := newTreeFilter(root)
+		this.filters = append(this.filters, filter)
+		this.addTree(filter, root)
+	}
+	if (this.snapshotEvery > 0 || this.snapshotDelta > 0) && len(sinks) == 0 {
+		log.Warning("snapshots need a sink such as --postgres-dsn or --influx-url")
+	}
+	this.flush()
+	this.snapshot()
+	if this.listen != "" {
+		mux := http.NewServeMux()
+		registerRoutes(mux, "sloc watch", []apiRoute{
+			{method: "GET", path: "/counts", summary: "the counts as of the last recount", response: countReport{}, handler: this.handleCounts},
+		})
+		server := &http.Server{Addr: this.listen, Handler: mux}
+		go func() {
+			if err := server.ListenAndServe(); err != http.ErrServerClosed {
+				log.Error(err)
+			}
+		}()
+		defer server.Close()
+	}
+	return this.loop(ctx)
+}
+
+func (this *watcher) handleCounts(w http.ResponseWriter, r *http.Request) {
+	this.mu.Lock()
+	report := this.report
+	this.mu.Unlock()
+	writeJSON(w, r, report)
+}
+
+// addTree counts everything under dir, a root or a directory created below
+// one, and watches its directories, as fsnotify only reports changes to a
+// directory's immediate entries
+func (this *watcher) addTree(filter *treeFilter, dir string) {
+	filter.walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if err := this.fs.Add(path); err != nil {
+				log.Warningf("not watching %s: %v", path, err)
+			}
+			return nil
+		}
+		if isSourceFile(path) || isScript(path, info) {
+			this.pending[path] = true
+		}
+		return nil
+	})
+}
+
+func (this *watcher) loop(ctx context.Context) error {
+	timer := time.NewTimer(this.debounce)
+	timer.Stop()
+	var snapshots <-chan time.Time
+	if this.snapshotEvery > 0 {
+		ticker := time.NewTicker(this.snapshotEvery)
+		defer ticker.Stop()
+		snapshots = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-this.fs.Errors:
+			log.Warning(err)
+		case ev := <-this.fs.Events:
+			log.Debug("watch", ev)
+			filter := this.filterFor(ev.Name)
+			if ev.Has(fsnotify.Create) && filter != nil {
+				// walked from its root, so the root's filters and depth hold
+				if dir, _ := isDirectory(ev.Name); dir {
+					this.addTree(filter, ev.Name)
+				}
+			}
+			if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+				this.forgetTree(ev.Name)
+			}
+			// extensionless files may be scripts, flush checks; counted files
+			// are recounted whatever happened to them, so removals drop out
+			_, counted := this.files[ev.Name]
+			if counted || (isSourceFile(ev.Name) || filepath.Ext(ev.Name) == "") && this.walkCounts(filter, ev.Name) {
+				this.pending[ev.Name] = true
+			}
+			// editors save in bursts; recount once they settle
+			timer.Reset(this.debounce)
+		case <-timer.C:
+			this.flush()
+			if this.snapshotDelta > 0 && abs(this.lines()-this.snapshotLines) >= this.snapshotDelta {
+				this.snapshot()
+			}
+		case <-snapshots:
+			this.snapshot()
+		}
+	}
+}
+
+// filterFor finds the filter of the root a changed path is below, the
+// innermost if roots overlap
+func (this *watcher) filterFor(path string) *treeFilter {
+	var res *treeFilter
+	for _, filter := range this.filters {
+		if filter.contains(path) && (res == nil || len(filter.root) > len(res.root)) {
+			res = filter
+		}
+	}
+	return res
+}
+
+// walkCounts reports whether a walk from the root would reach path, which
+// must still exist
+func (this *watcher) walkCounts(filter *treeFilter, path string) bool {
+	if filter == nil {
+		return false
+	}
+	info, err := os.Lstat(path)
+	return err == nil && !filter.skips(path, info)
+}
+
+// forgetTree drops files under a removed or renamed directory
+func (this *watcher) forgetTree(path string) {
+	prefix := path + string(filepath.Separator)
+	for name := range this.files {
+		if strings.HasPrefix(name, prefix) {
+			this.pending[name] = true
+		}
+	}
+}
+
+// flush recounts the changed files and redraws
+func (this *watcher) flush() {
+	initial := this.updated.IsZero()
+	this.deltas = nil
+
+	var paths []string
+	for path := range this.pending {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	clear(this.pending)
+
+	for _, path := range paths {
+		old, existed := this.files[path]
+		delete(this.files, path)
+
+		info, err := os.Lstat(path)
+		if err == nil && isRegularFile(path, info) && (isSourceFile(path) || isScript(path, info)) {
+			stats, err := getFileStats(path)
+			if err != nil {
+				log.Warningf("skipping %v", err)
+			} else if !stats.generated || includeGenerated {
+				this.files[path] = stats
+			}
+		}
+
+		cur, exists := this.files[path]
+		if delta := watchDelta(path, old, cur, existed, exists); delta != "" && !initial {
+			this.deltas = append(this.deltas, delta)
+		}
+	}
+	this.updated = time.Now()
+	if this.listen != "" {
+		this.publish()
+	}
+	this.draw()
+}
+
+// publish swaps in the counts the server hands out
+func (this *watcher) publish() {
+	var paths []string
+	for path := range this.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	total := fileLines{filename: "TOTAL"}
+	report := &countReport{Target: strings.Join(this.roots, " "), Time: this.updated.UTC(), Files: []jsonLines{}}
+	for _, path := range paths {
+		total.join(this.files[path])
+		report.Files = append(report.Files, this.files[path].toJSON())
+	}
+	report.Total = total.toJSON()
+
+	this.mu.Lock()
+	this.report = report
+	this.mu.Unlock()
+}
+
+func (this *watcher) lines() int {
+	total := 0
+	for _, f := range this.files {
+		total += f.codeLines + f.commentLines + f.whitespaceLines
+	}
+	return total
+}
+
+// snapshot hands the current counts to the sinks as if from a full run
+func (this *watcher) snapshot() {
+	if len(this.sinks) == 0 {
+		return
+	}
+
+	total := fileLines{filename: "TOTAL"}
+	var files []fileLines
+	for _, f := range this.files {
+		total.join(f)
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].filename < files[j].filename })
+
+	for _, sink := range this.sinks {
+		if err := sink(strings.Join(this.roots, " "), files, total); err != nil {
+			log.Error(err)
+		}
+	}
+	this.snapshotLines = this.lines()
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// watchDelta describes how a file's counts changed, or "" if they didn't
+func watchDelta(path string, old, cur fileLines, existed, exists bool) string {
+	switch {
+	case !existed && !exists:
+		return ""
+	case !exists:
+		return fmt.Sprintf("%s: removed (code %+d, comments %+d, blank %+d)", displayPath(path), -old.codeLines, -old.commentLines, -old.whitespaceLines)
+	case !existed:
+		return fmt.Sprintf("%s: new (code %+d, comments %+d, blank %+d)", displayPath(path), cur.codeLines, cur.commentLines, cur.whitespaceLines)
+	}
+
+	code, comments, blank := cur.codeLines-old.codeLines, cur.commentLines-old.commentLines, cur.whitespaceLines-old.whitespaceLines
+	if code == 0 && comments == 0 && blank == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s: code %+d, comments %+d, blank %+d", displayPath(path), code, comments, blank)
+}
+
+func (this *watcher) draw() {
+	total := fileLines{filename: "TOTAL"}
+	dirs := map[string]*fileLines{}
+	for path, f := range this.files {
+		total.join(f)
+		dir := filepath.Dir(path)
+		if dirs[dir] == nil {
+			dirs[dir] = &fileLines{filename: dir}
+		}
+		dirs[dir].join(f)
+	}
+
+	this.history = append(this.history, watchSample{files: len(this.files), total: total})
+	if len(this.history) > watchHistory {
+		this.history = this.history[len(this.history)-watchHistory:]
+	}
+	this.checkBudgets()
+	series := func(value func(watchSample) int) []int {
+		var res []int
+		for _, s := range this.history {
+			res = append(res, value(s))
+		}
+		return res
+	}
+
+	// a scrolling terminal only gets the changes and a line of totals
+	if this.plain {
+		for _, delta := range this.deltas {
+			fmt.Fprintf(this.out, "%s %s\n", this.updated.Format("15:04:05"), delta)
+		}
+		if len(this.deltas) > 0 || len(this.history) == 1 {
+			fmt.Fprintf(this.out, "%s total: %d files, code %d, comments %d, blank %d\n", this.updated.Format("15:04:05"), len(this.files), total.codeLines, total.commentLines, total.whitespaceLines)
+		}
+		return
+	}
+
+	fmt.Fprint(this.out, clearScreen)
+	fmt.Fprintf(this.out, "watching %s, updated %s (Ctrl-C to quit)\n\n", strings.Join(this.roots, " "), this.updated.Format("15:04:05"))
+	fmt.Fprintf(this.out, "files    %-10d %s\n", len(this.files), sparkline(series(func(s watchSample) int { return s.files })))
+	fmt.Fprintf(this.out, "code     %-10d %s\n", total.codeLines, sparkline(series(func(s watchSample) int { return s.total.codeLines })))
+	fmt.Fprintf(this.out, "comment  %-10d %s\n", total.commentLines, sparkline(series(func(s watchSample) int { return s.total.commentLines })))
+	fmt.Fprintf(this.out, "blank    %-10d %s\n", total.whitespaceLines, sparkline(series(func(s watchSample) int { return s.total.whitespaceLines })))
+
+	if len(this.deltas) > 0 {
+		fmt.Fprintln(this.out)
+		for _, delta := range this.deltas {
+			fmt.Fprintln(this.out, delta)
+		}
+	}
+
+	if this.byDir {
+		var rows []fileLines
+		for _, d := range dirs {
+			rows = append(rows, *d)
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i].filename < rows[j].filename })
+		renderTable(this.out, rows, total)
+	}
+}
+
+// checkBudgets notifies when the last recount took a total over its budget
+func (this *watcher) checkBudgets() {
+	if len(this.history) < 2 {
+		return
+	}
+	prev, cur := this.history[len(this.history)-2], this.history[len(this.history)-1]
+	for _, b := range this.budgets {
+		if prev.get(b.metric) <= b.limit && cur.get(b.metric) > b.limit {
+			msg := fmt.Sprintf("%s is now %d, over the budget of %d", b.metric, cur.get(b.metric), b.limit)
+			this.deltas = append(this.deltas, "budget exceeded: "+msg)
+			if err := desktopNotify("sloc: "+b.metric+" budget exceeded", msg); err != nil {
+				log.Warning(err)
+			}
+		}
+	}
+}
+
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline scales values between their own min and max, so small changes
+// in a large codebase still show
+func sparkline(values []int) string {
+	if len(values) < 2 {
+		return ""
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+
+	res := make([]rune, len(values))
+	for i, v := range values {
+		bar := 0
+		if hi > lo {
+			bar = (v - lo) * (len(sparkBars) - 1) / (hi - lo)
+		}
+		res[i] = sparkBars[bar]
+	}
+	return string(res)
+}
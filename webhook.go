@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// newWebhookSink posts the JSON report to url after each run, as
+// --format json writes it. Failed requests and 5xx or 429 responses are
+// retried with a doubling delay.
+func newWebhookSink(url, auth string, retries int) reportSink {
+	return func(target string, files []fileLines, total fileLines) error {
+		var body bytes.Buffer
+		if err := renderJSON(&body, files, total); err != nil {
+			return err
+		}
+
+		delay := time.Second
+		for attempt := 0; ; attempt++ {
+			err := postReport(url, auth, target, body.Bytes())
+			if err == nil || attempt >= retries {
+				return err
+			}
+			if _, ok := err.(permanentError); ok {
+				return err
+			}
+			log.Warningf("%v, retrying in %s", err, delay)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+// permanentError is a response that retrying won't change
+type permanentError struct{ error }
+
+func postReport(url, auth, target string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sloc-Target", target)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("post %s: %s", url, resp.Status)
+	case resp.StatusCode >= 300:
+		return permanentError{fmt.Errorf("post %s: %s", url, resp.Status)}
+	}
+	return nil
+}